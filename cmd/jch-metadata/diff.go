@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"jch-metadata/internal/document"
+	"jch-metadata/internal/parser"
+	"os"
+	"path/filepath"
+)
+
+// diffSource returns the file compared with fileName by the diff action.  In directory mode, files are matched by
+// their path relative to the directories.
+func diffSource(fileName string) (string, error) {
+	if fileName == inputFilename || inputFilename == stdinFilename {
+		return fromFilename, nil
+	}
+	rel, err := filepath.Rel(inputFilename, fileName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(fromFilename, rel), nil
+}
+
+// diffFile returns the differences between the metadata of the file matching input and the metadata of input.  Both
+// files must be parsed by the same parser.  It returns nil if the format of input is not supported.
+func diffFile(input *parser.Input) (*document.Document, error) {
+	sourceName, err := diffSource(input.Name)
+	if err != nil {
+		return nil, err
+	}
+	after, err := parser.StartParsing(parsers, input, parser.ShowAction, parser.Options{OutputDir: "output", MaxNesting: maxNesting})
+	if err != nil || after == nil {
+		return nil, err
+	}
+	if _, err = os.Stat(sourceName); os.IsNotExist(err) {
+		return nil, fmt.Errorf("no matching file %s", sourceName)
+	}
+	file, source, err := openFile(sourceName, parser.ShowAction)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFile(file)
+	before, err := parser.StartParsing(parsers, source, parser.ShowAction, parser.Options{OutputDir: "output", MaxNesting: maxNesting})
+	if err != nil {
+		return nil, fmt.Errorf("error handling %s: %w", sourceName, err)
+	}
+	if before == nil {
+		return nil, fmt.Errorf("format of %s is not supported", sourceName)
+	}
+	if before.Format != after.Format {
+		return nil, fmt.Errorf("%s is %s but %s is %s", sourceName, before.Format, input.Name, after.Format)
+	}
+	return document.Diff(before, after), nil
+}
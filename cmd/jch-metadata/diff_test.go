@@ -0,0 +1,100 @@
+package main
+
+import (
+	"jch-metadata/internal/document"
+	"jch-metadata/internal/output"
+	"jch-metadata/internal/parser"
+	"jch-metadata/pkg/metadata"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes files under a temporary directory, keyed by their slash separated path.
+func writeFiles(t *testing.T, files map[string][]byte) string {
+	root := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Error creating directory: %s", err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Error writing file: %s", err)
+		}
+	}
+	return root
+}
+
+func diffPath(t *testing.T, path string) (*document.Document, error) {
+	file, input, err := openFile(path, parser.DiffAction)
+	if err != nil {
+		t.Fatalf("Error opening file: %s", err)
+	}
+	defer closeFile(file)
+	return diffFile(input)
+}
+
+func TestDiffDirectories(t *testing.T) {
+	data, err := os.ReadFile("../../internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	buffer := metadata.NewBuffer("test1.png", data)
+	_, err = metadata.Set(buffer, metadata.FieldValue{Key: "Software", Value: "GIMP"})
+	if err != nil {
+		t.Fatalf("Error setting metadata: %s", err)
+	}
+	before := writeFiles(t, map[string][]byte{"same.png": data, "sub/changed.png": data, "removed.png": data})
+	after := writeFiles(t, map[string][]byte{"same.png": data, "sub/changed.png": buffer.Bytes(), "added.png": data})
+	input, from := inputFilename, fromFilename
+	inputFilename, fromFilename = after, before
+	defer func() { inputFilename, fromFilename = input, from }()
+
+	for _, name := range []string{"same.png", filepath.Join("sub", "changed.png")} {
+		source, err := diffSource(filepath.Join(after, name))
+		if err != nil || source != filepath.Join(before, name) {
+			t.Fatalf("Expected %s to be compared with %s but received %s, %v", name, filepath.Join(before, name), source, err)
+		}
+	}
+
+	doc, err := diffPath(t, filepath.Join(after, "same.png"))
+	if err != nil {
+		t.Fatalf("Error comparing files: %s", err)
+	}
+	if len(doc.Sections) != 0 || len(doc.Messages) != 1 || doc.Messages[0] != "No differences found" {
+		t.Fatalf("Identical files shouldn't differ: %v, %v", doc.Sections, doc.Messages)
+	}
+	doc, err = diffPath(t, filepath.Join(after, "sub", "changed.png"))
+	if err != nil {
+		t.Fatalf("Error comparing files: %s", err)
+	}
+	if len(doc.Sections) != 1 || doc.Sections[0].FindField("field").Value != "text.Software" {
+		t.Fatalf("Expected the Software field to differ: %v", doc.Sections)
+	}
+	_, err = diffPath(t, filepath.Join(after, "added.png"))
+	if err == nil || !strings.Contains(err.Error(), "no matching file") {
+		t.Fatalf("File missing from the source directory should fail: %v", err)
+	}
+
+	// Only the files of the input directory are compared, so removed.png is ignored
+	report, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatalf("Error creating file: %s", err)
+	}
+	defer report.Close()
+	stdout, count, format := os.Stdout, workers, outputFormat
+	os.Stdout, workers, outputFormat = report, 2, output.TextFormat
+	ok := parseDirectory(after, parser.DiffAction)
+	os.Stdout, workers, outputFormat = stdout, count, format
+	if ok {
+		t.Fatalf("Comparing directories with a missing file should fail")
+	}
+	printed, err := os.ReadFile(report.Name())
+	if err != nil {
+		t.Fatalf("Error reading output: %s", err)
+	}
+	if !strings.Contains(string(printed), "Failed to process 1 files") || strings.Contains(string(printed), "removed.png") {
+		t.Fatalf("Only added.png should fail:\n%s", printed)
+	}
+}
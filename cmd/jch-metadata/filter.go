@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// patternList is a flag that can be repeated or contain comma separated values.
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if _, err := filepath.Match(v, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %w", v, err)
+		}
+		*p = append(*p, v)
+	}
+	return nil
+}
+
+// sizeValue is a flag for a file size in bytes with an optional K, M or G suffix.
+type sizeValue int64
+
+func (s *sizeValue) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *sizeValue) Set(value string) error {
+	if value == "" {
+		return fmt.Errorf("invalid size: %s", value)
+	}
+	multiplier := int64(1)
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid size: %s", value)
+	}
+	*s = sizeValue(size * multiplier)
+	return nil
+}
+
+// fileFilter selects the files processed in directory mode.
+type fileFilter struct {
+	include        patternList
+	exclude        patternList
+	formats        patternList
+	maxDepth       int
+	followSymlinks bool
+	minSize        sizeValue
+	maxSize        sizeValue
+}
+
+func matchPatterns(patterns []string, relPath string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, filepath.Base(relPath)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, filepath.ToSlash(relPath)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptFormat returns true if name, the name of a parser, is selected by the formats filter.  Formats can be
+// written in any case and without the description in parentheses, such as "mkv" for "Mkv (Matroska)".
+func (f *fileFilter) acceptFormat(name string) bool {
+	if len(f.formats) == 0 {
+		return true
+	}
+	shortName := strings.Fields(name)[0]
+	for _, format := range f.formats {
+		if strings.EqualFold(format, name) || strings.EqualFold(format, shortName) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fileFilter) acceptDir(relPath string, depth int) bool {
+	if matchPatterns(f.exclude, relPath) {
+		return false
+	}
+	return f.maxDepth < 0 || depth < f.maxDepth
+}
+
+// acceptParents returns true if walk enters every directory containing relPath, so files found by other means are
+// filtered the same way.
+func (f *fileFilter) acceptParents(relPath string) bool {
+	for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+		if !f.acceptDir(dir, strings.Count(dir, string(filepath.Separator))+1) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fileFilter) acceptFile(relPath string, size int64) bool {
+	if len(f.include) > 0 && !matchPatterns(f.include, relPath) {
+		return false
+	}
+	if matchPatterns(f.exclude, relPath) {
+		return false
+	}
+	if size < int64(f.minSize) {
+		return false
+	}
+	return f.maxSize == 0 || size <= int64(f.maxSize)
+}
+
+// walk calls visit for every file under root accepted by the filter.  Symbolic links to files and directories are
+// only followed if followSymlinks is set, in which case directories that were already visited are skipped.
+func (f *fileFilter) walk(root string, visit func(path string)) error {
+	visited := make(map[string]bool)
+	return f.walkDir(root, root, visited, visit)
+}
+
+func (f *fileFilter) walkDir(root string, dir string, visited map[string]bool, visit func(path string)) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	visited[realDir] = true
+	if dir != root {
+		// WalkDir only lists the target of a symbolic link if the path ends with a separator
+		dir += string(filepath.Separator)
+	}
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Encountered error while processing file: %s\n", err)
+			return nil
+		}
+		if path == dir {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		depth := strings.Count(relPath, string(filepath.Separator)) + 1
+		if d.IsDir() {
+			if !f.acceptDir(relPath, depth) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		var fileInfo fs.FileInfo
+		if d.Type()&fs.ModeSymlink != 0 {
+			if !f.followSymlinks {
+				return nil
+			}
+			fileInfo, err = os.Stat(path)
+		} else {
+			fileInfo, err = d.Info()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Encountered error while getting file info: %s\n", err)
+			return nil
+		}
+		if fileInfo.IsDir() {
+			if !f.acceptDir(relPath, depth) {
+				return nil
+			}
+			realPath, err := filepath.EvalSymlinks(path)
+			if err != nil || visited[realPath] {
+				return nil
+			}
+			err = f.walkDir(root, path, visited, visit)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Encountered error while listing directory: %s\n", err)
+			}
+			return nil
+		}
+		if !fileInfo.Mode().IsRegular() || !f.acceptFile(relPath, fileInfo.Size()) {
+			return nil
+		}
+		visit(path)
+		return nil
+	})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSizeValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+		valid    bool
+	}{
+		{"0", 0, true},
+		{"512", 512, true},
+		{"10K", 10 << 10, true},
+		{"5m", 5 << 20, true},
+		{"1G", 1 << 30, true},
+		{"", 0, false},
+		{"K", 0, false},
+		{"-1", 0, false},
+		{"1.5M", 0, false},
+		{"10T", 0, false},
+	}
+	for _, test := range tests {
+		var size sizeValue
+		err := size.Set(test.value)
+		if test.valid && (err != nil || int64(size) != test.expected) {
+			t.Fatalf("Expected %d for %q but received %d, %v", test.expected, test.value, size, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("Expected an error for %q but received %d", test.value, size)
+		}
+	}
+}
+
+func TestFileFilterPatterns(t *testing.T) {
+	var include, exclude patternList
+	if err := include.Set("*.jpg, photos/*.png"); err != nil {
+		t.Fatalf("Error setting include patterns: %s", err)
+	}
+	if err := exclude.Set(".git,*_small.jpg"); err != nil {
+		t.Fatalf("Error setting exclude patterns: %s", err)
+	}
+	if err := exclude.Set("[invalid"); err == nil {
+		t.Fatalf("Invalid pattern should be rejected")
+	}
+	f := fileFilter{include: include, exclude: exclude, maxDepth: -1, minSize: 10, maxSize: 100}
+	tests := []struct {
+		path     string
+		size     int64
+		accepted bool
+	}{
+		{"a.jpg", 50, true},
+		{filepath.Join("sub", "b.jpg"), 50, true},
+		{filepath.Join("photos", "c.png"), 50, true},
+		{"c.png", 50, false},
+		{"a_small.jpg", 50, false},
+		{"a.jpg", 5, false},
+		{"a.jpg", 100, true},
+		{"a.jpg", 101, false},
+	}
+	for _, test := range tests {
+		if accepted := f.acceptFile(test.path, test.size); accepted != test.accepted {
+			t.Fatalf("Expected %v for %s of %d bytes but received %v", test.accepted, test.path, test.size, accepted)
+		}
+	}
+	if f.acceptDir(".git", 1) || !f.acceptDir("photos", 1) {
+		t.Fatalf("Only .git should be excluded")
+	}
+}
+
+func TestFileFilterFormats(t *testing.T) {
+	var formats patternList
+	if err := formats.Set("mkv,JPEG"); err != nil {
+		t.Fatalf("Error setting formats: %s", err)
+	}
+	f := fileFilter{formats: formats}
+	tests := []struct {
+		name     string
+		accepted bool
+	}{
+		{"Mkv (Matroska)", true},
+		{"JPEG", true},
+		{"PNG", false},
+		{"ELF (Executable)", false},
+	}
+	for _, test := range tests {
+		if accepted := f.acceptFormat(test.name); accepted != test.accepted {
+			t.Fatalf("Expected %v for %s but received %v", test.accepted, test.name, accepted)
+		}
+	}
+	if !(&fileFilter{}).acceptFormat("PNG") {
+		t.Fatalf("Every format should be accepted without formats filter")
+	}
+}
+
+// createTree creates files and directories under a temporary directory.  Names ending with a separator are
+// directories.
+func createTree(t *testing.T, names ...string) string {
+	root := t.TempDir()
+	for _, name := range names {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatalf("Error creating directory: %s", err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Error creating directory: %s", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Error creating file: %s", err)
+		}
+	}
+	return root
+}
+
+func walkedFiles(t *testing.T, f fileFilter, root string) []string {
+	var result []string
+	err := f.walk(root, func(path string) {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			t.Fatalf("Error computing relative path: %s", err)
+		}
+		result = append(result, filepath.ToSlash(rel))
+	})
+	if err != nil {
+		t.Fatalf("Error walking directory: %s", err)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestFileFilterWalk(t *testing.T) {
+	root := createTree(t, "a.jpg", "one/b.jpg", "one/two/c.jpg", "one/two/three/d.jpg", "skip/e.jpg", "empty/")
+	tests := []struct {
+		filter   fileFilter
+		expected []string
+	}{
+		{fileFilter{maxDepth: -1}, []string{"a.jpg", "one/b.jpg", "one/two/c.jpg", "one/two/three/d.jpg", "skip/e.jpg"}},
+		{fileFilter{maxDepth: 1}, []string{"a.jpg"}},
+		{fileFilter{maxDepth: 2}, []string{"a.jpg", "one/b.jpg", "skip/e.jpg"}},
+		{fileFilter{maxDepth: -1, exclude: patternList{"skip", "two"}}, []string{"a.jpg", "one/b.jpg"}},
+		{fileFilter{maxDepth: -1, include: patternList{"c.jpg", "skip/*"}}, []string{"one/two/c.jpg", "skip/e.jpg"}},
+	}
+	for _, test := range tests {
+		if files := walkedFiles(t, test.filter, root); !reflect.DeepEqual(files, test.expected) {
+			t.Fatalf("Expected %v with %+v but received %v", test.expected, test.filter, files)
+		}
+	}
+}
+
+func TestFileFilterSymlinks(t *testing.T) {
+	root := createTree(t, "dir/a.jpg")
+	outside := createTree(t, "b.jpg", "other/c.jpg")
+	links := map[string]string{
+		"file.jpg":   filepath.Join(outside, "b.jpg"),
+		"other":      filepath.Join(outside, "other"),
+		"dir/parent": root,
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skipf("Symbolic links aren't supported: %s", err)
+		}
+	}
+	files := walkedFiles(t, fileFilter{maxDepth: -1}, root)
+	if !reflect.DeepEqual(files, []string{"dir/a.jpg"}) {
+		t.Fatalf("Symbolic links should be skipped but received %v", files)
+	}
+	// The link to the root directory is a cycle, so it is only visited once
+	files = walkedFiles(t, fileFilter{maxDepth: -1, followSymlinks: true}, root)
+	if !reflect.DeepEqual(files, []string{"dir/a.jpg", "file.jpg", "other/c.jpg"}) {
+		t.Fatalf("Symbolic links should be followed but received %v", files)
+	}
+}
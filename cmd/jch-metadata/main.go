@@ -1,110 +1,547 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
-	"io/fs"
+	"io"
+	"jch-metadata/internal/document"
 	"jch-metadata/internal/output"
 	"jch-metadata/internal/parser"
-	"jch-metadata/internal/parser/elf"
-	"jch-metadata/internal/parser/flac"
-	"jch-metadata/internal/parser/jpeg"
-	"jch-metadata/internal/parser/mkv"
-	"jch-metadata/internal/parser/mp4"
-	"jch-metadata/internal/parser/png"
-	"jch-metadata/internal/parser/webp"
+	"jch-metadata/internal/parser/builtin"
 	"os"
-	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 var actionArg string
 var inputFilename string
+var outputArg string
+var outputFormat output.Format
+var workers int
+var dryRun bool
+var backup bool
+var selector parser.Selector
+var fields fieldList
+var fromFilename string
+var source *parser.Metadata
+var policy = policyList(parser.DefaultPolicy)
+var filter = fileFilter{maxDepth: -1}
+var maxNesting int
+var watchDir string
+var settle time.Duration
 
-var parsers = []parser.Parser{
-	flac.Parser,
-	jpeg.Parser,
-	png.Parser,
-	webp.Parser,
-	mkv.Parser,
-	mp4.Parser,
-	elf.Parser,
-}
+var parsers = builtin.Parsers
 
-func parseFile(fileName string, action parser.Action) {
-	fileFlag := os.O_RDONLY
-	if action == parser.ClearAction {
-		fileFlag = os.O_RDWR
+// fieldList is a repeatable flag of KEY=VALUE fields written by the set action.
+type fieldList []parser.Field
+
+func (f *fieldList) String() string {
+	var values []string
+	for _, field := range *f {
+		values = append(values, field.Key+"="+field.Value)
 	}
-	fmt.Printf("Opening file \033[7m%s\033[27m\n", fileName)
-	file, err := os.OpenFile(fileName, fileFlag, 644)
+	return strings.Join(values, ",")
+}
+
+func (f *fieldList) Set(value string) error {
+	field, err := parser.ParseField(value)
 	if err != nil {
-		fmt.Println("Error opening file:", err)
-		return
+		return err
+	}
+	*f = append(*f, field)
+	return nil
+}
+
+// policyList is a comma separated flag of the categories that violate the policy of the audit action.  Setting it
+// replaces the default policy.
+type policyList []string
+
+func (p *policyList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *policyList) Set(value string) error {
+	result := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		valid := false
+		for _, c := range parser.Categories {
+			valid = valid || c == v
+		}
+		if !valid {
+			return fmt.Errorf("unknown category %s, expected one of %s", v, strings.Join(parser.Categories, ","))
+		}
+		result = append(result, v)
 	}
-	defer func(file *os.File) {
-		err := file.Close()
+	*p = result
+	return nil
+}
+
+type fileResult struct {
+	File string `json:"file"`
+	*document.Document
+	Error string `json:"error,omitempty"`
+}
+
+func printResult(w io.Writer, fileName string, result *document.Document, err error) {
+	if outputFormat == output.JSONFormat {
+		r := fileResult{
+			File:     fileName,
+			Document: result,
+		}
 		if err != nil {
-			fmt.Println("Failed to close file:", err)
+			r.Error = err.Error()
+		} else if result == nil {
+			r.Error = "invalid file format"
 		}
-	}(file)
-	fileInfo, err := file.Stat()
-	if err != nil {
-		fmt.Println("Error retrieving file stat:", err)
+		err = output.PrintJSON(w, r)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error encoding JSON output:", err)
+		}
+		return
 	}
-	parsed, err := parser.StartParsing(parsers, file, action, 0, fileInfo.Size())
 	if err != nil {
-		fmt.Println("Error handling file:", err)
+		fmt.Fprintln(w, err)
 		return
 	}
-	if !parsed {
-		fmt.Println("Invalid file format.  The following formats are supported:")
+	if result == nil {
+		fmt.Fprintln(w, "Invalid file format.  The following formats are supported:")
 		for _, p := range parsers {
-			fmt.Print(p.Name, "   ")
+			fmt.Fprint(w, p.Name, "   ")
 		}
-		fmt.Println()
+		fmt.Fprintln(w)
+		return
 	}
+	output.PrintDocument(w, 0, result)
 }
 
-func parseBatchedFile(fileName string, action parser.Action, fileSize int64) bool {
+// openFile opens a file for action.  The returned Closer closes the file, and flushes the changes of actions that
+// modify it.
+func openFile(fileName string, action parser.Action) (io.Closer, *parser.Input, error) {
 	fileFlag := os.O_RDONLY
-	if action == parser.ClearAction {
+	if (action == parser.ClearAction || action == parser.SetAction || action == parser.CopyAction) && !dryRun {
 		fileFlag = os.O_RDWR
 	}
-	file, err := os.OpenFile(fileName, fileFlag, 644)
+	fileName, items := splitItemPath(fileName)
+	path, name := fileName, fileName
+	if fileName == stdinFilename {
+		path, name = stdinSpool, "stdin"
+	}
+	file, err := os.OpenFile(path, fileFlag, 644)
 	if err != nil {
-		fmt.Println("Error opening file:", err)
-		return false
+		return nil, nil, fmt.Errorf("Error opening file: %w", err)
 	}
-	defer func(file *os.File) {
-		err := file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		closeFile(file)
+		return nil, nil, fmt.Errorf("Error retrieving file stat: %w", err)
+	}
+	input := &parser.Input{
+		Name:   name,
+		Reader: file,
+		Size:   fileInfo.Size(),
+	}
+	var closer io.Closer = file
+	if fileFlag == os.O_RDWR {
+		writer := &parser.FileWriter{File: file, Backup: backup && fileName != stdinFilename}
+		input.Writer = writer
+		closer = writer
+	}
+	if items != "" {
+		input, err = parser.ResolvePath(parsers, input, items)
 		if err != nil {
-			fmt.Println("Failed to close file:", err)
+			closeFile(closer)
+			return nil, nil, fmt.Errorf("Error opening nested item: %w", err)
 		}
-	}(file)
-	supported := false
+	}
+	return closer, input, nil
+}
+
+// splitItemPath separates the file name from the nested items of a path such as test.mkv!attachment[1].  Files
+// whose name contains the separator are opened as is.
+func splitItemPath(path string) (string, string) {
+	if _, err := os.Stat(path); err == nil {
+		return path, ""
+	}
+	return parser.SplitPath(path)
+}
+
+// extractItem writes nested content addressed by a path into the output directory.
+func extractItem(input *parser.Input) (*document.Document, error) {
+	filename, err := parser.ExtractItem(input, "output")
+	if err != nil {
+		return nil, err
+	}
+	doc := document.Message("%s has been extracted to %s", input.RelativePath(), filename)
+	doc.Format = "unknown"
 	for _, p := range parsers {
-		supported, err = p.Support(file, 0, fileSize)
-		if err != nil {
-			continue
-		}
-		if supported {
+		supported, err := p.Support(input.Reader, input.Size)
+		if err == nil && supported {
+			doc.Format = p.Name
 			break
 		}
 	}
-	if !supported {
+	return doc, nil
+}
+
+// readSource reads the metadata copied by the copy action.
+func readSource(fileName string) (*parser.Metadata, error) {
+	file, input, err := openFile(fileName, parser.ShowAction)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFile(file)
+	result, err := parser.ExportMetadata(parsers, input)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading metadata from %s: %w", fileName, err)
+	}
+	return result, nil
+}
+
+// flushFile syncs the changes written in place to a file, so errors are reported with the result of the action.
+func flushFile(file io.Closer) error {
+	if writer, ok := file.(*parser.FileWriter); ok {
+		return writer.Flush()
+	}
+	return nil
+}
+
+func closeFile(file io.Closer) {
+	err := file.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to close file:", err)
+	}
+}
+
+// flagged returns true if the verify action found errors or the audit action found values violating the policy.
+func flagged(action parser.Action, doc *document.Document) bool {
+	if action == parser.VerifyAction {
+		return parser.CountErrors(doc) > 0
+	} else if action == parser.AuditAction {
+		return parser.CountViolations(doc) > 0
+	}
+	return false
+}
+
+// parseFile prints the result of action for a single file.  It returns false if the file couldn't be processed or
+// is flagged by the verify or audit action.
+func parseFile(w io.Writer, fileName string, action parser.Action) bool {
+	if outputFormat == output.TextFormat {
+		fmt.Fprintf(w, "Opening file \033[7m%s\033[27m\n", fileName)
+	}
+	file, input, err := openFile(fileName, action)
+	if err != nil {
+		printResult(w, fileName, nil, err)
+		return false
+	}
+	defer closeFile(file)
+	var result *document.Document
+	if action == parser.DiffAction {
+		result, err = diffFile(input)
+	} else if action == parser.ExtractAction && input.Depth > 0 {
+		result, err = extractItem(input)
+	} else {
+		result, err = parser.StartParsing(parsers, input, action, parser.Options{OutputDir: "output", DryRun: dryRun, Selector: selector, Fields: fields, Source: source, Policy: policy, MaxNesting: maxNesting})
+	}
+	if err == nil {
+		err = flushFile(file)
+	}
+	if err != nil {
+		printResult(w, fileName, nil, fmt.Errorf("Error handling file: %w", err))
 		return false
 	}
-	parseFile(fileName, action)
-	fmt.Println()
-	fmt.Println()
-	return true
+	printResult(w, fileName, result, nil)
+	return result != nil && !flagged(action, result)
+}
+
+// batchResult is the outcome of a file processed in directory mode.  Unsupported files have an empty format and
+// no output.
+type batchResult struct {
+	index  int
+	path   string
+	format string
+	err    error
+	// invalid is true if the verify or audit action flagged the file.
+	invalid bool
+	// hash is the essence hash computed by the hash action.
+	hash   string
+	output bytes.Buffer
+}
+
+func parseBatchedFile(index int, fileName string, action parser.Action) *batchResult {
+	result := &batchResult{
+		index: index,
+		path:  fileName,
+	}
+	file, input, err := openFile(fileName, action)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer closeFile(file)
+	for _, p := range parsers {
+		supported, err := p.Support(input.Reader, input.Size)
+		if err == nil && supported {
+			result.format = p.Name
+			break
+		}
+	}
+	if result.format == "" || !filter.acceptFormat(result.format) {
+		result.format = ""
+		return result
+	}
+	if outputFormat == output.TextFormat {
+		fmt.Fprintf(&result.output, "Opening file \033[7m%s\033[27m\n", fileName)
+	}
+	var doc *document.Document
+	if action == parser.DiffAction {
+		doc, err = diffFile(input)
+	} else {
+		doc, err = parser.StartParsing(parsers, input, action, parser.Options{OutputDir: "output", DryRun: dryRun, Selector: selector, Fields: fields, Source: source, Policy: policy, MaxNesting: maxNesting})
+	}
+	if err == nil {
+		err = flushFile(file)
+	}
+	if err != nil {
+		result.err = fmt.Errorf("Error handling file: %w", err)
+	} else if doc != nil {
+		result.invalid = flagged(action, doc)
+		result.hash = parser.FindEssenceHash(doc)
+	}
+	printResult(&result.output, fileName, doc, result.err)
+	if outputFormat == output.TextFormat {
+		fmt.Fprintln(&result.output)
+		fmt.Fprintln(&result.output)
+	}
+	return result
+}
+
+type summary struct {
+	action     parser.Action
+	totalFiles int
+	formats    map[string]int
+	failures   []*batchResult
+	invalid    []*batchResult
+	// hashes are the files with the same essence hash and hashOrder lists the hashes in the order they were found.
+	hashes    map[string][]string
+	hashOrder []string
+}
+
+func (s *summary) add(result *batchResult) {
+	s.totalFiles += 1
+	if result.format != "" {
+		s.formats[result.format] += 1
+	}
+	if result.err != nil {
+		s.failures = append(s.failures, result)
+	}
+	if result.invalid {
+		s.invalid = append(s.invalid, result)
+	}
+	if result.hash != "" {
+		if _, found := s.hashes[result.hash]; !found {
+			s.hashOrder = append(s.hashOrder, result.hash)
+		}
+		s.hashes[result.hash] = append(s.hashes[result.hash], result.path)
+	}
+}
+
+func (s *summary) print(w io.Writer, dirName string) {
+	supportedFiles := 0
+	formats := make([]string, 0, len(s.formats))
+	for f, count := range s.formats {
+		formats = append(formats, f)
+		supportedFiles += count
+	}
+	sort.Strings(formats)
+	fmt.Fprintf(w, "Recursively parsed %d files out of %d files in folder %s\n", supportedFiles, s.totalFiles, dirName)
+	for _, f := range formats {
+		fmt.Fprintf(w, "  %-16s: %d\n", f, s.formats[f])
+	}
+	if len(s.failures) > 0 {
+		fmt.Fprintf(w, "Failed to process %d files:\n", len(s.failures))
+		for _, f := range s.failures {
+			fmt.Fprintf(w, "  %s: %s\n", f.path, f.err)
+		}
+	}
+	if len(s.invalid) > 0 {
+		if s.action == parser.AuditAction {
+			fmt.Fprintf(w, "Found policy violations in %d files:\n", len(s.invalid))
+		} else {
+			fmt.Fprintf(w, "Found errors in %d files:\n", len(s.invalid))
+		}
+		for _, f := range s.invalid {
+			fmt.Fprintf(w, "  %s\n", f.path)
+		}
+	}
+	if s.action == parser.HashAction {
+		s.printDuplicates(w)
+	}
+}
+
+// printDuplicates prints the groups of files with the same essence hash in the order their first file was visited.
+func (s *summary) printDuplicates(w io.Writer) {
+	var duplicates []string
+	for _, h := range s.hashOrder {
+		if len(s.hashes[h]) > 1 {
+			duplicates = append(duplicates, h)
+		}
+	}
+	if len(duplicates) == 0 {
+		fmt.Fprintln(w, "No files with identical essence found")
+		return
+	}
+	fmt.Fprintf(w, "Found %d groups of files with identical essence:\n", len(duplicates))
+	for _, h := range duplicates {
+		fmt.Fprintf(w, "  %s\n", h)
+		for _, p := range s.hashes[h] {
+			fmt.Fprintf(w, "    %s\n", p)
+		}
+	}
+}
+
+// recoverBatchedFile runs parseBatchedFile and turns a panic caused by a malformed file into a failure of that file,
+// so the other files of the batch are still processed.
+func recoverBatchedFile(index int, fileName string, action parser.Action) (result *batchResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = &batchResult{
+				index: index,
+				path:  fileName,
+				err:   fmt.Errorf("Error handling file: unexpected failure: %v", r),
+			}
+		}
+	}()
+	return parseBatchedFile(index, fileName, action)
+}
+
+// parseDirectory parses files under dirName accepted by filter using a pool of workers.  Results are printed in the
+// order the files are visited regardless of which worker finishes first.  It returns false if a file couldn't be
+// processed or is flagged by the verify or audit action.
+func parseDirectory(dirName string, action parser.Action) bool {
+	type job struct {
+		index int
+		path  string
+	}
+	jobs := make(chan job)
+	results := make(chan *batchResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results <- recoverBatchedFile(j.index, j.path, action)
+			}
+		}()
+	}
+	go func() {
+		index := 0
+		err := filter.walk(dirName, func(path string) {
+			jobs <- job{index: index, path: path}
+			index += 1
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Encountered error while listing directory: %s\n", err)
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	s := summary{action: action, formats: make(map[string]int), hashes: make(map[string][]string)}
+	pending := make(map[int]*batchResult)
+	next := 0
+	for r := range results {
+		pending[r.index] = r
+		for {
+			r, found := pending[next]
+			if !found {
+				break
+			}
+			delete(pending, next)
+			if r.format == "" && r.err != nil {
+				fmt.Fprintln(os.Stderr, r.err)
+			}
+			_, err := os.Stdout.Write(r.output.Bytes())
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error writing output:", err)
+			}
+			s.add(r)
+			next += 1
+		}
+	}
+	summaryOutput := os.Stdout
+	if outputFormat == output.JSONFormat {
+		summaryOutput = os.Stderr
+	}
+	s.print(summaryOutput, dirName)
+	return len(s.failures) == 0 && len(s.invalid) == 0
 }
 
 func main() {
 	output.Setup()
-	flag.StringVar(&inputFilename, "f", "", "Input filename")
-	flag.StringVar(&actionArg, "a", "show", "Action to perform: show, clear, extract")
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		err := runServe(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	flag.StringVar(&inputFilename, "f", "", "Input filename, or - to read from the standard input")
+	flag.StringVar(&actionArg, "a", "show", "Action to perform: show, clear, extract, set, copy, diff, verify, audit, hash")
+	flag.StringVar(&outputArg, "o", "text", "Output format: text, json")
+	flag.BoolVar(&dryRun, "dry-run", false, "Report the changes made by the clear, set or copy action without writing them")
+	flag.BoolVar(&backup, "backup", false, "Keep a copy of modified files with .bak extension")
+	flag.Var((*patternList)(&selector.Keep), "keep", "Comma separated list of metadata kept by the clear action, such as icc,exif.gps")
+	flag.Var((*patternList)(&selector.Remove), "remove", "Comma separated list of metadata removed by the clear action, such as xmp,mkv.tags")
+	flag.StringVar(&fromFilename, "from", "", "File whose metadata is written by the copy action, or the original file or directory compared by the diff action")
+	flag.Var(&fields, "field", "Field written by the set action as KEY=VALUE, an empty value removes the field (can be repeated)")
+	flag.IntVar(&maxNesting, "max-nesting", parser.DefaultMaxNesting, "Maximum number of levels of nested content, such as attachments or thumbnails, to parse")
+	flag.Var(&policy, "policy", fmt.Sprintf("Comma separated list of categories that make the audit action fail: %s", strings.Join(parser.Categories, ",")))
+	flag.StringVar(&watchDir, "watch", "", "Directory watched for new or modified files, which are processed by the clear action")
+	flag.DurationVar(&settle, "settle", 2*time.Second, "Time a file must stay unchanged before it is processed in watch mode")
+	flag.IntVar(&workers, "j", 1, "Number of files parsed in parallel in directory mode")
+	flag.Var(&filter.include, "include", "Only process files matching the glob pattern in directory mode (can be repeated)")
+	flag.Var(&filter.exclude, "exclude", "Skip files and directories matching the glob pattern in directory mode (can be repeated)")
+	flag.IntVar(&filter.maxDepth, "max-depth", -1, "Maximum depth of subdirectories to process in directory mode, -1 for unlimited")
+	flag.BoolVar(&filter.followSymlinks, "follow-symlinks", false, "Follow symbolic links to files and directories in directory mode")
+	flag.Var(&filter.minSize, "min-size", "Skip files smaller than the size (such as 10K, 5M or 1G) in directory mode")
+	flag.Var(&filter.maxSize, "max-size", "Skip files larger than the size (such as 10K, 5M or 1G) in directory mode")
+	flag.Var(&filter.formats, "formats", "Comma separated list of formats to process in directory mode, such as jpeg,png")
 	flag.Parse()
+	if watchDir != "" {
+		if inputFilename != "" || actionArg != string(parser.ClearAction) {
+			fmt.Println("The watch mode requires -a clear and no -f")
+			flag.PrintDefaults()
+			return
+		}
+		if settle <= 0 {
+			fmt.Println("Invalid settle period:", settle)
+			flag.PrintDefaults()
+			return
+		}
+		var err error
+		outputFormat, err = output.ConvertFormat(outputArg)
+		if err != nil {
+			fmt.Println(err)
+			flag.PrintDefaults()
+			return
+		}
+		err = watchDirectory(watchDir, parser.ClearAction, settle)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 	if inputFilename == "" {
 		fmt.Println("Invalid input filename")
 		flag.PrintDefaults()
@@ -116,39 +553,82 @@ func main() {
 		flag.PrintDefaults()
 		return
 	}
-	fileStat, err := os.Stat(inputFilename)
+	outputFormat, err = output.ConvertFormat(outputArg)
+	if err != nil {
+		fmt.Println(err)
+		flag.PrintDefaults()
+		return
+	}
+	if action == parser.SetAction && len(fields) == 0 {
+		fmt.Println("The set action requires at least one --field")
+		flag.PrintDefaults()
+		return
+	}
+	if action == parser.CopyAction {
+		if fromFilename == "" {
+			fmt.Println("The copy action requires --from")
+			flag.PrintDefaults()
+			return
+		}
+		source, err = readSource(fromFilename)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if len(source.Kinds()) == 0 {
+			fmt.Printf("No metadata to copy from %s\n", fromFilename)
+			return
+		}
+	}
+	if action == parser.DiffAction && fromFilename == "" {
+		fmt.Println("The diff action requires --from")
+		flag.PrintDefaults()
+		return
+	}
+	if maxNesting < 1 {
+		fmt.Println("Invalid maximum nesting level:", maxNesting)
+		flag.PrintDefaults()
+		return
+	}
+	if workers < 1 {
+		fmt.Println("Invalid number of workers:", workers)
+		flag.PrintDefaults()
+		return
+	}
+	if inputFilename == stdinFilename {
+		if !parseStdin(action) {
+			os.Exit(1)
+		}
+		return
+	}
+	statFilename, items := splitItemPath(inputFilename)
+	fileStat, err := os.Stat(statFilename)
 	if err != nil {
 		fmt.Printf("Error retrieving information for %s: %s\n", inputFilename, err)
 		return
 	}
-	if fileStat.Mode().IsDir() {
-		supportedFiles := 0
-		totalFiles := 0
-		err = filepath.WalkDir(inputFilename, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				fmt.Printf("Encountered error while processing file: %s\n", err)
-				return nil
-			}
-			if d.IsDir() {
-				return nil
-			}
-			fileInfo, err := d.Info()
-			if err != nil {
-				fmt.Printf("Encountered error while getting file info: %s\n", err)
-				return nil
-			}
-			supported := parseBatchedFile(path, action, fileInfo.Size())
-			if supported {
-				supportedFiles += 1
-			}
-			totalFiles += 1
-			return nil
-		})
+	if action == parser.DiffAction {
+		fromStat, err := os.Stat(fromFilename)
 		if err != nil {
-			fmt.Printf("Encountered error while listing directory: %s\n", err)
+			fmt.Printf("Error retrieving information for %s: %s\n", fromFilename, err)
+			return
+		}
+		if fromStat.IsDir() != fileStat.IsDir() {
+			fmt.Println("The diff action compares two files or two directories")
+			return
 		}
-		fmt.Printf("Recursively parsed %d files out of %d files in folder %s\n", supportedFiles, totalFiles, inputFilename)
+	}
+	if items != "" && fileStat.IsDir() {
+		fmt.Println("Nested items can only be addressed in a file")
+		return
+	}
+	var ok bool
+	if fileStat.Mode().IsDir() {
+		ok = parseDirectory(inputFilename, action)
 	} else {
-		parseFile(inputFilename, action)
+		ok = parseFile(os.Stdout, inputFilename, action)
+	}
+	if !ok {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// notifyChanges isn't supported outside Linux, so the watched directory is polled.
+func notifyChanges(dir string) (<-chan string, error) {
+	return nil, errors.New("change notifications are only supported on Linux")
+}
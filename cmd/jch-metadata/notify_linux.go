@@ -0,0 +1,107 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const notifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_MODIFY
+
+// notifyChanges returns a channel receiving the paths of files created, written or moved into dir and its
+// subdirectories using inotify.  Subdirectories that filter excludes or that are deeper than its maximum depth aren't
+// watched.  The channel is closed if reading events fails.
+func notifyChanges(dir string) (<-chan string, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing inotify: %w", err)
+	}
+	dirs := make(map[int32]string)
+	err = addWatches(fd, dirs, dir, dir)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	changes := make(chan string)
+	go func() {
+		defer close(changes)
+		defer syscall.Close(fd)
+		buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := syscall.Read(fd, buffer)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || n < syscall.SizeofInotifyEvent {
+				fmt.Fprintln(os.Stderr, "Error reading inotify events:", err)
+				return
+			}
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				name := string(bytes.TrimRight(buffer[nameStart:nameStart+int(event.Len)], "\x00"))
+				offset = nameStart + int(event.Len)
+				parent, found := dirs[event.Wd]
+				if !found || name == "" {
+					continue
+				}
+				path := filepath.Join(parent, name)
+				if event.Mask&syscall.IN_ISDIR != 0 {
+					if !watchedDir(dir, path) {
+						continue
+					}
+					// Files may be written into a new directory before it is watched, so they are listed
+					err = addWatches(fd, dirs, dir, path)
+					if err != nil {
+						fmt.Fprintln(os.Stderr, err)
+					}
+					_ = filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+						if err == nil && !d.IsDir() {
+							changes <- p
+						}
+						return nil
+					})
+					continue
+				}
+				changes <- path
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// addWatches watches dir and its subdirectories under root that are accepted by filter.
+func addWatches(fd int, dirs map[int32]string, root string, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if !watchedDir(root, path) {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(fd, path, notifyMask)
+		if err != nil {
+			return fmt.Errorf("error watching %s: %w", path, err)
+		}
+		dirs[int32(wd)] = path
+		return nil
+	})
+}
+
+// watchedDir returns true if dir, a directory under root, is entered by filter.walk.
+func watchedDir(root string, dir string) bool {
+	relPath, err := filepath.Rel(root, dir)
+	if err != nil {
+		return false
+	}
+	if relPath == "." {
+		return true
+	}
+	return filter.acceptParents(relPath) && filter.acceptDir(relPath, strings.Count(relPath, string(filepath.Separator))+1)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"jch-metadata/internal/output"
+	"jch-metadata/pkg/metadata"
+	"net/http"
+	"time"
+)
+
+// server exposes the show and clear actions over HTTP.  Uploads are kept in memory, so their size and the number
+// of uploads processed at the same time are limited.
+type server struct {
+	maxSize int64
+	slots   chan struct{}
+}
+
+// runServe parses the flags of the serve subcommand and listens until the server fails.
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:8080", "Address to listen on")
+	maxSize := sizeValue(32 << 20)
+	flags.Var(&maxSize, "max-size", "Largest accepted upload (such as 10K, 5M or 1G)")
+	concurrency := flags.Int("max-concurrent", 4, "Number of uploads processed at the same time")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *concurrency < 1 {
+		return fmt.Errorf("invalid number of concurrent uploads: %d", *concurrency)
+	}
+	s := newServer(int64(maxSize), *concurrency)
+	// Uploads are read after waiting for a slot, so reading and writing a request can take much longer than its
+	// headers
+	httpServer := &http.Server{
+		Addr:              *addr,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       5 * time.Minute,
+		WriteTimeout:      5 * time.Minute,
+		IdleTimeout:       time.Minute,
+	}
+	fmt.Printf("Listening on http://%s\n", *addr)
+	return httpServer.ListenAndServe()
+}
+
+func newServer(maxSize int64, concurrency int) *server {
+	return &server{
+		maxSize: maxSize,
+		slots:   make(chan struct{}, concurrency),
+	}
+}
+
+// routes returns the handler of the endpoints of the server.
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/inspect", s.handle(s.inspect))
+	mux.HandleFunc("/clear", s.handle(s.clear))
+	return mux
+}
+
+// handle reads the body of a POST request into a buffer and passes it to next once a slot is available.
+func (s *server) handle(next func(w http.ResponseWriter, f *metadata.File)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, "", fmt.Errorf("method %s is not allowed", r.Method))
+			return
+		}
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			name = "upload"
+		}
+		select {
+		case s.slots <- struct{}{}:
+			defer func() { <-s.slots }()
+		case <-r.Context().Done():
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxSize))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				writeError(w, http.StatusRequestEntityTooLarge, name, fmt.Errorf("upload is larger than %d bytes", s.maxSize))
+			} else {
+				writeError(w, http.StatusBadRequest, name, fmt.Errorf("error reading upload: %w", err))
+			}
+			return
+		}
+		next(w, metadata.NewBuffer(name, data))
+	}
+}
+
+// inspect writes the metadata of f as JSON.
+func (s *server) inspect(w http.ResponseWriter, f *metadata.File) {
+	doc, err := metadata.Read(f)
+	if err != nil {
+		writeError(w, statusOf(err), f.Name, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = output.PrintJSON(w, fileResult{File: f.Name, Document: doc})
+	if err != nil {
+		fmt.Println("Error encoding JSON output:", err)
+	}
+}
+
+// clear removes the metadata of f and writes the result.
+func (s *server) clear(w http.ResponseWriter, f *metadata.File) {
+	_, err := metadata.Clear(f)
+	if err != nil {
+		writeError(w, statusOf(err), f.Name, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprint(len(f.Bytes())))
+	_, err = w.Write(f.Bytes())
+	if err != nil {
+		fmt.Println("Error writing response:", err)
+	}
+}
+
+func statusOf(err error) int {
+	if errors.Is(err, metadata.ErrUnsupportedFormat) {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusUnprocessableEntity
+}
+
+func writeError(w http.ResponseWriter, status int, name string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = output.PrintJSON(w, fileResult{File: name, Error: err.Error()})
+}
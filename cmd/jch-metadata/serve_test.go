@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"jch-metadata/pkg/metadata"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func post(t *testing.T, handler http.Handler, path string, body []byte) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestServeInspect(t *testing.T) {
+	data, err := os.ReadFile("../../internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	response := post(t, newServer(1<<20, 1).routes(), "/inspect?name=test1.png", data)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Unexpected status: %d", response.Code)
+	}
+	var result struct {
+		File   string `json:"file"`
+		Format string `json:"format"`
+	}
+	err = json.Unmarshal(response.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatalf("Error decoding response: %s", err)
+	}
+	if result.File != "test1.png" || result.Format != "PNG" {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+
+	response = post(t, newServer(1<<20, 1).routes(), "/inspect", bytes.Repeat([]byte("not a media file\n"), 64))
+	if response.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("Unexpected status for unsupported format: %d", response.Code)
+	}
+}
+
+func TestServeClear(t *testing.T) {
+	data, err := os.ReadFile("../../internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	response := post(t, newServer(1<<20, 1).routes(), "/clear?name=test1.png", data)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Unexpected status: %d", response.Code)
+	}
+	cleared, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("Error reading response: %s", err)
+	}
+	if len(cleared) == 0 || len(cleared) >= len(data) {
+		t.Fatalf("Metadata should have been removed: %d bytes before, %d bytes after", len(data), len(cleared))
+	}
+	format, err := metadata.Detect(metadata.NewBuffer("test1.png", cleared))
+	if err != nil || format != "PNG" {
+		t.Fatalf("Cleared file should still be a PNG file: %s, %v", format, err)
+	}
+}
+
+func TestServeLimits(t *testing.T) {
+	handler := newServer(16, 1).routes()
+	response := post(t, handler, "/inspect", make([]byte, 1024))
+	if response.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Unexpected status for oversize upload: %d", response.Code)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/clear", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusMethodNotAllowed || recorder.Header().Get("Allow") != http.MethodPost {
+		t.Fatalf("Unexpected response to GET: %d, Allow: %s", recorder.Code, recorder.Header().Get("Allow"))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+	"os"
+)
+
+// stdinFilename is the input filename that reads the content from the standard input.
+const stdinFilename = "-"
+
+// stdinSpool is the temporary file holding the standard input, because parsers need random access to the content.
+var stdinSpool string
+
+// spoolStdin copies the standard input into a temporary file and returns its name.
+func spoolStdin() (string, error) {
+	file, err := os.CreateTemp("", "jch-metadata-*")
+	if err != nil {
+		return "", fmt.Errorf("error creating spool file: %w", err)
+	}
+	_, err = io.Copy(file, os.Stdin)
+	if err == nil {
+		err = file.Close()
+	} else {
+		_ = file.Close()
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", fmt.Errorf("error reading standard input: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// parseStdin runs action on the content of the standard input.  If the action modifies the content, the result is
+// printed to the standard error and the modified content is written to the standard output.  It returns false if
+// nothing has been written because the content couldn't be processed.
+func parseStdin(action parser.Action) bool {
+	var err error
+	stdinSpool, err = spoolStdin()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	defer func() {
+		err := os.Remove(stdinSpool)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to remove spool file:", err)
+		}
+	}()
+	modifies := (action == parser.ClearAction || action == parser.SetAction || action == parser.CopyAction) && !dryRun
+	if !modifies {
+		return parseFile(os.Stdout, stdinFilename, action)
+	}
+	if !parseFile(os.Stderr, stdinFilename, action) {
+		return false
+	}
+	// The spool may have been replaced by a new file, so it is opened again
+	file, err := os.Open(stdinSpool)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening spool file:", err)
+		return false
+	}
+	defer closeFile(file)
+	_, err = io.Copy(os.Stdout, file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing output:", err)
+		return false
+	}
+	return true
+}
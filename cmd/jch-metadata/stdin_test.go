@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"jch-metadata/internal/output"
+	"jch-metadata/internal/parser"
+	"jch-metadata/pkg/metadata"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runStdin runs parseStdin with data as the standard input and returns what it wrote to the standard output and
+// the standard error.
+func runStdin(t *testing.T, action parser.Action, data []byte) (bool, []byte, []byte) {
+	dir := t.TempDir()
+	files := make(map[string]*os.File)
+	for _, name := range []string{"stdin", "stdout", "stderr"} {
+		file, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Error creating %s: %s", name, err)
+		}
+		defer file.Close()
+		files[name] = file
+	}
+	_, err := files["stdin"].Write(data)
+	if err == nil {
+		_, err = files["stdin"].Seek(0, 0)
+	}
+	if err != nil {
+		t.Fatalf("Error writing standard input: %s", err)
+	}
+	stdin, stdout, stderr, format := os.Stdin, os.Stdout, os.Stderr, outputFormat
+	os.Stdin, os.Stdout, os.Stderr, outputFormat = files["stdin"], files["stdout"], files["stderr"], output.TextFormat
+	ok := parseStdin(action)
+	os.Stdin, os.Stdout, os.Stderr, outputFormat = stdin, stdout, stderr, format
+
+	if _, err := os.Stat(stdinSpool); !os.IsNotExist(err) {
+		t.Fatalf("Spool file %s should have been removed: %v", stdinSpool, err)
+	}
+	written, err := os.ReadFile(files["stdout"].Name())
+	if err != nil {
+		t.Fatalf("Error reading standard output: %s", err)
+	}
+	report, err := os.ReadFile(files["stderr"].Name())
+	if err != nil {
+		t.Fatalf("Error reading standard error: %s", err)
+	}
+	return ok, written, report
+}
+
+func TestParseStdinClear(t *testing.T) {
+	data, err := os.ReadFile("../../internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	ok, written, report := runStdin(t, parser.ClearAction, data)
+	if !ok {
+		t.Fatalf("Clearing the standard input should succeed: %s", report)
+	}
+	if !strings.Contains(string(report), "File type is PNG") {
+		t.Fatalf("Result should be written to the standard error: %q", report)
+	}
+	if len(written) == 0 || len(written) >= len(data) {
+		t.Fatalf("Metadata should have been removed: %d bytes before, %d bytes after", len(data), len(written))
+	}
+	format, err := metadata.Detect(metadata.NewBuffer("stdin", written))
+	if err != nil || format != "PNG" {
+		t.Fatalf("Standard output should be a PNG file: %s, %v", format, err)
+	}
+}
+
+func TestParseStdinShow(t *testing.T) {
+	data, err := os.ReadFile("../../internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	ok, written, report := runStdin(t, parser.ShowAction, data)
+	if !ok {
+		t.Fatalf("Showing the standard input should succeed: %s", written)
+	}
+	if !strings.Contains(string(written), "File type is PNG") || len(report) != 0 {
+		t.Fatalf("Result should be written to the standard output: %q, %q", written, report)
+	}
+	if bytes.Contains(written, data[:8]) {
+		t.Fatalf("Content shouldn't be written by the show action")
+	}
+}
+
+func TestParseStdinUnsupported(t *testing.T) {
+	ok, written, _ := runStdin(t, parser.ClearAction, bytes.Repeat([]byte("not a media file\n"), 64))
+	if ok || len(written) != 0 {
+		t.Fatalf("Nothing should be written for an unsupported format: %v, %d bytes", ok, len(written))
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"jch-metadata/internal/output"
+	"jch-metadata/internal/parser"
+	"jch-metadata/internal/safewrite"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// watcher processes files written into a directory once their size and modification time haven't changed for the
+// settle period.  Files are found through change notifications or, if they aren't available, by listing the
+// directory every settle period.
+type watcher struct {
+	dir    string
+	action parser.Action
+	settle time.Duration
+	// pending are the files changed since they were last processed, with their state when they were last checked.
+	pending map[string]*fileState
+	// processed are the files that have been processed, with their state after processing.
+	processed map[string]fileState
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+	// since is the time the size or modification time was last seen changing.
+	since time.Time
+}
+
+func (s fileState) same(other fileState) bool {
+	return s.size == other.size && s.modTime.Equal(other.modTime)
+}
+
+// watchDirectory runs action on the files written into dir until the process is stopped.  Files that are already in
+// dir are processed when the watcher starts.
+func watchDirectory(dir string, action parser.Action, settle time.Duration) error {
+	fileInfo, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("Error retrieving information for %s: %w", dir, err)
+	}
+	if !fileInfo.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	w := &watcher{
+		dir:       dir,
+		action:    action,
+		settle:    settle,
+		pending:   make(map[string]*fileState),
+		processed: make(map[string]fileState),
+	}
+	changes, err := notifyChanges(dir)
+	if err != nil {
+		w.log("Polling %s every %s: %s", dir, settle, err)
+	} else {
+		w.log("Watching %s", dir)
+	}
+	w.scan()
+	ticker := time.NewTicker(settle / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case path, ok := <-changes:
+			if !ok {
+				w.log("Change notifications stopped, polling %s every %s", dir, settle)
+				changes = nil
+				continue
+			}
+			w.touch(path)
+		case <-ticker.C:
+			if changes == nil {
+				w.scan()
+			}
+			w.processStable()
+		}
+	}
+}
+
+// log writes a timestamped message to the standard output, or to the standard error if JSON documents are printed.
+func (w *watcher) log(format string, a ...any) {
+	var out io.Writer = os.Stdout
+	if outputFormat == output.JSONFormat {
+		out = os.Stderr
+	}
+	fmt.Fprintf(out, "%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, a...))
+}
+
+// scan checks every file in the directory accepted by filter.
+func (w *watcher) scan() {
+	err := filter.walk(w.dir, w.touch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Encountered error while listing directory: %s\n", err)
+	}
+}
+
+// touch marks path as pending if it changed since it was processed.  Hidden files, such as the temporary files of
+// modified files, backups and files in directories that walk doesn't enter are ignored.
+func (w *watcher) touch(path string) {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, safewrite.BackupSuffix) {
+		return
+	}
+	// Symbolic links aren't regular files, so they are skipped unless they are followed
+	stat := os.Lstat
+	if filter.followSymlinks {
+		stat = os.Stat
+	}
+	fileInfo, err := stat(path)
+	if err != nil || !fileInfo.Mode().IsRegular() {
+		return
+	}
+	relPath, err := filepath.Rel(w.dir, path)
+	if err != nil || !filter.acceptParents(relPath) || !filter.acceptFile(relPath, fileInfo.Size()) {
+		return
+	}
+	state := fileState{size: fileInfo.Size(), modTime: fileInfo.ModTime(), since: time.Now()}
+	if processed, found := w.processed[path]; found && processed.same(state) {
+		return
+	}
+	if pending, found := w.pending[path]; found && pending.same(state) {
+		return
+	}
+	w.pending[path] = &state
+}
+
+// processStable processes the pending files that haven't changed for the settle period.
+func (w *watcher) processStable() {
+	var stable []string
+	now := time.Now()
+	for path, state := range w.pending {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			delete(w.pending, path)
+			continue
+		}
+		current := fileState{size: fileInfo.Size(), modTime: fileInfo.ModTime(), since: now}
+		if !state.same(current) {
+			w.pending[path] = &current
+		} else if now.Sub(state.since) >= w.settle {
+			stable = append(stable, path)
+		}
+	}
+	sort.Strings(stable)
+	for _, path := range stable {
+		w.process(path)
+	}
+}
+
+// process runs the action on path and records its state, so it isn't processed again unless it is modified.
+func (w *watcher) process(path string) {
+	delete(w.pending, path)
+	result := recoverBatchedFile(0, path, w.action)
+	if result.format == "" {
+		if result.err != nil {
+			w.log("Failed to open %s: %s", path, result.err)
+		} else {
+			w.log("Skipped %s: format not supported or excluded by --formats", path)
+		}
+	} else {
+		if result.err != nil {
+			w.log("Failed to %s %s (%s): %s", w.action, path, result.format, result.err)
+		} else {
+			w.log("Processed %s with %s action (%s)", path, w.action, result.format)
+		}
+		_, err := os.Stdout.Write(result.output.Bytes())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing output:", err)
+		}
+	}
+	fileInfo, err := os.Stat(path)
+	if err == nil {
+		w.processed[path] = fileState{size: fileInfo.Size(), modTime: fileInfo.ModTime()}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"jch-metadata/internal/parser"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcherSettle(t *testing.T) {
+	data, err := os.ReadFile("../../internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	logs, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatalf("Error creating file: %s", err)
+	}
+	defer logs.Close()
+	stdout := os.Stdout
+	os.Stdout = logs
+	defer func() { os.Stdout = stdout }()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test1.png")
+	w := &watcher{
+		dir:       dir,
+		action:    parser.ClearAction,
+		settle:    50 * time.Millisecond,
+		pending:   make(map[string]*fileState),
+		processed: make(map[string]fileState),
+	}
+	// poll runs the polling backend once, after waiting for wait
+	poll := func(wait time.Duration) {
+		time.Sleep(wait)
+		w.scan()
+		w.processStable()
+	}
+
+	if err := os.WriteFile(path, data[:len(data)/2], 0644); err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+	poll(0)
+	if _, found := w.pending[path]; !found || len(w.processed) != 0 {
+		t.Fatalf("New file should be pending until it settles")
+	}
+	// The file is still being written, so the settle period starts again
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+	poll(w.settle)
+	if _, found := w.pending[path]; !found || len(w.processed) != 0 {
+		t.Fatalf("Modified file should be pending until it settles")
+	}
+	poll(w.settle)
+	processed, found := w.processed[path]
+	if !found || len(w.pending) != 0 {
+		t.Fatalf("Settled file should have been processed")
+	}
+	if processed.size >= int64(len(data)) {
+		t.Fatalf("Metadata should have been removed: %d bytes before, %d bytes after", len(data), processed.size)
+	}
+
+	// The file written by the action isn't processed again
+	poll(w.settle)
+	if len(w.pending) != 0 || !w.processed[path].same(processed) {
+		t.Fatalf("Processed file shouldn't be processed again")
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+	poll(0)
+	if _, found := w.pending[path]; !found {
+		t.Fatalf("File modified after processing should be pending")
+	}
+}
@@ -0,0 +1,98 @@
+package document
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Diff returns a document listing the fields that were added, removed or changed between before and after.  Fields
+// are matched by the keys of their sections, such as "exif.0.0x0110", and values of list fields are compared item by
+// item.
+func Diff(before *Document, after *Document) *Document {
+	beforeValues := make(map[string]string)
+	flatten(beforeValues, "", &before.Section)
+	afterValues := make(map[string]string)
+	flatten(afterValues, "", &after.Section)
+
+	var paths []string
+	for p := range beforeValues {
+		paths = append(paths, p)
+	}
+	for p := range afterValues {
+		if _, found := beforeValues[p]; !found {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	doc := &Document{Format: after.Format}
+	count := 0
+	for _, p := range paths {
+		beforeValue, inBefore := beforeValues[p]
+		afterValue, inAfter := afterValues[p]
+		change := Changed
+		if !inBefore {
+			change = Added
+		} else if !inAfter {
+			change = Removed
+		} else if beforeValue == afterValue {
+			continue
+		}
+		section := doc.AddSection(fmt.Sprintf("change.%d", count), fmt.Sprintf("Change %d", count+1))
+		section.AddString("field", "Field", p)
+		section.AddString("change", "Change", change)
+		if inBefore {
+			section.AddString("before", "Before", beforeValue)
+		}
+		if inAfter {
+			section.AddString("after", "After", afterValue)
+		}
+		count += 1
+	}
+	if count == 0 {
+		doc.AddMessage("No differences found")
+	} else {
+		doc.AddMessage("%d fields differ", count)
+	}
+	return doc
+}
+
+// flatten adds the values of the fields of section and its children to values, keyed by their path.
+func flatten(values map[string]string, prefix string, section *Section) {
+	add := func(path string, value string) {
+		key := path
+		for i := 2; ; i++ {
+			if _, found := values[key]; !found {
+				break
+			}
+			key = fmt.Sprintf("%s#%d", path, i)
+		}
+		values[key] = value
+	}
+	for _, f := range section.Fields {
+		path := prefix + f.Key
+		switch v := f.Value.(type) {
+		case []string:
+			for _, item := range v {
+				add(path+"."+item, item)
+			}
+		case []byte:
+			add(path, fmt.Sprintf("%d bytes (CRC32 %08X)", len(v), crc32.ChecksumIEEE(v)))
+		default:
+			add(path, f.String())
+		}
+	}
+	for _, s := range section.Sections {
+		flatten(values, prefix+s.Key+".", s)
+	}
+	for i, d := range section.Documents {
+		flatten(values, fmt.Sprintf("%sdocument.%d.", prefix, i), &d.Section)
+	}
+}
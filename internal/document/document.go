@@ -0,0 +1,153 @@
+package document
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Type string
+
+const (
+	StringType Type = "string"
+	TextType   Type = "text"
+	IntType    Type = "int"
+	UintType   Type = "uint"
+	FloatType  Type = "float"
+	BoolType   Type = "bool"
+	TimeType   Type = "time"
+	ListType   Type = "list"
+	BinaryType Type = "binary"
+)
+
+// Document is the metadata of a single file.  The document itself is the root section, so fields and
+// sections that don't belong to any group can be added directly to it.
+type Document struct {
+	Format string `json:"format"`
+	// Path addresses a nested document from the file that contains it.
+	Path string `json:"path,omitempty"`
+	Section
+	Messages []string `json:"messages,omitempty"`
+}
+
+// Section groups related fields.  Documents holds nested files such as attachments.
+type Section struct {
+	Key       string      `json:"key,omitempty"`
+	Title     string      `json:"title,omitempty"`
+	Fields    []Field     `json:"fields,omitempty"`
+	Sections  []*Section  `json:"sections,omitempty"`
+	Documents []*Document `json:"documents,omitempty"`
+}
+
+// Field is a typed value.  Key is stable and meant for tools while Label is meant for humans.
+type Field struct {
+	Key   string `json:"key"`
+	Label string `json:"label,omitempty"`
+	Type  Type   `json:"type"`
+	Value any    `json:"value"`
+}
+
+func (d *Document) AddMessage(format string, a ...any) {
+	d.Messages = append(d.Messages, fmt.Sprintf(format, a...))
+}
+
+func (s *Section) AddSection(key string, title string) *Section {
+	section := &Section{
+		Key:   key,
+		Title: title,
+	}
+	s.Sections = append(s.Sections, section)
+	return section
+}
+
+func (s *Section) AddDocument(doc *Document) {
+	s.Documents = append(s.Documents, doc)
+}
+
+func (s *Section) Add(key string, label string, fieldType Type, value any) {
+	s.Fields = append(s.Fields, Field{
+		Key:   key,
+		Label: label,
+		Type:  fieldType,
+		Value: value,
+	})
+}
+
+func (s *Section) AddString(key string, label string, value string) {
+	s.Add(key, label, StringType, value)
+}
+
+func (s *Section) AddText(key string, label string, value string) {
+	s.Add(key, label, TextType, value)
+}
+
+func (s *Section) AddInt(key string, label string, value int64) {
+	s.Add(key, label, IntType, value)
+}
+
+func (s *Section) AddUint(key string, label string, value uint64) {
+	s.Add(key, label, UintType, value)
+}
+
+func (s *Section) AddFloat(key string, label string, value float64) {
+	s.Add(key, label, FloatType, value)
+}
+
+func (s *Section) AddBool(key string, label string, value bool) {
+	s.Add(key, label, BoolType, value)
+}
+
+func (s *Section) AddTime(key string, label string, value time.Time) {
+	s.Add(key, label, TimeType, value)
+}
+
+func (s *Section) AddList(key string, label string, value []string) {
+	s.Add(key, label, ListType, value)
+}
+
+func (s *Section) AddBinary(key string, label string, value []byte) {
+	s.Add(key, label, BinaryType, value)
+}
+
+// FindSection returns the first direct child section with the given key.
+func (s *Section) FindSection(key string) *Section {
+	for _, section := range s.Sections {
+		if section.Key == key {
+			return section
+		}
+	}
+	return nil
+}
+
+// FindField returns the first field with the given key.
+func (s *Section) FindField(key string) *Field {
+	for i := range s.Fields {
+		if s.Fields[i].Key == key {
+			return &s.Fields[i]
+		}
+	}
+	return nil
+}
+
+// String formats the value for display.
+func (f Field) String() string {
+	switch v := f.Value.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Local().String()
+	case []string:
+		return strings.Join(v, ", ")
+	case []byte:
+		return fmt.Sprintf("%d bytes", len(v))
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// Message returns a document that only reports the outcome of an action.
+func Message(format string, a ...any) *Document {
+	doc := &Document{}
+	doc.AddMessage(format, a...)
+	return doc
+}
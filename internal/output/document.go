@@ -0,0 +1,74 @@
+package output
+
+import (
+	"io"
+	"jch-metadata/internal/document"
+)
+
+func PrintDocument(w io.Writer, depth int, doc *document.Document) {
+	Printf(w, depth, "File type is %s\n\n", doc.Format)
+	printFields(w, depth, doc.Fields)
+	if len(doc.Fields) > 0 {
+		Println(w, depth)
+	}
+	for _, s := range doc.Sections {
+		printSection(w, depth, s)
+	}
+	printDocuments(w, depth, doc.Documents)
+	for _, m := range doc.Messages {
+		Println(w, depth, m)
+	}
+}
+
+func printSection(w io.Writer, depth int, section *document.Section) {
+	if section.Title != "" {
+		PrintHeader(w, depth, section.Title)
+	}
+	printFields(w, depth, section.Fields)
+	Println(w, depth)
+	for _, s := range section.Sections {
+		printSection(w, depth, s)
+	}
+	printDocuments(w, depth, section.Documents)
+}
+
+// printDocuments prints nested documents indented one level deeper than their parent.
+func printDocuments(w io.Writer, depth int, docs []*document.Document) {
+	for _, d := range docs {
+		PrintDocument(w, depth+1, d)
+		Println(w, 0)
+	}
+}
+
+func printFields(w io.Writer, depth int, fields []document.Field) {
+	width := 0
+	for _, f := range fields {
+		if len(f.Label) > width {
+			width = len(f.Label)
+		}
+	}
+	for _, f := range fields {
+		switch f.Type {
+		case document.BinaryType:
+			if f.Label != "" {
+				PrintForm(w, depth, f.Label, f.String(), width)
+			}
+			PrintHexDump(w, depth, f.Value.([]byte))
+		case document.TextType:
+			if f.Label != "" {
+				PrintForm(w, depth, f.Label, "", width)
+			}
+			PrintMultiline(w, depth, f.String())
+		case document.ListType:
+			if f.Label != "" {
+				PrintForm(w, depth, f.Label, f.String(), width)
+			} else {
+				for _, v := range f.Value.([]string) {
+					Println(w, depth, v)
+				}
+			}
+		default:
+			PrintForm(w, depth, f.Label, f.String(), width)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type Format string
+
+const (
+	TextFormat Format = "text"
+	JSONFormat Format = "json"
+)
+
+func ConvertFormat(formatArgument string) (Format, error) {
+	if formatArgument == string(TextFormat) {
+		return TextFormat, nil
+	} else if formatArgument == string(JSONFormat) {
+		return JSONFormat, nil
+	}
+	return "", fmt.Errorf("invalid output format: %s", formatArgument)
+}
+
+func PrintJSON(w io.Writer, value any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(value)
+}
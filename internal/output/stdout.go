@@ -2,76 +2,70 @@ package output
 
 import (
 	"fmt"
+	"io"
 	"strings"
 )
 
-func Printf(indented bool, format string, a ...any) {
-	if indented {
-		fmt.Print("  ")
-	}
-	fmt.Printf(format, a...)
+// indent writes two spaces for every level of nesting.
+func indent(w io.Writer, depth int) {
+	fmt.Fprint(w, strings.Repeat("  ", depth))
 }
 
-func Println(indented bool, a ...any) {
-	if indented {
-		fmt.Print("  ")
-	}
-	fmt.Println(a...)
+func Printf(w io.Writer, depth int, format string, a ...any) {
+	indent(w, depth)
+	fmt.Fprintf(w, format, a...)
 }
 
-func PrintForm(indented bool, label string, value any, labelWidth int) {
-	if indented {
-		fmt.Print("  ")
-	}
-	fmt.Printf("\033[2m%-*s:\033[22m %s\n", labelWidth, label, value)
+func Println(w io.Writer, depth int, a ...any) {
+	indent(w, depth)
+	fmt.Fprintln(w, a...)
 }
 
-func PrintHeader(indented bool, format string, a ...any) {
-	if indented {
-		fmt.Print("  ")
-	}
-	fmt.Printf("\033[4m"+format+"\033[24m\n", a...)
+func PrintForm(w io.Writer, depth int, label string, value any, labelWidth int) {
+	indent(w, depth)
+	fmt.Fprintf(w, "\033[2m%-*s:\033[22m %s\n", labelWidth, label, value)
 }
 
-func PrintHexDump(indented bool, value []byte) {
+func PrintHeader(w io.Writer, depth int, format string, a ...any) {
+	indent(w, depth)
+	fmt.Fprintf(w, "\033[4m"+format+"\033[24m\n", a...)
+}
+
+func PrintHexDump(w io.Writer, depth int, value []byte) {
 	for i := 0; i < len(value); i += 32 {
-		if indented {
-			fmt.Print("  ")
-		}
+		indent(w, depth)
 		for j := i; j < i+32; j++ {
 			if j >= len(value) {
-				fmt.Printf("   ")
+				fmt.Fprintf(w, "   ")
 			} else {
-				fmt.Printf("%02X ", value[j])
+				fmt.Fprintf(w, "%02X ", value[j])
 			}
 		}
-		fmt.Printf("   ")
+		fmt.Fprintf(w, "   ")
 		for j := i; j < i+32; j++ {
 			if j >= len(value) {
-				fmt.Printf("   ")
+				fmt.Fprintf(w, "   ")
 			} else {
 				if value[j] >= 33 && value[j] <= 126 {
-					fmt.Printf("%c", value[j])
+					fmt.Fprintf(w, "%c", value[j])
 				} else {
-					fmt.Printf(".")
+					fmt.Fprintf(w, ".")
 				}
 
 			}
 		}
-		fmt.Println()
+		fmt.Fprintln(w)
 	}
 }
 
-func PrintMultiline(indented bool, value string) {
+func PrintMultiline(w io.Writer, depth int, value string) {
 	lines := strings.Split(value, "\n")
 	for _, line := range lines {
-		if indented {
-			fmt.Printf("  ")
-		}
-		fmt.Printf("%.120s", line)
+		indent(w, depth)
+		fmt.Fprintf(w, "%.120s", line)
 		if len(line) > 120 {
-			fmt.Printf("...")
+			fmt.Fprintf(w, "...")
 		}
-		fmt.Println()
+		fmt.Fprintln(w)
 	}
 }
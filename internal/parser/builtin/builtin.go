@@ -0,0 +1,28 @@
+// Package builtin lists the parsers of every supported format, shared by the CLI and the public API.
+package builtin
+
+import (
+	"jch-metadata/internal/parser"
+	"jch-metadata/internal/parser/elf"
+	"jch-metadata/internal/parser/flac"
+	"jch-metadata/internal/parser/jpeg"
+	"jch-metadata/internal/parser/mkv"
+	"jch-metadata/internal/parser/mp4"
+	"jch-metadata/internal/parser/png"
+	"jch-metadata/internal/parser/tar"
+	"jch-metadata/internal/parser/webp"
+	"jch-metadata/internal/parser/zip"
+)
+
+// Parsers are tried in order, so formats with a more specific signature come first.
+var Parsers = []parser.Parser{
+	flac.Parser,
+	jpeg.Parser,
+	png.Parser,
+	webp.Parser,
+	mkv.Parser,
+	mp4.Parser,
+	elf.Parser,
+	zip.Parser,
+	tar.Parser,
+}
@@ -0,0 +1,40 @@
+package parser
+
+import (
+	"fmt"
+	"jch-metadata/internal/document"
+)
+
+const (
+	RemoveOperation    = "remove"
+	ZeroOperation      = "zero"
+	ObfuscateOperation = "obfuscate"
+	WriteOperation     = "write"
+	RewriteOperation   = "rewrite"
+)
+
+// Change is a modification made by the clear or set action, such as removing a segment or zeroing an element.
+type Change struct {
+	Operation string
+	Target    string
+	Offset    int64
+	Size      int64
+}
+
+// DescribeChanges returns a document listing changes that would be made by the clear or set action.
+func DescribeChanges(changes []Change) *document.Document {
+	doc := &document.Document{}
+	if len(changes) == 0 {
+		doc.AddMessage("Nothing to clear")
+		return doc
+	}
+	for i, c := range changes {
+		section := doc.AddSection(fmt.Sprintf("change.%d", i), fmt.Sprintf("Change %d", i+1))
+		section.AddString("operation", "Operation", c.Operation)
+		section.AddString("target", "Target", c.Target)
+		section.AddInt("offset", "Offset", c.Offset)
+		section.AddInt("size", "Size", c.Size)
+	}
+	doc.AddMessage("Dry run: %d changes would be made, nothing has been written", len(changes))
+	return doc
+}
@@ -0,0 +1,65 @@
+package elf
+
+import (
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Audit returns the directories of absolute source file paths in DWARF and .gopclntab, which may contain user
+// names, and the VCS commit time of the Go build info.  Files of the Go standard library are reported once as GOROOT.
+func Audit(r io.ReaderAt) ([]parser.Finding, error) {
+	metadata, err := GetMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	var result []parser.Finding
+	result = append(result, auditSourcePaths("DWARF", metadata.DWARFFiles)...)
+	result = append(result, auditSourcePaths(".gopclntab", metadata.PclntabFiles)...)
+	if metadata.BuildInfo != nil {
+		for _, s := range metadata.BuildInfo.Settings {
+			if s.Key == "vcs.time" {
+				result = append(result, parser.Finding{Category: parser.TimestampCategory, Source: "Go build info vcs.time", Value: s.Value})
+			}
+		}
+	}
+	return result, nil
+}
+
+func auditSourcePaths(source string, files []string) []parser.Finding {
+	goroot := ""
+	for _, f := range files {
+		if strings.HasSuffix(f, "/src/runtime/proc.go") {
+			goroot = strings.TrimSuffix(f, "/src/runtime/proc.go")
+			break
+		}
+	}
+	directories := make(map[string]bool)
+	for _, f := range files {
+		if !strings.HasPrefix(f, "/") || (goroot != "" && strings.HasPrefix(f, goroot+"/")) {
+			continue
+		}
+		if i := strings.Index(f, "/pkg/mod/"); i >= 0 {
+			// Files of dependencies are reported by the module cache directory
+			directories[f[:i+8]] = true
+		} else {
+			directories[path.Dir(f)] = true
+		}
+	}
+	var result []parser.Finding
+	if goroot != "" {
+		result = append(result, parser.Finding{Category: parser.BuildPathCategory, Source: fmt.Sprintf("%s GOROOT", source), Value: goroot})
+	}
+	sorted := make([]string, 0, len(directories))
+	for d := range directories {
+		sorted = append(sorted, d)
+	}
+	sort.Strings(sorted)
+	for _, d := range sorted {
+		result = append(result, parser.Finding{Category: parser.BuildPathCategory, Source: fmt.Sprintf("%s source directory", source), Value: d})
+	}
+	return result
+}
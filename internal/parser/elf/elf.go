@@ -7,87 +7,111 @@ import (
 	"debug/elf"
 	"debug/gosym"
 	"fmt"
-	"jch-metadata/internal/output"
+	"io"
+	"jch-metadata/internal/document"
 	"jch-metadata/internal/parser"
 	"math/rand"
-	"os"
 	"sort"
 )
 
 var Parser = parser.Parser{
 	Name:      "ELF (Executable)",
 	Container: false,
-	Support: func(file *os.File, startOffset int64, length int64) (bool, error) {
-		return IsELF(file, startOffset)
+	Support: func(r io.ReaderAt, size int64) (bool, error) {
+		return IsELF(r)
 	},
-	Handle: func(file *os.File, action parser.Action, startOffset int64, length int64, parsers []parser.Parser) error {
+	Handle: func(input *parser.Input, action parser.Action, parsers []parser.Parser, options parser.Options) (*document.Document, error) {
 		if action == parser.ShowAction {
-			err := ShowMetadata(file)
+			metadata, err := GetMetadata(input.Reader)
 			if err != nil {
-				return err
+				return nil, err
 			}
+			return metadata.Describe(), nil
 		} else if action == parser.ClearAction {
-			err := ClearMetadata(file)
+			return ClearMetadata(input.Reader, input.Writer, options.Selector, options.DryRun)
+		} else if action == parser.VerifyAction {
+			problems, err := Verify(input.Reader, input.Size)
 			if err != nil {
-				return err
+				return nil, err
 			}
+			return parser.DescribeProblems(problems), nil
+		} else if action == parser.AuditAction {
+			findings, err := Audit(input.Reader)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeFindings(findings, options.Policy), nil
 		}
-		return nil
+		return document.Message("Unsupported action: %s", action), nil
 	},
 }
 
-func IsELF(file *os.File, startOffset int64) (bool, error) {
+func IsELF(r io.ReaderAt) (bool, error) {
 	magicBytes := make([]byte, 4)
-	_, err := file.ReadAt(magicBytes, startOffset)
+	_, err := r.ReadAt(magicBytes, 0)
 	if err != nil {
 		return false, fmt.Errorf("failed to read file: %w", err)
 	}
 	return bytes.Equal(magicBytes, []byte{0x7F, 0x45, 0x4C, 0x46}), nil
 }
 
-func ShowMetadata(file *os.File) error {
-	dwarfFiles, err := GetDWARFFiles(file)
+func GetMetadata(r io.ReaderAt) (*Metadata, error) {
+	dwarfFiles, err := GetDWARFFiles(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if dwarfFiles != nil {
-		output.PrintHeader(false, "DWARF Source Files")
-		for _, f := range dwarfFiles {
-			output.Println(false, f)
-		}
-		output.Println(false)
+	pclntabFiles, err := GetPclntabFiles(r)
+	if err != nil {
+		return nil, err
 	}
-	pclntabFiles, err := GetPclntabFiles(file)
+	buildId, err := GetBuildId(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if pclntabFiles != nil {
-		output.PrintHeader(false, "Go Symbol Table Files")
-		for _, f := range pclntabFiles {
-			output.Println(false, f)
-		}
-		output.Println(false)
+	return &Metadata{
+		DWARFFiles:   dwarfFiles,
+		PclntabFiles: pclntabFiles,
+		BuildID:      buildId,
+		BuildInfo:    GetBuildInfo(r),
+	}, nil
+}
+
+type Metadata struct {
+	DWARFFiles   []string             `json:"dwarfFiles,omitempty"`
+	PclntabFiles []string             `json:"pclntabFiles,omitempty"`
+	BuildID      string               `json:"buildId,omitempty"`
+	BuildInfo    *buildinfo.BuildInfo `json:"buildInfo,omitempty"`
+}
+
+func (m *Metadata) Describe() *document.Document {
+	doc := &document.Document{}
+	if m.DWARFFiles != nil {
+		doc.AddSection("dwarf", "DWARF Source Files").AddList("files", "", m.DWARFFiles)
 	}
-	buildId, err := GetBuildId(file)
-	if err != nil {
-		return err
+	if m.PclntabFiles != nil {
+		doc.AddSection("pclntab", "Go Symbol Table Files").AddList("files", "", m.PclntabFiles)
 	}
-	if buildId != "" {
-		output.PrintHeader(false, "Go Build ID")
-		output.Println(false, buildId)
-		output.Println(false)
+	if m.BuildID != "" {
+		doc.AddSection("buildId", "Go Build ID").AddString("buildId", "Build ID", m.BuildID)
 	}
-	buildInfo := GetBuildInfo(file)
-	if buildInfo != nil {
-		output.PrintHeader(false, "Go Build Info")
-		output.Println(false, buildInfo)
+	if m.BuildInfo != nil {
+		section := doc.AddSection("buildInfo", "Go Build Info")
+		section.AddString("goVersion", "Go Version", m.BuildInfo.GoVersion)
+		section.AddString("path", "Path", m.BuildInfo.Path)
+		section.AddString("main", "Main Module", fmt.Sprintf("%s %s", m.BuildInfo.Main.Path, m.BuildInfo.Main.Version))
+		for _, d := range m.BuildInfo.Deps {
+			section.AddString("dep."+d.Path, "Dependency", fmt.Sprintf("%s %s", d.Path, d.Version))
+		}
+		for _, setting := range m.BuildInfo.Settings {
+			section.AddString("setting."+setting.Key, setting.Key, setting.Value)
+		}
 	}
-	return nil
+	return doc
 }
 
-func GetDWARFFiles(file *os.File) ([]string, error) {
+func GetDWARFFiles(r io.ReaderAt) ([]string, error) {
 	var result = make(map[string]bool)
-	elfFile, err := elf.NewFile(file)
+	elfFile, err := elf.NewFile(r)
 	if err != nil {
 		return nil, fmt.Errorf("error opening ELF file: %w", err)
 	}
@@ -133,8 +157,8 @@ func GetDWARFFiles(file *os.File) ([]string, error) {
 	return files, nil
 }
 
-func GetPclntabFiles(file *os.File) ([]string, error) {
-	elfFile, err := elf.NewFile(file)
+func GetPclntabFiles(r io.ReaderAt) ([]string, error) {
+	elfFile, err := elf.NewFile(r)
 	if err != nil {
 		return nil, fmt.Errorf("error opening ELF file: %w", err)
 	}
@@ -167,8 +191,8 @@ func GetPclntabFiles(file *os.File) ([]string, error) {
 	return files, nil
 }
 
-func GetBuildId(file *os.File) (string, error) {
-	elfFile, err := elf.NewFile(file)
+func GetBuildId(r io.ReaderAt) (string, error) {
+	elfFile, err := elf.NewFile(r)
 	if err != nil {
 		return "", fmt.Errorf("error opening ELF file: %w", err)
 	}
@@ -183,58 +207,87 @@ func GetBuildId(file *os.File) (string, error) {
 	return string(data[16 : len(data)-1]), nil
 }
 
-func GetBuildInfo(file *os.File) *buildinfo.BuildInfo {
-	info, err := buildinfo.Read(file)
+func GetBuildInfo(r io.ReaderAt) *buildinfo.BuildInfo {
+	info, err := buildinfo.Read(r)
 	if err != nil {
 		return nil
 	}
 	return info
 }
 
-func ClearMetadata(file *os.File) error {
-	elfFile, err := elf.NewFile(file)
+// ClearMetadata obfuscates source file names ("elf.pclntab") and the Go build ID ("elf.buildid") chosen by selector.
+// If dryRun is true, the changes are returned without writing them.
+func ClearMetadata(r io.ReaderAt, w io.WriterAt, selector parser.Selector, dryRun bool) (*document.Document, error) {
+	doc := &document.Document{}
+	var changes []parser.Change
+	elfFile, err := elf.NewFile(r)
 	if err != nil {
-		return fmt.Errorf("error opening ELF file: %w", err)
+		return nil, fmt.Errorf("error opening ELF file: %w", err)
 	}
-	pclntabFiles, err := GetPclntabFiles(file)
-	if err != nil {
-		return fmt.Errorf("error reading .gopclntab section: %w", err)
+	var pclntabFiles []string
+	if selector.Removes("elf.pclntab") {
+		pclntabFiles, err = GetPclntabFiles(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading .gopclntab section: %w", err)
+		}
 	}
-	if pclntabFiles != nil {
-		fmt.Println("Found .gopclntab section.  Obfuscating file names...")
+	if pclntabFiles != nil && dryRun {
+		pclntab := elfFile.Section(".gopclntab")
+		changes = append(changes, parser.Change{
+			Operation: parser.ObfuscateOperation,
+			Target:    fmt.Sprintf(".gopclntab section (%d file names)", len(pclntabFiles)),
+			Offset:    int64(pclntab.Offset),
+			Size:      int64(pclntab.Size),
+		})
+	} else if pclntabFiles != nil {
 		pclntab := elfFile.Section(".gopclntab")
 		data, err := pclntab.Data()
 		if err != nil {
-			return fmt.Errorf("error reading .gopclntab data: %w", err)
+			return nil, fmt.Errorf("error reading .gopclntab data: %w", err)
 		}
 		for _, f := range pclntabFiles {
 			ObfuscateString(data, f)
 		}
-		_, err = file.WriteAt(data, int64(pclntab.Offset))
+		_, err = w.WriteAt(data, int64(pclntab.Offset))
 		if err != nil {
-			return fmt.Errorf("error writing to file: %w", err)
+			return nil, fmt.Errorf("error writing to file: %w", err)
 		}
-		fmt.Println("Obfuscated file names has been saved!")
+		doc.AddMessage("Obfuscated file names in .gopclntab section has been saved!")
 	}
-	goBuildIdSection := elfFile.Section(".note.go.buildid")
-	if goBuildIdSection != nil {
-		fmt.Println("Found .note.go.buildid.  Obfuscating value...")
+	var goBuildIdSection *elf.Section
+	if selector.Removes("elf.buildid") {
+		goBuildIdSection = elfFile.Section(".note.go.buildid")
+	}
+	if goBuildIdSection != nil && dryRun {
+		changes = append(changes, parser.Change{
+			Operation: parser.ObfuscateOperation,
+			Target:    ".note.go.buildid section",
+			Offset:    int64(goBuildIdSection.Offset) + 16,
+			Size:      int64(goBuildIdSection.Size) - 17,
+		})
+	} else if goBuildIdSection != nil {
 		data, err := goBuildIdSection.Data()
 		if err != nil {
-			return fmt.Errorf("erro reading .note.go.buildid data: %w", err)
+			return nil, fmt.Errorf("erro reading .note.go.buildid data: %w", err)
 		}
 		for i := 16; i < len(data)-1; i++ {
 			if data[i] != '/' {
 				data[i] = byte(97 + rand.Intn(26))
 			}
 		}
-		_, err = file.WriteAt(data, int64(goBuildIdSection.Offset))
+		_, err = w.WriteAt(data, int64(goBuildIdSection.Offset))
 		if err != nil {
-			return fmt.Errorf("error writing to file: %w", err)
+			return nil, fmt.Errorf("error writing to file: %w", err)
 		}
-		fmt.Println("Obfuscated Build ID has been saved!")
+		doc.AddMessage("Obfuscated Build ID has been saved!")
+	}
+	if dryRun {
+		return parser.DescribeChanges(changes), nil
+	}
+	if len(doc.Messages) == 0 {
+		doc.AddMessage("Nothing to clear")
 	}
-	return nil
+	return doc, nil
 }
 
 func ObfuscateString(source []byte, target string) {
@@ -0,0 +1,32 @@
+package elf
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+)
+
+// Verify checks that the ELF headers can be parsed and that sections and segments fit in the file.
+func Verify(r io.ReaderAt, size int64) ([]parser.Problem, error) {
+	var problems []parser.Problem
+	file, err := elf.NewFile(r)
+	if err != nil {
+		problems = append(problems, parser.NewError("ELF header", 0, "%s", err))
+		return problems, nil
+	}
+	for _, s := range file.Sections {
+		if s.Type == elf.SHT_NOBITS || s.Type == elf.SHT_NULL {
+			continue
+		}
+		if s.Offset > uint64(size) || s.FileSize > uint64(size)-s.Offset {
+			problems = append(problems, parser.NewError(fmt.Sprintf("%s section", s.Name), int64(s.Offset), "section of %d bytes overruns the end of file", s.FileSize))
+		}
+	}
+	for i, p := range file.Progs {
+		if p.Off > uint64(size) || p.Filesz > uint64(size)-p.Off {
+			problems = append(problems, parser.NewError(fmt.Sprintf("segment %d (%s)", i, p.Type), int64(p.Off), "segment of %d bytes overruns the end of file", p.Filesz))
+		}
+	}
+	return problems, nil
+}
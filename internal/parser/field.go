@@ -0,0 +1,22 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Field is a metadata value written by the set action.  An empty Value removes the field.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// ParseField parses a field written as KEY=VALUE.
+func ParseField(value string) (Field, error) {
+	key, v, found := strings.Cut(value, "=")
+	key = strings.TrimSpace(key)
+	if !found || key == "" {
+		return Field{}, fmt.Errorf("invalid field %s, expected KEY=VALUE", value)
+	}
+	return Field{Key: key, Value: v}, nil
+}
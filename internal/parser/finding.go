@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"fmt"
+	"jch-metadata/internal/document"
+	"strings"
+)
+
+const (
+	LocationCategory  = "location"
+	SerialCategory    = "serial"
+	PersonCategory    = "person"
+	SoftwareCategory  = "software"
+	BuildPathCategory = "build-path"
+	TimestampCategory = "timestamp"
+)
+
+// Categories are the kinds of personal data reported by the audit action, from the most to the least sensitive.
+var Categories = []string{LocationCategory, SerialCategory, PersonCategory, SoftwareCategory, BuildPathCategory, TimestampCategory}
+
+// DefaultPolicy are the categories that make a file fail the audit action unless another policy is configured.
+var DefaultPolicy = []string{LocationCategory, SerialCategory, PersonCategory, BuildPathCategory}
+
+// Finding is a metadata value that may leak personal data, found by the audit action.
+type Finding struct {
+	Category string
+	// Source is the metadata holding the value, such as "EXIF GPS IFD" or "XMP dc:creator".
+	Source string
+	Value  string
+}
+
+// DescribeFindings returns a document with a risk summary and the findings of the audit action.  Findings in a
+// category of policy are marked as violations.
+func DescribeFindings(findings []Finding, policy []string) *document.Document {
+	doc := &document.Document{}
+	counts := make(map[string]int)
+	violations := 0
+	for _, f := range findings {
+		counts[f.Category] += 1
+	}
+	summary := doc.AddSection("audit", "Privacy Audit")
+	summary.AddString("risk", "Risk", riskLevel(counts))
+	for _, c := range Categories {
+		if counts[c] > 0 {
+			summary.AddInt(c, c, int64(counts[c]))
+		}
+	}
+	for i, f := range findings {
+		violation := false
+		for _, p := range policy {
+			if p == f.Category {
+				violation = true
+			}
+		}
+		section := doc.AddSection(fmt.Sprintf("finding.%d", i), fmt.Sprintf("Finding %d", i+1))
+		section.AddString("category", "Category", f.Category)
+		section.AddString("source", "Source", f.Source)
+		section.AddString("value", "Value", f.Value)
+		section.AddBool("violation", "Violation", violation)
+		if violation {
+			violations += 1
+		}
+	}
+	if len(findings) == 0 {
+		doc.AddMessage("No personal data found")
+	} else {
+		doc.AddMessage("Found %d values, %d of them violate the policy (%s)", len(findings), violations, strings.Join(policy, ","))
+	}
+	return doc
+}
+
+func riskLevel(counts map[string]int) string {
+	if counts[LocationCategory]+counts[SerialCategory]+counts[PersonCategory] > 0 {
+		return "high"
+	} else if counts[BuildPathCategory]+counts[SoftwareCategory] > 0 {
+		return "medium"
+	} else if counts[TimestampCategory] > 0 {
+		return "low"
+	}
+	return "none"
+}
+
+// CountViolations returns the number of findings that violate the policy in doc and its nested documents.
+func CountViolations(doc *document.Document) int {
+	return countViolations(&doc.Section)
+}
+
+func countViolations(section *document.Section) int {
+	result := 0
+	for _, s := range section.Sections {
+		if violation := s.FindField("violation"); violation != nil && violation.Value == true {
+			result += 1
+		}
+		result += countViolations(s)
+	}
+	for _, d := range section.Documents {
+		result += countViolations(&d.Section)
+	}
+	return result
+}
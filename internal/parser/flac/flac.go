@@ -4,80 +4,171 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"jch-metadata/internal/output"
+	"io"
+	"jch-metadata/internal/document"
 	"jch-metadata/internal/parser"
-	"os"
+	"jch-metadata/internal/parser/shared"
+	"strings"
 )
 
 var Parser = parser.Parser{
 	Name:      "FLAC",
 	Container: false,
-	Support: func(file *os.File, startOffset int64, length int64) (bool, error) {
-		return IsFLAC(file, startOffset)
+	Support: func(r io.ReaderAt, size int64) (bool, error) {
+		return IsFLAC(r)
 	},
-	Handle: func(file *os.File, action parser.Action, startOffset int64, length int64, parsers []parser.Parser) error {
-		metadata, err := GetMetadata(file, startOffset)
+	Handle: func(input *parser.Input, action parser.Action, parsers []parser.Parser, options parser.Options) (*document.Document, error) {
+		if action == parser.VerifyAction {
+			problems, err := Verify(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeProblems(problems), nil
+		}
+		if action == parser.AuditAction {
+			metadata, err := ExportMetadata(input.Reader)
+			if err != nil {
+				return nil, err
+			}
+			findings, err := shared.AuditMetadata(metadata)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeFindings(findings, options.Policy), nil
+		}
+		if action == parser.HashAction {
+			ranges, err := EssenceRanges(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeHash(input.Reader, ranges)
+		}
+		metadata, err := GetMetadata(input.Reader)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if action == parser.ShowAction {
-			found := false
+			result := Comments{}
 			for _, m := range metadata {
 				if m.Type == 4 {
 					comment, err := m.GetVorbisComment()
 					if err != nil {
-						return err
+						return nil, err
 					}
-					output.PrintForm(startOffset > 0, "Vendor String", comment.VendorString, 13)
-					output.PrintHeader(startOffset > 0, "User Comments")
-					for _, c := range comment.UserComment {
-						output.Printf(startOffset > 0, " %s\n", c)
-					}
-					output.Println(startOffset > 0)
-					found = true
+					result.VorbisComments = append(result.VorbisComments, *comment)
 				}
 			}
-			if !found {
-				output.Println(startOffset > 0, "Vorbis comment metadata not found!")
+			doc := result.Describe()
+			index := 0
+			for _, m := range metadata {
+				if m.Type != 6 {
+					continue
+				}
+				picture, err := m.GetPicture()
+				if err != nil {
+					return nil, err
+				}
+				err = picture.Describe(doc, input, parsers, options, index)
+				if err != nil {
+					return nil, err
+				}
+				index++
 			}
+			return doc, nil
 		} else if action == parser.ClearAction {
-			found := false
+			if !options.Selector.Removes("comments", "flac.comments") {
+				if options.DryRun {
+					return parser.DescribeChanges(nil), nil
+				}
+				return document.Message("Vorbis comment has been kept!"), nil
+			}
+			if options.DryRun {
+				var changes []parser.Change
+				for _, m := range metadata {
+					if m.Type == 4 {
+						changes = append(changes, parser.Change{
+							Operation: parser.ZeroOperation,
+							Target:    "Vorbis comment block (converted into padding)",
+							Offset:    m.StartAt,
+							Size:      4 + int64(m.Length),
+						})
+					}
+				}
+				return parser.DescribeChanges(changes), nil
+			}
+			doc := &document.Document{}
 			for _, m := range metadata {
 				if m.Type == 4 {
-					fmt.Println("Converting Vorbis comment into padding...")
-					err = m.ConvertToPadding()
+					err = m.ConvertToPadding(input.Writer)
 					if err != nil {
-						return err
+						return nil, err
 					}
-					fmt.Println("Vorbis comment has been converted into padding!")
-					found = true
+					doc.AddMessage("Vorbis comment has been converted into padding!")
 				}
 			}
-			if !found {
-				fmt.Println("Vorbis comment metadata not found!")
+			if doc.Messages == nil {
+				doc.AddMessage("Vorbis comment metadata not found!")
+			}
+			return doc, nil
+		} else if action == parser.SetAction {
+			changes, err := SetVorbisComment(input.Reader, input.Size, input.Writer, options.Fields, options.DryRun)
+			if err != nil {
+				return nil, err
+			}
+			if options.DryRun {
+				return parser.DescribeChanges(changes), nil
 			}
-		} else {
-			fmt.Printf("Unssuported action: %s\n", action)
+			return document.Message("Vorbis comment has been written!"), nil
+		} else if action == parser.CopyAction {
+			var changes []parser.Change
+			if len(options.Source.Comments) > 0 {
+				changes, err = SetVorbisComment(input.Reader, input.Size, input.Writer, options.Source.Comments, options.DryRun)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return parser.DescribeCopy(options.Source, []string{parser.CommentsKind}, changes, options.DryRun), nil
 		}
-		return nil
+		return document.Message("Unssuported action: %s", action), nil
+	},
+	Export: func(input *parser.Input) (*parser.Metadata, error) {
+		return ExportMetadata(input.Reader)
+	},
+	Items: func(input *parser.Input) ([]*parser.Input, error) {
+		metadata, err := GetMetadata(input.Reader)
+		if err != nil {
+			return nil, err
+		}
+		var result []*parser.Input
+		for _, m := range metadata {
+			if m.Type != 6 {
+				continue
+			}
+			picture, err := m.GetPicture()
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, picture.Item(input, len(result)))
+		}
+		return result, nil
 	},
 }
 
-func IsFLAC(file *os.File, startOffset int64) (bool, error) {
+func IsFLAC(r io.ReaderAt) (bool, error) {
 	magicBytes := make([]byte, 4)
-	_, err := file.ReadAt(magicBytes, startOffset)
+	_, err := r.ReadAt(magicBytes, 0)
 	if err != nil {
 		return false, fmt.Errorf("failed to read file: %w", err)
 	}
 	return bytes.Equal(magicBytes, []byte{0x66, 0x4C, 0x61, 0x43}), nil
 }
 
-func GetMetadata(file *os.File, offset int64) ([]Metadata, error) {
+func GetMetadata(r io.ReaderAt) ([]Metadata, error) {
 	var result []Metadata
-	offset = offset + int64(4)
+	offset := int64(4)
 	for {
 		header := make([]byte, 4)
-		_, err := file.ReadAt(header, offset)
+		_, err := r.ReadAt(header, offset)
 		if err != nil {
 			return result, fmt.Errorf("failed to read file: %w", err)
 		}
@@ -85,13 +176,12 @@ func GetMetadata(file *os.File, offset int64) ([]Metadata, error) {
 			StartAt: offset,
 			Type:    header[0] & 0x7F,
 			Length:  binary.BigEndian.Uint32([]byte{0, header[1], header[2], header[3]}),
-			File:    file,
-			Last:    false,
+			Reader:  r,
+			Last:    header[0]>>7 == 1,
 		}
 		result = append(result, metadata)
 		offset += int64(metadata.Length) + 4
-		if header[0]>>7 == 1 {
-			metadata.Last = true
+		if metadata.Last {
 			break
 		}
 	}
@@ -102,7 +192,7 @@ type Metadata struct {
 	StartAt int64
 	Type    byte
 	Length  uint32
-	File    *os.File
+	Reader  io.ReaderAt
 	Last    bool
 }
 
@@ -111,7 +201,7 @@ func (m *Metadata) GetVorbisComment() (*VorbisComment, error) {
 		return nil, fmt.Errorf("this metadata type %d doesn't contain Vorbis Comment", m.Type)
 	}
 	data := make([]byte, m.Length)
-	_, err := m.File.ReadAt(data, m.StartAt+4)
+	_, err := m.Reader.ReadAt(data, m.StartAt+4)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
@@ -133,9 +223,130 @@ func (m *Metadata) GetVorbisComment() (*VorbisComment, error) {
 	return &result, nil
 }
 
-func (m *Metadata) ConvertToPadding() error {
-	data := make([]byte, 6+m.Length)
-	_, err := m.File.ReadAt(data, m.StartAt)
+// Picture is the header of a PICTURE block, such as the front cover of an album.
+type Picture struct {
+	Type        uint32 `json:"type"`
+	MediaType   string `json:"mediaType"`
+	Description string `json:"description"`
+	Width       uint32 `json:"width"`
+	Height      uint32 `json:"height"`
+	Depth       uint32 `json:"depth"`
+	Colors      uint32 `json:"colors"`
+	DataAt      int64  `json:"offset"`
+	Size        int64  `json:"size"`
+}
+
+// GetPicture reads the header of a PICTURE block.  The picture data isn't read.
+func (m *Metadata) GetPicture() (*Picture, error) {
+	if m.Type != 6 {
+		return nil, fmt.Errorf("this metadata type %d doesn't contain a picture", m.Type)
+	}
+	data := make([]byte, m.Length)
+	_, err := m.Reader.ReadAt(data, m.StartAt+4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	readString := func(offset int) (string, int, error) {
+		if offset+4 > len(data) {
+			return "", 0, fmt.Errorf("picture block at offset %d is truncated", m.StartAt)
+		}
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		if length < 0 || offset+4+length > len(data) {
+			return "", 0, fmt.Errorf("picture block at offset %d is truncated", m.StartAt)
+		}
+		return string(data[offset+4 : offset+4+length]), offset + 4 + length, nil
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("picture block at offset %d is truncated", m.StartAt)
+	}
+	result := Picture{Type: binary.BigEndian.Uint32(data[0:4])}
+	offset := 4
+	result.MediaType, offset, err = readString(offset)
+	if err != nil {
+		return nil, err
+	}
+	result.Description, offset, err = readString(offset)
+	if err != nil {
+		return nil, err
+	}
+	if offset+20 > len(data) {
+		return nil, fmt.Errorf("picture block at offset %d is truncated", m.StartAt)
+	}
+	result.Width = binary.BigEndian.Uint32(data[offset : offset+4])
+	result.Height = binary.BigEndian.Uint32(data[offset+4 : offset+8])
+	result.Depth = binary.BigEndian.Uint32(data[offset+8 : offset+12])
+	result.Colors = binary.BigEndian.Uint32(data[offset+12 : offset+16])
+	result.Size = int64(binary.BigEndian.Uint32(data[offset+16 : offset+20]))
+	result.DataAt = m.StartAt + 4 + int64(offset) + 20
+	if result.DataAt+result.Size > m.StartAt+4+int64(m.Length) {
+		return nil, fmt.Errorf("picture data at offset %d overruns its block", result.DataAt)
+	}
+	return &result, nil
+}
+
+// Item returns the picture data as nested content.
+func (p *Picture) Item(input *parser.Input, index int) *parser.Input {
+	name := fmt.Sprintf("picture_%02d", index)
+	if _, subtype, found := strings.Cut(p.MediaType, "/"); found {
+		name += "." + subtype
+	}
+	return input.Item("picture", index, name, p.DataAt, p.Size)
+}
+
+// Describe adds the picture to doc.  The picture data is parsed into a nested document.
+func (p *Picture) Describe(doc *document.Document, input *parser.Input, parsers []parser.Parser, options parser.Options, index int) error {
+	section := doc.AddSection(fmt.Sprintf("picture.%d", index), "Picture")
+	section.AddString("type", "Type", PictureTypeName(p.Type))
+	section.AddString("mediaType", "Media Type", p.MediaType)
+	section.AddString("description", "Description", p.Description)
+	section.AddString("dimension", "Dimension", fmt.Sprintf("%dx%d", p.Width, p.Height))
+	section.AddUint("depth", "Color Depth", uint64(p.Depth))
+	section.AddInt("size", "Size", p.Size)
+	if p.MediaType == "-->" {
+		// The picture data is an URL
+		return nil
+	}
+	return parser.DescribeItem(section, parsers, p.Item(input, index), parser.ShowAction, options)
+}
+
+var pictureTypes = []string{"Other", "File Icon", "Other File Icon", "Front Cover", "Back Cover", "Leaflet Page", "Media",
+	"Lead Artist", "Artist", "Conductor", "Band", "Composer", "Lyricist", "Recording Location", "During Recording",
+	"During Performance", "Movie Screen Capture", "Bright Coloured Fish", "Illustration", "Band Logotype",
+	"Publisher Logotype"}
+
+func PictureTypeName(pictureType uint32) string {
+	if int(pictureType) < len(pictureTypes) {
+		return pictureTypes[pictureType]
+	}
+	return fmt.Sprintf("Unknown (%d)", pictureType)
+}
+
+// ExportMetadata returns the user comments of the Vorbis comment blocks.
+func ExportMetadata(r io.ReaderAt) (*parser.Metadata, error) {
+	metadata, err := GetMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	result := parser.Metadata{}
+	for _, m := range metadata {
+		if m.Type != 4 {
+			continue
+		}
+		comment, err := m.GetVorbisComment()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range comment.UserComment {
+			name, value, _ := strings.Cut(c, "=")
+			result.AddComment(name, value)
+		}
+	}
+	return &result, nil
+}
+
+func (m *Metadata) ConvertToPadding(w io.WriterAt) error {
+	data := make([]byte, 4+m.Length)
+	_, err := m.Reader.ReadAt(data, m.StartAt)
 	if err != nil {
 		return err
 	}
@@ -147,14 +358,200 @@ func (m *Metadata) ConvertToPadding() error {
 	for i := 4; i < len(data); i++ {
 		data[i] = 0
 	}
-	_, err = m.File.WriteAt(data, m.StartAt)
+	_, err = w.WriteAt(data, m.StartAt)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// SetVorbisComment writes fields into the Vorbis comment block, creating the block if it doesn't exist.  Comments
+// with the same name are replaced, or removed if the value is empty.  The block is written in place if it fits in the
+// space used by the current block and a following padding block, otherwise the file is rewritten.  If dryRun is true,
+// the change is returned without writing it.
+func SetVorbisComment(r io.ReaderAt, size int64, w parser.Writer, fields []parser.Field, dryRun bool) ([]parser.Change, error) {
+	metadata, err := GetMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	comment := &VorbisComment{VendorString: "jch-metadata"}
+	commentIndex := -1
+	paddingIndex := -1
+	for i, m := range metadata {
+		if m.Type == 4 && commentIndex < 0 {
+			commentIndex = i
+			comment, err = m.GetVorbisComment()
+			if err != nil {
+				return nil, err
+			}
+		} else if m.Type == 1 && paddingIndex < 0 {
+			paddingIndex = i
+		}
+	}
+	for _, f := range fields {
+		comment.Set(f.Key, f.Value)
+	}
+	data := comment.Encode()
+	if len(data) >= 1<<24 {
+		return nil, fmt.Errorf("Vorbis comment is too large: %d bytes", len(data))
+	}
+
+	// The new block can replace the current block and the padding block that directly follows it
+	var region []Metadata
+	if commentIndex >= 0 {
+		region = append(region, metadata[commentIndex])
+		if commentIndex+1 < len(metadata) && metadata[commentIndex+1].Type == 1 {
+			region = append(region, metadata[commentIndex+1])
+		}
+	} else if paddingIndex >= 0 {
+		region = append(region, metadata[paddingIndex])
+	}
+	if len(region) > 0 {
+		regionSize := int64(0)
+		for _, m := range region {
+			regionSize += 4 + int64(m.Length)
+		}
+		last := region[len(region)-1].Last
+		remaining := regionSize - 4 - int64(len(data))
+		if remaining == 0 || remaining >= 4 {
+			block := blockHeader(4, last && remaining == 0, len(data))
+			block = append(block, data...)
+			if remaining > 0 {
+				block = append(block, blockHeader(1, last, int(remaining)-4)...)
+				block = append(block, make([]byte, remaining-4)...)
+			}
+			changes := []parser.Change{{
+				Operation: parser.WriteOperation,
+				Target:    "Vorbis comment block",
+				Offset:    region[0].StartAt,
+				Size:      regionSize,
+			}}
+			if dryRun {
+				return changes, nil
+			}
+			_, err = w.WriteAt(block, region[0].StartAt)
+			if err != nil {
+				return nil, fmt.Errorf("error writing to file: %w", err)
+			}
+			return changes, nil
+		}
+	}
+
+	// Rewrite the file with the new block in place of the current block or after the STREAMINFO block
+	var blocks [][]byte
+	offset := int64(4)
+	for i, m := range metadata {
+		if i == commentIndex {
+			blocks = append(blocks, append(blockHeader(4, false, len(data)), data...))
+			continue
+		}
+		block := make([]byte, 4+m.Length)
+		_, err = r.ReadAt(block, m.StartAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		block[0] = m.Type
+		blocks = append(blocks, block)
+		if i < commentIndex || (i == 0 && commentIndex < 0) {
+			offset += int64(len(block))
+		}
+		if i == 0 && commentIndex < 0 {
+			blocks = append(blocks, append(blockHeader(4, false, len(data)), data...))
+		}
+	}
+	blocks[len(blocks)-1][0] |= 0x80
+	changes := []parser.Change{{
+		Operation: parser.RewriteOperation,
+		Target:    "Vorbis comment block",
+		Offset:    offset,
+		Size:      4 + int64(len(data)),
+	}}
+	if dryRun {
+		return changes, nil
+	}
+	last := metadata[len(metadata)-1]
+	audioAt := last.StartAt + 4 + int64(last.Length)
+	err = w.Replace(func(writer io.Writer) error {
+		_, err := writer.Write([]byte{0x66, 0x4C, 0x61, 0x43})
+		if err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			_, err = writer.Write(block)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = io.Copy(writer, io.NewSectionReader(r, audioAt, size-audioAt))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func blockHeader(blockType byte, last bool, length int) []byte {
+	header := []byte{blockType, byte(length >> 16), byte(length >> 8), byte(length)}
+	if last {
+		header[0] |= 0x80
+	}
+	return header
+}
+
 type VorbisComment struct {
-	VendorString string
-	UserComment  []string
+	VendorString string   `json:"vendor"`
+	UserComment  []string `json:"userComments"`
+}
+
+// Set replaces the comments named name with a single comment holding value.  Names are compared case-insensitively
+// and an empty value removes the comments.
+func (v *VorbisComment) Set(name string, value string) {
+	var result []string
+	found := false
+	for _, c := range v.UserComment {
+		commentName, _, _ := strings.Cut(c, "=")
+		if !strings.EqualFold(commentName, name) {
+			result = append(result, c)
+			continue
+		}
+		if !found && value != "" {
+			result = append(result, name+"="+value)
+		}
+		found = true
+	}
+	if !found && value != "" {
+		result = append(result, name+"="+value)
+	}
+	v.UserComment = result
+}
+
+// Encode returns the content of a Vorbis comment block.
+func (v *VorbisComment) Encode() []byte {
+	result := binary.LittleEndian.AppendUint32(nil, uint32(len(v.VendorString)))
+	result = append(result, v.VendorString...)
+	result = binary.LittleEndian.AppendUint32(result, uint32(len(v.UserComment)))
+	for _, c := range v.UserComment {
+		result = binary.LittleEndian.AppendUint32(result, uint32(len(c)))
+		result = append(result, c...)
+	}
+	return result
+}
+
+type Comments struct {
+	VorbisComments []VorbisComment `json:"vorbisComments"`
+}
+
+func (c *Comments) Describe() *document.Document {
+	doc := &document.Document{}
+	if len(c.VorbisComments) == 0 {
+		doc.AddMessage("Vorbis comment metadata not found!")
+		return doc
+	}
+	for i, comment := range c.VorbisComments {
+		section := doc.AddSection(fmt.Sprintf("vorbisComment.%d", i), "Vorbis Comment")
+		section.AddString("vendor", "Vendor String", comment.VendorString)
+		section.AddList("userComments", "", comment.UserComment)
+	}
+	return doc
 }
@@ -0,0 +1,20 @@
+package flac
+
+import (
+	"io"
+	"jch-metadata/internal/parser"
+)
+
+// EssenceRanges returns the audio frames, which start after the last metadata block.
+func EssenceRanges(r io.ReaderAt, size int64) ([]parser.Range, error) {
+	metadata, err := GetMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	last := metadata[len(metadata)-1]
+	start := last.StartAt + 4 + int64(last.Length)
+	if start >= size {
+		return nil, nil
+	}
+	return []parser.Range{{Offset: start, Size: size - start}}, nil
+}
@@ -0,0 +1,114 @@
+package flac
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+)
+
+var blockNames = map[byte]string{
+	0: "STREAMINFO",
+	1: "PADDING",
+	2: "APPLICATION",
+	3: "SEEKTABLE",
+	4: "VORBIS_COMMENT",
+	5: "CUESHEET",
+	6: "PICTURE",
+}
+
+// Verify checks that metadata blocks fit in the file, that the first block is STREAMINFO, that the lengths inside
+// Vorbis comment blocks are consistent and that audio frames follow the last block.
+func Verify(r io.ReaderAt, size int64) ([]parser.Problem, error) {
+	var problems []parser.Problem
+	offset := int64(4)
+	for index := 0; ; index++ {
+		if offset+4 > size {
+			problems = append(problems, parser.NewError("file", offset, "metadata block header past the end of file, last block not found"))
+			return problems, nil
+		}
+		header := make([]byte, 4)
+		_, err := r.ReadAt(header, offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		blockType := header[0] & 0x7F
+		length := int64(binary.BigEndian.Uint32([]byte{0, header[1], header[2], header[3]}))
+		name, found := blockNames[blockType]
+		if !found {
+			name = fmt.Sprintf("block type %d", blockType)
+		}
+		target := fmt.Sprintf("%s block", name)
+		if blockType == 127 {
+			problems = append(problems, parser.NewError(target, offset, "invalid block type"))
+		}
+		if index == 0 && (blockType != 0 || length != 34) {
+			problems = append(problems, parser.NewError(target, offset, "first block should be a STREAMINFO block of 34 bytes"))
+		} else if index > 0 && blockType == 0 {
+			problems = append(problems, parser.NewError(target, offset, "only the first block can be a STREAMINFO block"))
+		}
+		if offset+4+length > size {
+			problems = append(problems, parser.NewError(target, offset, "block length %d overruns the end of file", length))
+			return problems, nil
+		}
+		if blockType == 4 {
+			data := make([]byte, length)
+			_, err = r.ReadAt(data, offset+4)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read file: %w", err)
+			}
+			if message := verifyVorbisComment(data); message != "" {
+				problems = append(problems, parser.NewError(target, offset, message))
+			}
+		}
+		offset += 4 + length
+		if header[0]&0x80 != 0 {
+			break
+		}
+	}
+
+	sync := make([]byte, 2)
+	if offset+2 > size {
+		problems = append(problems, parser.NewWarning("audio", offset, "no audio frames after the last metadata block"))
+		return problems, nil
+	}
+	_, err := r.ReadAt(sync, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if sync[0] != 0xFF || sync[1]&0xFE != 0xF8 {
+		problems = append(problems, parser.NewError("audio", offset, "frame sync code not found after the last metadata block"))
+	}
+	return problems, nil
+}
+
+// verifyVorbisComment returns a description of the first length that overruns data, or an empty string if the block
+// is valid.
+func verifyVorbisComment(data []byte) string {
+	offset := uint64(0)
+	read := func() (uint64, bool) {
+		if offset+4 > uint64(len(data)) {
+			return 0, false
+		}
+		value := uint64(binary.LittleEndian.Uint32(data[offset : offset+4]))
+		offset += 4
+		return value, true
+	}
+	vendorLength, ok := read()
+	if !ok || offset+vendorLength > uint64(len(data)) {
+		return "vendor string overruns the block"
+	}
+	offset += vendorLength
+	count, ok := read()
+	if !ok {
+		return "number of comments overruns the block"
+	}
+	for i := uint64(0); i < count; i++ {
+		length, ok := read()
+		if !ok || offset+length > uint64(len(data)) {
+			return fmt.Sprintf("comment %d overruns the block", i+1)
+		}
+		offset += length
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"jch-metadata/internal/document"
+)
+
+// HashAlgorithm is the digest computed by the hash action.
+const HashAlgorithm = "SHA-256"
+
+// Range is a part of the content hashed by the hash action, such as the image data of a chunk.
+type Range struct {
+	Offset int64
+	Size   int64
+}
+
+// EssenceHash returns the hex encoded digest of the ranges of r in order, and the number of bytes hashed.
+func EssenceHash(r io.ReaderAt, ranges []Range) (string, int64, error) {
+	hash := sha256.New()
+	total := int64(0)
+	for _, rg := range ranges {
+		n, err := io.Copy(hash, io.NewSectionReader(r, rg.Offset, rg.Size))
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to read media data at offset %d: %w", rg.Offset, err)
+		}
+		if n != rg.Size {
+			return "", 0, fmt.Errorf("media data at offset %d is truncated", rg.Offset)
+		}
+		total += n
+	}
+	return hex.EncodeToString(hash.Sum(nil)), total, nil
+}
+
+// DescribeHash returns a document with the essence hash of the ranges of r, which only cover media data so files
+// that only differ by their metadata have the same hash.
+func DescribeHash(r io.ReaderAt, ranges []Range) (*document.Document, error) {
+	if len(ranges) == 0 {
+		return document.Message("No media data found"), nil
+	}
+	hash, size, err := EssenceHash(r, ranges)
+	if err != nil {
+		return nil, err
+	}
+	doc := &document.Document{}
+	section := doc.AddSection("essence", "Essence Hash")
+	section.AddString("algorithm", "Algorithm", HashAlgorithm)
+	section.AddString("hash", "Hash", hash)
+	section.AddInt("size", "Hashed Size", size)
+	section.AddInt("ranges", "Ranges", int64(len(ranges)))
+	return doc, nil
+}
+
+// FindEssenceHash returns the essence hash listed in doc or an empty string if there is none.
+func FindEssenceHash(doc *document.Document) string {
+	section := doc.FindSection("essence")
+	if section == nil {
+		return ""
+	}
+	field := section.FindField("hash")
+	if field == nil {
+		return ""
+	}
+	return field.String()
+}
@@ -1,13 +1,17 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
-	"jch-metadata/internal/output"
-	"os"
+	"io"
+	"jch-metadata/internal/document"
 )
 
 type Action string
 
+// ErrUnsupportedAction is returned by parsers that can't run an action on their format.
+var ErrUnsupportedAction = errors.New("unsupported action")
+
 func ConvertAction(actionArgument string) (Action, error) {
 	if actionArgument == string(ShowAction) {
 		return ShowAction, nil
@@ -15,6 +19,18 @@ func ConvertAction(actionArgument string) (Action, error) {
 		return ClearAction, nil
 	} else if actionArgument == string(ExtractAction) {
 		return ExtractAction, nil
+	} else if actionArgument == string(SetAction) {
+		return SetAction, nil
+	} else if actionArgument == string(CopyAction) {
+		return CopyAction, nil
+	} else if actionArgument == string(DiffAction) {
+		return DiffAction, nil
+	} else if actionArgument == string(VerifyAction) {
+		return VerifyAction, nil
+	} else if actionArgument == string(AuditAction) {
+		return AuditAction, nil
+	} else if actionArgument == string(HashAction) {
+		return HashAction, nil
 	}
 	return "", fmt.Errorf("invalid action: %s", actionArgument)
 }
@@ -23,41 +39,115 @@ const (
 	ShowAction    Action = "show"
 	ClearAction   Action = "clear"
 	ExtractAction Action = "extract"
+	SetAction     Action = "set"
+	CopyAction    Action = "copy"
+	VerifyAction  Action = "verify"
+	AuditAction   Action = "audit"
+	HashAction    Action = "hash"
+	// DiffAction compares the documents built by ShowAction for two files, so it is never passed to parsers.
+	DiffAction Action = "diff"
 )
 
+// Options configures how an action is performed.
+type Options struct {
+	// OutputDir is the directory where extracted files are written.
+	OutputDir string
+	// DryRun makes the clear, set and copy actions report the changes they would make without writing them.
+	DryRun bool
+	// Selector chooses the metadata removed by the clear action.
+	Selector Selector
+	// Fields are the values written by the set action.
+	Fields []Field
+	// Source is the metadata written by the copy action.
+	Source *Metadata
+	// Policy are the categories of personal data that violate the policy of the audit action.
+	Policy []string
+	// MaxNesting is the number of levels of nested content that are parsed.  DefaultMaxNesting is used if it is 0.
+	MaxNesting int
+}
+
+// DefaultMaxNesting is the number of levels of nested content parsed unless Options.MaxNesting is set.
+const DefaultMaxNesting = 8
+
+// MaxNestingLevel returns the number of levels of nested content that are parsed.
+func (o Options) MaxNestingLevel() int {
+	if o.MaxNesting == 0 {
+		return DefaultMaxNesting
+	}
+	return o.MaxNesting
+}
+
 type Parser struct {
 	Name      string
 	Container bool
-	Support   func(file *os.File, startOffset int64, length int64) (bool, error)
-	Handle    func(file *os.File, action Action, startOffset int64, length int64, parsers []Parser) error
+	Support   func(r io.ReaderAt, size int64) (bool, error)
+	Handle    func(input *Input, action Action, parsers []Parser, options Options) (*document.Document, error)
+	// Export reads the metadata copied by the copy action.  It is nil if the format can't be copied from.
+	Export func(input *Input) (*Metadata, error)
+	// Items returns the nested content, such as attachments or thumbnails, created with Input.Item.  It is nil if
+	// the format can't hold nested content.
+	Items func(input *Input) ([]*Input, error)
 }
 
-func StartParsing(parsers []Parser, file *os.File, action Action, startOffset int64, length int64) (bool, error) {
-	parsed := false
-	for _, p := range parsers {
-		if startOffset > 0 && p.Container {
-			continue
+// Input is the content handled by a parser.
+type Input struct {
+	// Name is the name of the content, used to name extracted files.
+	Name   string
+	Reader io.ReaderAt
+	Size   int64
+	// Writer is nil if the content can't be modified.
+	Writer Writer
+	// Nested is true if the content is embedded in another file.
+	Nested bool
+	// Path addresses nested content from the file that contains it, such as "test.mkv!attachment[1]".
+	Path string
+	// Depth is the number of containers above nested content.
+	Depth int
+	// root is the path of the file that contains nested content.
+	root string
+}
+
+// Section returns the nested content stored in the given range.
+func (in *Input) Section(name string, offset int64, size int64) *Input {
+	section := Input{
+		Name:   name,
+		Reader: io.NewSectionReader(in.Reader, offset, size),
+		Size:   size,
+		Nested: true,
+	}
+	if in.Writer != nil {
+		section.Writer = &sectionWriter{
+			writer: in.Writer,
+			offset: offset,
+			size:   size,
 		}
-		supported, err := p.Support(file, startOffset, length)
+	}
+	return &section
+}
+
+// StartParsing runs the first parser that supports the content and returns the document it built.  It returns nil
+// if no parser supports the content.
+func StartParsing(parsers []Parser, input *Input, action Action, options Options) (*document.Document, error) {
+	for _, p := range parsers {
+		supported, err := p.Support(input.Reader, input.Size)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
 		if !supported {
 			continue
 		}
-		output.Printf(startOffset > 0, "File type is %s\n\n", p.Name)
-		_, err = file.Seek(startOffset, 0)
-		if err != nil {
-			output.Printf(startOffset > 0, "Error changing file position: %s", err)
-			return false, err
+		if (action == ClearAction || action == SetAction || action == CopyAction) && input.Writer == nil && !options.DryRun {
+			return nil, fmt.Errorf("%s is not writable", input.ItemPath())
 		}
-		err = p.Handle(file, action, startOffset, length, parsers)
+		doc, err := p.Handle(input, action, parsers, options)
 		if err != nil {
-			output.Printf(startOffset > 0, "Error handling file: %s", err)
-			return false, err
+			return nil, err
+		}
+		doc.Format = p.Name
+		if input.Depth > 0 {
+			doc.Path = input.Path
 		}
-		parsed = true
-		break
+		return doc, nil
 	}
-	return parsed, nil
+	return nil, nil
 }
@@ -0,0 +1,153 @@
+package parser
+
+import (
+	"fmt"
+	"jch-metadata/internal/document"
+	"jch-metadata/internal/safewrite"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// PathSeparator separates the file name and the nested items of a path, such as "test.mkv!attachment[1]!picture[0]".
+const PathSeparator = "!"
+
+var itemPattern = regexp.MustCompile(`^[a-z]+\[\d+\]$`)
+
+// Item returns the nested content stored in the given range, addressed as kind[index] below in.
+func (in *Input) Item(kind string, index int, name string, offset int64, size int64) *Input {
+	item := in.Section(name, offset, size)
+	item.Path = fmt.Sprintf("%s%s%s[%d]", in.ItemPath(), PathSeparator, kind, index)
+	item.Depth = in.Depth + 1
+	item.root = in.rootPath()
+	return item
+}
+
+// ItemData returns nested content decoded into memory, such as a compressed archive entry.  It can't be modified.
+func (in *Input) ItemData(kind string, index int, name string, data []byte) *Input {
+	return &Input{
+		Name:   name,
+		Reader: &Buffer{Data: data},
+		Size:   int64(len(data)),
+		Nested: true,
+		Path:   fmt.Sprintf("%s%s%s[%d]", in.ItemPath(), PathSeparator, kind, index),
+		Depth:  in.Depth + 1,
+		root:   in.rootPath(),
+	}
+}
+
+func (in *Input) rootPath() string {
+	if in.Depth == 0 {
+		return in.ItemPath()
+	}
+	return in.root
+}
+
+// RelativePath returns the path of nested content without the name of the file that contains it, such as
+// "attachment[1]!picture[0]".
+func (in *Input) RelativePath() string {
+	return strings.TrimPrefix(in.Path, in.rootPath()+PathSeparator)
+}
+
+// ItemPath returns the path of the content, which is its name if it isn't nested.
+func (in *Input) ItemPath() string {
+	if in.Path == "" {
+		return in.Name
+	}
+	return in.Path
+}
+
+// SplitPath separates the file name from the nested items of path.  The items are empty if path has none.
+func SplitPath(path string) (string, string) {
+	fileName, items, found := strings.Cut(path, PathSeparator)
+	if !found {
+		return path, ""
+	}
+	return fileName, items
+}
+
+// ResolvePath returns the nested content of input addressed by items, such as "attachment[1]!picture[0]".
+func ResolvePath(parsers []Parser, input *Input, items string) (*Input, error) {
+	for _, item := range strings.Split(items, PathSeparator) {
+		if !itemPattern.MatchString(item) {
+			return nil, fmt.Errorf("invalid nested item %s, expected a name such as attachment[1]", item)
+		}
+		var parser *Parser
+		for i, p := range parsers {
+			supported, err := p.Support(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			if supported {
+				parser = &parsers[i]
+				break
+			}
+		}
+		if parser == nil {
+			return nil, fmt.Errorf("unsupported file type: %s", input.ItemPath())
+		}
+		if parser.Items == nil {
+			return nil, fmt.Errorf("%s files don't have nested items", parser.Name)
+		}
+		nested, err := parser.Items(input)
+		if err != nil {
+			return nil, err
+		}
+		path := input.ItemPath() + PathSeparator + item
+		input = nil
+		for _, n := range nested {
+			if n.Path == path {
+				input = n
+				break
+			}
+		}
+		if input == nil {
+			return nil, fmt.Errorf("%s not found", path)
+		}
+	}
+	return input, nil
+}
+
+// DescribeItem parses nested content and adds the resulting document to section.  Content deeper than the maximum
+// nesting level isn't parsed.
+func DescribeItem(section *document.Section, parsers []Parser, item *Input, action Action, options Options) error {
+	section.AddString("path", "Path", item.RelativePath())
+	if item.Depth > options.MaxNestingLevel() {
+		section.AddString("content", "Content", fmt.Sprintf("Not parsed, nested deeper than %d levels", options.MaxNestingLevel()))
+		return nil
+	}
+	content, err := StartParsing(parsers, item, action, options)
+	if err != nil {
+		return fmt.Errorf("error while processing %s: %w", item.Path, err)
+	}
+	if content == nil {
+		section.AddString("content", "Content", "Unsupported file type")
+	} else {
+		section.AddDocument(content)
+	}
+	return nil
+}
+
+// ExtractItem writes nested content into outputDir and returns the name of the new file.
+func ExtractItem(item *Input, outputDir string) (string, error) {
+	data := make([]byte, item.Size)
+	_, err := item.Reader.ReadAt(data, 0)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", item.Path, err)
+	}
+	err = os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("error creating output directory: %w", err)
+	}
+	name := filepath.Base(item.Name)
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		name = "item"
+	}
+	filename := filepath.Join(outputDir, name)
+	err = safewrite.WriteFile(filename, data, 0644)
+	if err != nil {
+		return "", fmt.Errorf("error writing %s: %w", filename, err)
+	}
+	return filename, nil
+}
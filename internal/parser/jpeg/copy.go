@@ -0,0 +1,152 @@
+package jpeg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+	"jch-metadata/internal/parser/shared"
+	"sort"
+)
+
+const xmpNamespace = "http://ns.adobe.com/xap/1.0/\x00"
+
+// ExportMetadata returns the EXIF data, XMP packet and ICC profile stored in the application segments.
+func ExportMetadata(r io.ReaderAt, size int64) (*parser.Metadata, error) {
+	appSegments, err := FindApplicationSegments(r, size)
+	if err != nil {
+		return nil, err
+	}
+	result := parser.Metadata{}
+	var iccSegments []ApplicationSegment
+	for _, a := range appSegments {
+		if a.IsEXIFSegment() && result.Exif == nil {
+			result.Exif = a.Raw[10:]
+		} else if a.IsXMPSegment() && len(a.Raw) > 33 && result.XMP == nil {
+			result.XMP = a.Raw[33:]
+		} else if a.IsICCProfileSegment() && len(a.Raw) > 18 {
+			iccSegments = append(iccSegments, a)
+		}
+	}
+	sort.SliceStable(iccSegments, func(i, j int) bool { return iccSegments[i].Raw[16] < iccSegments[j].Raw[16] })
+	for _, a := range iccSegments {
+		result.ICC = append(result.ICC, a.Raw[18:]...)
+	}
+	return &result, nil
+}
+
+// ImportMetadata writes the EXIF data, XMP packet and ICC profile of source as application segments, replacing the
+// segments of the same kind.  New segments are added after the JFIF segments at the start of the file.  If dryRun is
+// true, the changes are returned without writing them.
+func ImportMetadata(r io.ReaderAt, size int64, w parser.Writer, source *parser.Metadata, dryRun bool) ([]parser.Change, error) {
+	appSegments, err := FindApplicationSegments(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var added [][]byte
+	if len(source.Exif) > 0 {
+		segment, err := encodeSegment(0xE1, append([]byte(shared.ExifHeader), source.Exif...))
+		if err != nil {
+			return nil, fmt.Errorf("can't write EXIF data: %w", err)
+		}
+		added = append(added, segment)
+	}
+	if len(source.XMP) > 0 {
+		segment, err := encodeSegment(0xE1, append([]byte(xmpNamespace), source.XMP...))
+		if err != nil {
+			return nil, fmt.Errorf("can't write XMP packet: %w", err)
+		}
+		added = append(added, segment)
+	}
+	if len(source.ICC) > 0 {
+		// Large profiles are split into numbered segments
+		const chunkSize = 65519
+		count := (len(source.ICC) + chunkSize - 1) / chunkSize
+		if count > 255 {
+			return nil, fmt.Errorf("ICC profile is too large: %d bytes", len(source.ICC))
+		}
+		for i := 0; i < count; i++ {
+			end := (i + 1) * chunkSize
+			if end > len(source.ICC) {
+				end = len(source.ICC)
+			}
+			payload := append([]byte("ICC_PROFILE\x00"), byte(i+1), byte(count))
+			payload = append(payload, source.ICC[i*chunkSize:end]...)
+			segment, err := encodeSegment(0xE2, payload)
+			if err != nil {
+				return nil, err
+			}
+			added = append(added, segment)
+		}
+	}
+
+	insertAt := int64(2)
+	var removed []ApplicationSegment
+	for _, a := range appSegments {
+		if a.StartOffset == insertAt && (a.IsJFIFSegment() || a.IsJFXXSegment()) {
+			insertAt += int64(a.Length) + 2
+		}
+		if (a.IsEXIFSegment() && len(source.Exif) > 0) ||
+			((a.IsXMPSegment() || a.IsExtendedXMPSegment()) && len(source.XMP) > 0) ||
+			(a.IsICCProfileSegment() && len(source.ICC) > 0) {
+			removed = append(removed, a)
+		}
+	}
+	var changes []parser.Change
+	for _, a := range removed {
+		changes = append(changes, parser.Change{
+			Operation: parser.RemoveOperation,
+			Target:    fmt.Sprintf("APP%d segment", a.Marker[1]-0xE0),
+			Offset:    a.StartOffset,
+			Size:      int64(a.Length) + 2,
+		})
+	}
+	for _, segment := range added {
+		changes = append(changes, parser.Change{
+			Operation: parser.RewriteOperation,
+			Target:    fmt.Sprintf("new APP%d segment", segment[1]-0xE0),
+			Offset:    insertAt,
+			Size:      int64(len(segment)),
+		})
+	}
+	if dryRun || len(changes) == 0 {
+		return changes, nil
+	}
+
+	err = w.Replace(func(writer io.Writer) error {
+		_, err := io.Copy(writer, io.NewSectionReader(r, 0, insertAt))
+		if err != nil {
+			return err
+		}
+		for _, segment := range added {
+			_, err = writer.Write(segment)
+			if err != nil {
+				return err
+			}
+		}
+		offset := insertAt
+		for _, a := range removed {
+			_, err = io.Copy(writer, io.NewSectionReader(r, offset, a.StartOffset-offset))
+			if err != nil {
+				return err
+			}
+			offset = a.StartOffset + int64(a.Length) + 2
+		}
+		_, err = io.Copy(writer, io.NewSectionReader(r, offset, size-offset))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// encodeSegment returns an application segment with the given marker and payload.
+func encodeSegment(marker byte, payload []byte) ([]byte, error) {
+	if len(payload)+2 > 0xFFFF {
+		return nil, fmt.Errorf("%d bytes doesn't fit in a segment", len(payload))
+	}
+	result := []byte{0xFF, marker}
+	result = binary.BigEndian.AppendUint16(result, uint16(len(payload)+2))
+	return append(result, payload...), nil
+}
@@ -0,0 +1,59 @@
+package jpeg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+)
+
+// EssenceRanges returns the segments and scan data of the image from SOI to EOI without the application (APPn) and
+// comment (COM) segments.  Data after the EOI marker isn't part of the image.
+func EssenceRanges(r io.ReaderAt, size int64) ([]parser.Range, error) {
+	var result []parser.Range
+	marker := make([]byte, 4)
+	for offset := int64(0); offset+2 <= size; {
+		_, err := r.ReadAt(marker[:2], offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		if marker[0] != 0xFF {
+			return nil, fmt.Errorf("expected a marker at offset %d but found 0x%02X", offset, marker[0])
+		}
+		if marker[1] == 0xFF {
+			offset += 1
+			continue
+		}
+		if marker[1] == 0xD8 || marker[1] == 0xD9 || marker[1] == 0x01 || (marker[1] >= 0xD0 && marker[1] <= 0xD7) {
+			result = append(result, parser.Range{Offset: offset, Size: 2})
+			if marker[1] == 0xD9 {
+				break
+			}
+			offset += 2
+			continue
+		}
+		if offset+4 > size {
+			return nil, fmt.Errorf("segment at offset %d is truncated", offset)
+		}
+		_, err = r.ReadAt(marker, offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		length := int64(binary.BigEndian.Uint16(marker[2:4]))
+		if length < 2 || offset+2+length > size {
+			return nil, fmt.Errorf("invalid segment length %d at offset %d", length, offset)
+		}
+		next := offset + 2 + length
+		if marker[1] == 0xDA {
+			next, err = skipScanData(r, next, size)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if (marker[1] < 0xE0 || marker[1] > 0xEF) && marker[1] != 0xFE {
+			result = append(result, parser.Range{Offset: offset, Size: next - offset})
+		}
+		offset = next
+	}
+	return result, nil
+}
@@ -6,104 +6,127 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"jch-metadata/internal/output"
+	"jch-metadata/internal/document"
 	"jch-metadata/internal/parser"
 	"jch-metadata/internal/parser/shared"
+	"jch-metadata/internal/safewrite"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 )
 
 var Parser = parser.Parser{
 	Name:      "JPEG",
 	Container: false,
-	Support: func(file *os.File, startOffset int64, length int64) (bool, error) {
-		return IsJPEG(file, startOffset)
+	Support: func(r io.ReaderAt, size int64) (bool, error) {
+		return IsJPEG(r)
 	},
-	Handle: func(file *os.File, action parser.Action, startOffset int64, length int64, parsers []parser.Parser) error {
+	Handle: func(input *parser.Input, action parser.Action, parsers []parser.Parser, options parser.Options) (*document.Document, error) {
 		if action == parser.ShowAction {
-			metadata, err := ParseFile(file, startOffset)
+			metadata, err := ParseFile(input.Reader, input.Size)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			output.PrintHeader(startOffset > 0, "JFIF Segments")
-			output.PrintForm(startOffset > 0, "Has JFIF Thumbnail", fmt.Sprintf("%v", metadata.JFIFThumbnail), 20)
-			output.PrintForm(startOffset > 0, "Has JFXX Thumbnail", fmt.Sprintf("%v", metadata.JFXXThumbnail), 20)
-			output.Println(startOffset > 0)
-			shared.PrintExif(startOffset > 0, metadata.IFDs)
-			if metadata.XMP != nil {
-				output.PrintHeader(startOffset > 0, "XMP")
-				for _, s := range metadata.XMP {
-					output.PrintMultiline(startOffset > 0, s)
-					output.Println(startOffset > 0)
+			doc := metadata.Describe()
+			thumbnail, err := thumbnailItem(input)
+			if err != nil {
+				doc.AddSection("thumbnail", "Thumbnail").AddString("content", "Content", err.Error())
+			} else if thumbnail != nil {
+				err = parser.DescribeItem(doc.AddSection("thumbnail", "Thumbnail"), parsers, thumbnail, action, options)
+				if err != nil {
+					return nil, err
 				}
-
 			}
-
-			for _, m := range metadata.UnsupportedMarkers {
-				output.PrintHeader(startOffset > 0, "Application Segment 0x%04X", m.Marker)
-				output.PrintHexDump(startOffset > 0, m.Raw)
-				output.Println(startOffset > 0)
-			}
-			output.Println(startOffset > 0)
-			shared.PrintICC(startOffset > 0, metadata.ICCProfile)
+			return doc, nil
 		} else if action == parser.ClearAction {
-			appSegments, err := FindApplicationSegments(file, startOffset)
+			return ClearMetadata(input, options)
+		} else if action == parser.ExtractAction {
+			thumbnailData, err := ExtractThumbnail(input.Reader, input.Size)
 			if err != nil {
-				return err
+				return nil, fmt.Errorf("error extracting thumbnail: %w", err)
 			}
-			if len(appSegments) == 0 {
-				fmt.Println("There is no application segments to remove!")
-				return nil
+			if thumbnailData == nil {
+				return document.Message("No thumbnail to extract"), nil
 			}
-			err = RemoveApplicationSegment(file, appSegments, length)
+			err = os.MkdirAll(options.OutputDir, 0755)
 			if err != nil {
-				return err
+				return nil, fmt.Errorf("error creating output directory: %w", err)
 			}
-			fmt.Println("Application segments has been removed!")
-		} else if action == parser.ExtractAction {
-			thumbnailData, err := ExtractThumbnail(file, startOffset)
+			ext := filepath.Ext(input.Name)
+			basename := filepath.Base(strings.TrimSuffix(input.Name, ext))
+			filename := filepath.Join(options.OutputDir, basename+"_thumbnail.jpeg")
+			err = safewrite.WriteFile(filename, thumbnailData, 0644)
 			if err != nil {
-				return fmt.Errorf("error extracting thumbnail: %w", err)
+				return nil, fmt.Errorf("error writing thumbnail: %w", err)
 			}
-			if thumbnailData == nil {
-				output.Println(startOffset > 0, "No thumbnail to extract")
-				return nil
+			return document.Message("Thumbnail has been extracted to %s", filename), nil
+		} else if action == parser.CopyAction {
+			changes, err := ImportMetadata(input.Reader, input.Size, input.Writer, options.Source, options.DryRun)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeCopy(options.Source, []string{parser.ExifKind, parser.XMPKind, parser.ICCKind}, changes, options.DryRun), nil
+		} else if action == parser.SetAction {
+			changes, changed, err := SetExifFields(input.Reader, input.Size, input.Writer, options.Fields, options.DryRun)
+			if err != nil {
+				return nil, err
+			}
+			if len(changed) == 0 {
+				return document.Message("No EXIF tag has been changed"), nil
+			}
+			if options.DryRun {
+				return parser.DescribeChanges(changes), nil
+			}
+			return document.Message("EXIF tags have been written: %s", strings.Join(changed, ", ")), nil
+		} else if action == parser.VerifyAction {
+			problems, err := Verify(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeProblems(problems), nil
+		} else if action == parser.AuditAction {
+			metadata, err := ExportMetadata(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
 			}
-			err = os.MkdirAll("output", os.ModePerm)
+			findings, err := shared.AuditMetadata(metadata)
 			if err != nil {
-				return fmt.Errorf("error creating output directory: %w", err)
+				return nil, err
 			}
-			ext := filepath.Ext(file.Name())
-			basename := filepath.Base(strings.TrimSuffix(file.Name(), ext))
-			filename := filepath.Join("output", basename+"_thumbnail.jpeg")
-			err = os.WriteFile(filename, thumbnailData, os.ModePerm)
+			return parser.DescribeFindings(findings, options.Policy), nil
+		} else if action == parser.HashAction {
+			ranges, err := EssenceRanges(input.Reader, input.Size)
 			if err != nil {
-				return fmt.Errorf("error writing thumbnail: %w", err)
+				return nil, err
 			}
-			output.Printf(startOffset > 0, "Thumbnail has been extracted to %s\n", filename)
+			return parser.DescribeHash(input.Reader, ranges)
 		}
-		return nil
+		return document.Message("Unsupported action: %s", action), nil
+	},
+	Export: func(input *parser.Input) (*parser.Metadata, error) {
+		return ExportMetadata(input.Reader, input.Size)
+	},
+	Items: func(input *parser.Input) ([]*parser.Input, error) {
+		thumbnail, err := thumbnailItem(input)
+		if err != nil || thumbnail == nil {
+			return nil, err
+		}
+		return []*parser.Input{thumbnail}, nil
 	},
 }
 
-func IsJPEG(file *os.File, startOffset int64) (bool, error) {
+func IsJPEG(r io.ReaderAt) (bool, error) {
 	magicBytes := make([]byte, 3)
-	_, err := file.ReadAt(magicBytes, startOffset)
+	_, err := r.ReadAt(magicBytes, 0)
 	if err != nil {
 		return false, fmt.Errorf("failed to read file: %w", err)
 	}
 	return bytes.Equal(magicBytes, []byte{0xFF, 0xD8, 0xFF}), nil
 }
 
-func FindApplicationSegments(file *os.File, startOffset int64) ([]ApplicationSegment, error) {
-	offset := startOffset
-	reader := bufio.NewReader(file)
-	_, err := reader.Discard(int(offset))
-	if err != nil {
-		return nil, err
-	}
+func FindApplicationSegments(r io.ReaderAt, size int64) ([]ApplicationSegment, error) {
+	reader := bufio.NewReader(io.NewSectionReader(r, 0, size))
+	var err error
 	var result []ApplicationSegment
 	appSegment := ApplicationSegment{
 		Marker: make([]byte, 2),
@@ -155,8 +178,8 @@ func FindApplicationSegments(file *os.File, startOffset int64) ([]ApplicationSeg
 	return result, nil
 }
 
-func ParseFile(file *os.File, startOffset int64) (*Metadata, error) {
-	markers, err := FindApplicationSegments(file, startOffset)
+func ParseFile(r io.ReaderAt, size int64) (*Metadata, error) {
+	markers, err := FindApplicationSegments(r, size)
 	if err != nil {
 		return nil, err
 	}
@@ -170,7 +193,10 @@ func ParseFile(file *os.File, startOffset int64) (*Metadata, error) {
 		} else if m.IsJFXXSegment() {
 			result.JFXXThumbnail = true
 		} else if m.IsEXIFSegment() {
-			result.IFDs = m.GetIFDs()
+			result.IFDs, err = m.GetIFDs()
+			if err != nil {
+				return nil, err
+			}
 		} else if m.IsICCProfileSegment() {
 			result.ICCProfile = m.GetICCProfile()
 		} else if m.IsXMPSegment() {
@@ -184,11 +210,26 @@ func ParseFile(file *os.File, startOffset int64) (*Metadata, error) {
 	return &result, nil
 }
 
-func ExtractThumbnail(file *os.File, startOffset int64) ([]byte, error) {
-	markers, err := FindApplicationSegments(file, startOffset)
-	if err != nil {
+func ExtractThumbnail(r io.ReaderAt, size int64) ([]byte, error) {
+	offset, length, err := FindThumbnail(r, size)
+	if err != nil || length == 0 {
 		return nil, err
 	}
+	data := make([]byte, length)
+	_, err = r.ReadAt(data, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read thumbnail: %w", err)
+	}
+	return data, nil
+}
+
+// FindThumbnail returns the offset in the file and the size of the JPEG thumbnail stored in the EXIF segment.  The
+// size is 0 if there is no thumbnail.
+func FindThumbnail(r io.ReaderAt, size int64) (int64, int64, error) {
+	markers, err := FindApplicationSegments(r, size)
+	if err != nil {
+		return 0, 0, err
+	}
 	var exifSegment *ApplicationSegment
 	for _, m := range markers {
 		if m.IsEXIFSegment() {
@@ -197,82 +238,197 @@ func ExtractThumbnail(file *os.File, startOffset int64) ([]byte, error) {
 		}
 	}
 	if exifSegment == nil {
-		return nil, nil
+		return 0, 0, nil
+	}
+	ifds, err := exifSegment.GetIFDs()
+	if err != nil {
+		return 0, 0, err
 	}
-	ifds := exifSegment.GetIFDs()
 	for _, ifd := range ifds {
-		if ifd.Tags[0x0103] == "6" {
-			offsetStr, exists := ifd.Tags[0x0201]
+		if ifd.Kind != shared.TIFFIFD {
+			continue
+		}
+		if compression, _ := ifd.Tags[0x0103].Uint(0); compression == 6 {
+			offsetValue, exists := ifd.Tags[0x0201].Uint(0)
 			if !exists {
 				continue
 			}
-			offset, err := strconv.Atoi(offsetStr)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse thumbnail offset [%s]: %w", offsetStr, err)
-			}
-			sizeStr, exists := ifd.Tags[0x0202]
+			sizeValue, exists := ifd.Tags[0x0202].Uint(0)
 			if !exists {
 				continue
 			}
-			size, err := strconv.Atoi(sizeStr)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse thumbnail size [%s]: %w", sizeStr, err)
-			}
+			offset, size := int(offsetValue), int(sizeValue)
+			// The offset is relative to the TIFF header that follows the marker, length and "Exif\0\0"
 			start := offset + 10
-			return exifSegment.Raw[start : start+size], nil
+			if start < 10 || size < 0 || start+size > len(exifSegment.Raw) {
+				return 0, 0, fmt.Errorf("thumbnail at offset %d with size %d is outside of the EXIF segment", offset, size)
+			}
+			return exifSegment.StartOffset + int64(start), int64(size), nil
 		}
 	}
-	return nil, nil
+	return 0, 0, nil
 }
 
-func RemoveApplicationSegment(file *os.File, appSegments []ApplicationSegment, length int64) error {
-	tempFile, err := os.CreateTemp("", "jch_metadata_tmp_*")
-	if err != nil {
-		return err
+// thumbnailItem returns the thumbnail as nested content or nil if there is no thumbnail.
+func thumbnailItem(input *parser.Input) (*parser.Input, error) {
+	offset, size, err := FindThumbnail(input.Reader, input.Size)
+	if err != nil || size == 0 {
+		return nil, err
 	}
-	_, err = file.Seek(0, 0)
+	ext := filepath.Ext(input.Name)
+	return input.Item("thumbnail", 0, strings.TrimSuffix(input.Name, ext)+"_thumbnail.jpeg", offset, size), nil
+}
+
+// ClearMetadata removes the application segments chosen by options.Selector.  If only some EXIF tags are selected,
+// such as "exif.gps", the EXIF segment is rewritten without them instead.
+func ClearMetadata(input *parser.Input, options parser.Options) (*document.Document, error) {
+	appSegments, err := FindApplicationSegments(input.Reader, input.Size)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	reader := bufio.NewReader(file)
-	writer := bufio.NewWriter(tempFile)
-	for i := int64(0); i < length; i++ {
-		isAppSegment := false
-		for _, a := range appSegments {
-			if i >= a.StartOffset && i < (a.StartOffset+int64(a.Length+2)) {
-				isAppSegment = true
-				break
-			}
-		}
-		if isAppSegment {
-			_, err := reader.Discard(1)
+	var segments []ApplicationSegment
+	var contents [][]byte
+	var removedTags, removedSegments int
+	var changes []parser.Change
+	for _, a := range appSegments {
+		names := a.SelectorNames()
+		if options.Selector.Removes(names...) && !(a.IsEXIFSegment() && options.Selector.KeepsPart(names...)) {
+			segments = append(segments, a)
+			contents = append(contents, nil)
+			removedSegments++
+			changes = append(changes, parser.Change{
+				Operation: parser.RemoveOperation,
+				Target:    fmt.Sprintf("APP%d segment", a.Marker[1]-0xE0),
+				Offset:    a.StartOffset,
+				Size:      int64(a.Length) + 2,
+			})
+		} else if a.IsEXIFSegment() {
+			exif, removed, err := shared.RemoveExifTags(a.Raw[4:], options.Selector, "jpeg")
 			if err != nil {
-				return err
+				return nil, fmt.Errorf("error rewriting EXIF segment: %w", err)
 			}
-		} else {
-			b, err := reader.ReadByte()
-			if err != nil {
-				return err
+			if len(removed) == 0 {
+				continue
 			}
-			err = writer.WriteByte(b)
-			if err != nil {
-				return err
+			change := parser.Change{
+				Operation: parser.RemoveOperation,
+				Target:    "APP1 segment",
+				Offset:    a.StartOffset,
+				Size:      int64(a.Length) + 2,
+			}
+			var segment []byte
+			if exif != nil {
+				segment, err = encodeSegment(0xE1, exif)
+				if err != nil {
+					return nil, fmt.Errorf("error rewriting EXIF segment: %w", err)
+				}
+				change.Operation = parser.RewriteOperation
+				change.Target = fmt.Sprintf("APP1 segment without %s", strings.Join(removed, ", "))
+			} else {
+				removedSegments++
 			}
+			segments = append(segments, a)
+			contents = append(contents, segment)
+			removedTags += len(removed)
+			changes = append(changes, change)
 		}
 	}
-	err = writer.Flush()
+	if options.DryRun {
+		return parser.DescribeChanges(changes), nil
+	}
+	if len(changes) == 0 {
+		return document.Message("There is no application segments to remove!"), nil
+	}
+	err = ReplaceApplicationSegments(input.Reader, input.Size, input.Writer, segments, contents)
+	if err != nil {
+		return nil, err
+	}
+	doc := &document.Document{}
+	if removedTags > 0 {
+		doc.AddMessage("%d EXIF tags have been removed!", removedTags)
+	}
+	if removedSegments > 0 {
+		doc.AddMessage("Application segments has been removed!")
+	}
+	return doc, nil
+}
+
+// SetExifFields writes fields, such as "exif.Artist", to the EXIF segment, which is rewritten in place or added after
+// the JFIF segments.  It also returns the names of the changed tags, and nothing is written if there is none.  If
+// dryRun is true, the changes are returned without writing them.
+func SetExifFields(r io.ReaderAt, size int64, w parser.Writer, fields []parser.Field, dryRun bool) ([]parser.Change, []string, error) {
+	appSegments, err := FindApplicationSegments(r, size)
 	if err != nil {
-		return fmt.Errorf("error flushing temporary file: %w", err)
+		return nil, nil, err
 	}
-	err = tempFile.Close()
+	var exifSegment *ApplicationSegment
+	var raw []byte
+	for i, a := range appSegments {
+		if a.IsEXIFSegment() {
+			exifSegment = &appSegments[i]
+			raw = a.Raw[4:]
+			break
+		}
+	}
+	exif, changed, err := shared.SetExifTags(raw, fields)
 	if err != nil {
-		return fmt.Errorf("error closing temporary file: %w", err)
+		return nil, nil, err
+	}
+	if len(changed) == 0 {
+		return nil, nil, nil
+	}
+	if exifSegment == nil {
+		changes, err := ImportMetadata(r, size, w, &parser.Metadata{Exif: exif[len(shared.ExifHeader):]}, dryRun)
+		return changes, changed, err
 	}
-	err = os.Rename(tempFile.Name(), file.Name())
+	change := parser.Change{
+		Operation: parser.RemoveOperation,
+		Target:    "APP1 segment",
+		Offset:    exifSegment.StartOffset,
+		Size:      int64(exifSegment.Length) + 2,
+	}
+	var segment []byte
+	if exif != nil {
+		segment, err = encodeSegment(0xE1, exif)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't write EXIF data: %w", err)
+		}
+		change.Operation = parser.RewriteOperation
+		change.Target = fmt.Sprintf("APP1 segment with %s", strings.Join(changed, ", "))
+	}
+	if dryRun {
+		return []parser.Change{change}, changed, nil
+	}
+	err = ReplaceApplicationSegments(r, size, w, []ApplicationSegment{*exifSegment}, [][]byte{segment})
 	if err != nil {
-		return fmt.Errorf("error renaming file: %w", err)
+		return nil, nil, err
 	}
-	return nil
+	return []parser.Change{change}, changed, nil
+}
+
+func RemoveApplicationSegment(r io.ReaderAt, size int64, w parser.Writer, appSegments []ApplicationSegment) error {
+	return ReplaceApplicationSegments(r, size, w, appSegments, make([][]byte, len(appSegments)))
+}
+
+// ReplaceApplicationSegments writes the file with each of appSegments replaced by the segment at the same index in
+// contents, or removed if it is nil.  appSegments must be in the order of the file.
+func ReplaceApplicationSegments(r io.ReaderAt, size int64, w parser.Writer, appSegments []ApplicationSegment, contents [][]byte) error {
+	return w.Replace(func(writer io.Writer) error {
+		offset := int64(0)
+		for i, a := range appSegments {
+			_, err := io.Copy(writer, io.NewSectionReader(r, offset, a.StartOffset-offset))
+			if err != nil {
+				return err
+			}
+			_, err = writer.Write(contents[i])
+			if err != nil {
+				return err
+			}
+			offset = a.StartOffset + int64(a.Length) + 2
+		}
+		_, err := io.Copy(writer, io.NewSectionReader(r, offset, size-offset))
+		return err
+	})
 }
 
 type ApplicationSegment struct {
@@ -336,7 +492,21 @@ func (m *ApplicationSegment) IsExtendedXMPSegment() bool {
 	return string(m.Raw[4:38]) == "http://ns.adobe.com/xmp/extension/"
 }
 
-func (m *ApplicationSegment) GetIFDs() []shared.IFD {
+// SelectorNames returns the names used by parser.Selector to choose this segment.
+func (m *ApplicationSegment) SelectorNames() []string {
+	if m.IsJFIFSegment() || m.IsJFXXSegment() {
+		return []string{"jfif", "jpeg.jfif"}
+	} else if m.IsEXIFSegment() {
+		return []string{"exif", "jpeg.exif"}
+	} else if m.IsICCProfileSegment() {
+		return []string{"icc", "jpeg.icc"}
+	} else if m.IsXMPSegment() || m.IsExtendedXMPSegment() {
+		return []string{"xmp", "jpeg.xmp"}
+	}
+	return []string{fmt.Sprintf("jpeg.app%d", m.Marker[1]-0xE0)}
+}
+
+func (m *ApplicationSegment) GetIFDs() ([]shared.IFD, error) {
 	return shared.ParseExif(m.Raw[4:])
 }
 
@@ -363,3 +533,25 @@ type Metadata struct {
 	UnsupportedMarkers []ApplicationSegment
 	XMP                []string
 }
+
+func (m *Metadata) Describe() *document.Document {
+	doc := &document.Document{}
+	jfif := doc.AddSection("jfif", "JFIF Segments")
+	jfif.AddBool("jfifThumbnail", "Has JFIF Thumbnail", m.JFIFThumbnail)
+	jfif.AddBool("jfxxThumbnail", "Has JFXX Thumbnail", m.JFXXThumbnail)
+	doc.Sections = append(doc.Sections, shared.ExifSections(m.IFDs)...)
+	if m.XMP != nil {
+		xmp := doc.AddSection("xmp", "XMP")
+		for i, s := range m.XMP {
+			xmp.AddText(fmt.Sprintf("%d", i), "", s)
+		}
+	}
+	for i, s := range m.UnsupportedMarkers {
+		segment := doc.AddSection(fmt.Sprintf("segment.%d", i), fmt.Sprintf("Application Segment 0x%04X", s.Marker))
+		segment.AddBinary("raw", "", s.Raw)
+	}
+	if m.ICCProfile != nil {
+		doc.Sections = append(doc.Sections, shared.ICCSection(m.ICCProfile))
+	}
+	return doc
+}
@@ -0,0 +1,98 @@
+package jpeg
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+)
+
+// Verify checks that the file starts with an SOI marker, that segments fit in the file and that the image data is
+// terminated by an EOI marker.
+func Verify(r io.ReaderAt, size int64) ([]parser.Problem, error) {
+	var problems []parser.Problem
+	marker := make([]byte, 2)
+	_, err := r.ReadAt(marker, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if marker[0] != 0xFF || marker[1] != 0xD8 {
+		problems = append(problems, parser.NewError("SOI marker", 0, "file doesn't start with SOI marker"))
+		return problems, nil
+	}
+	offset := int64(2)
+	for offset+2 <= size {
+		_, err = r.ReadAt(marker, offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		if marker[0] != 0xFF {
+			problems = append(problems, parser.NewError("file", offset, "expected a marker but found 0x%02X", marker[0]))
+			return problems, nil
+		}
+		if marker[1] == 0xFF {
+			// Fill byte before a marker
+			offset += 1
+			continue
+		}
+		target := fmt.Sprintf("marker 0x%02X", marker[1])
+		if marker[1] == 0xD9 {
+			if offset+2 < size {
+				problems = append(problems, parser.NewWarning("EOI marker", offset, "%d bytes after EOI marker", size-offset-2))
+			}
+			return problems, nil
+		}
+		if marker[1] == 0x01 || (marker[1] >= 0xD0 && marker[1] <= 0xD7) {
+			offset += 2
+			continue
+		}
+		if offset+4 > size {
+			break
+		}
+		lengthRaw := make([]byte, 2)
+		_, err = r.ReadAt(lengthRaw, offset+2)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		length := int64(binary.BigEndian.Uint16(lengthRaw))
+		if length < 2 {
+			problems = append(problems, parser.NewError(target, offset, "invalid segment length %d", length))
+			return problems, nil
+		}
+		if offset+2+length > size {
+			problems = append(problems, parser.NewError(target, offset, "segment length %d overruns the end of file", length))
+			return problems, nil
+		}
+		offset += 2 + length
+		if marker[1] == 0xDA {
+			offset, err = skipScanData(r, offset, size)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	problems = append(problems, parser.NewError("EOI marker", size, "EOI marker not found"))
+	return problems, nil
+}
+
+// skipScanData returns the offset of the first marker after the entropy-coded data starting at offset, or size if
+// there is no marker.  Stuffed zero bytes and restart markers are part of the data.
+func skipScanData(r io.ReaderAt, offset int64, size int64) (int64, error) {
+	reader := bufio.NewReader(io.NewSectionReader(r, offset, size-offset))
+	previous := byte(0)
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read file: %w", err)
+		}
+		if previous == 0xFF && b != 0x00 && b != 0xFF && (b < 0xD0 || b > 0xD7) {
+			return offset - 1, nil
+		}
+		previous = b
+		offset += 1
+	}
+}
@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"fmt"
+	"jch-metadata/internal/document"
+	"strings"
+)
+
+const (
+	ExifKind     = "EXIF data"
+	XMPKind      = "XMP packet"
+	ICCKind      = "ICC profile"
+	CommentsKind = "comments"
+)
+
+// Metadata is the metadata read from a file by the copy action, stored in a form that can be written into files of
+// other formats.
+type Metadata struct {
+	// Exif is the EXIF data starting with the TIFF header, without the "Exif\0\0" prefix.
+	Exif []byte
+	// XMP is the XMP packet.
+	XMP []byte
+	// ICC is the ICC profile.
+	ICC []byte
+	// Comments are textual values with upper case names, such as TITLE or ARTIST.
+	Comments []Field
+}
+
+// AddComment adds a textual value.  The name is converted to upper case and empty values are ignored.
+func (m *Metadata) AddComment(name string, value string) {
+	if name == "" || value == "" {
+		return
+	}
+	m.Comments = append(m.Comments, Field{Key: strings.ToUpper(name), Value: value})
+}
+
+// Kinds returns the kinds of metadata stored in m.
+func (m *Metadata) Kinds() []string {
+	var result []string
+	if len(m.Exif) > 0 {
+		result = append(result, ExifKind)
+	}
+	if len(m.XMP) > 0 {
+		result = append(result, XMPKind)
+	}
+	if len(m.ICC) > 0 {
+		result = append(result, ICCKind)
+	}
+	if len(m.Comments) > 0 {
+		result = append(result, CommentsKind)
+	}
+	return result
+}
+
+// ExportMetadata reads the metadata of input with the first parser that supports it.
+func ExportMetadata(parsers []Parser, input *Input) (*Metadata, error) {
+	for _, p := range parsers {
+		supported, err := p.Support(input.Reader, input.Size)
+		if err != nil {
+			return nil, err
+		}
+		if !supported {
+			continue
+		}
+		if p.Export == nil {
+			return nil, fmt.Errorf("copying metadata from %s files is not supported", p.Name)
+		}
+		return p.Export(input)
+	}
+	return nil, fmt.Errorf("unsupported file type: %s", input.Name)
+}
+
+// DescribeCopy returns the result of the copy action.  The kinds of metadata in source that are missing from
+// supported are reported as skipped.
+func DescribeCopy(source *Metadata, supported []string, changes []Change, dryRun bool) *document.Document {
+	var doc *document.Document
+	if dryRun {
+		doc = DescribeChanges(changes)
+	} else if len(changes) == 0 {
+		doc = document.Message("Nothing to copy")
+	} else {
+		doc = document.Message("Metadata has been copied!")
+	}
+	for _, k := range source.Kinds() {
+		found := false
+		for _, s := range supported {
+			if s == k {
+				found = true
+			}
+		}
+		if !found {
+			doc.AddMessage("Skipped %s, it is not supported by this format", k)
+		}
+	}
+	return doc
+}
@@ -0,0 +1,62 @@
+package mkv
+
+import (
+	"errors"
+	"fmt"
+	"jch-metadata/internal/document"
+	"jch-metadata/internal/parser"
+	"jch-metadata/internal/parser/shared"
+	"time"
+)
+
+// Audit returns the values of the Info element and SimpleTags that may leak personal data.  Supported attachments
+// are audited into nested documents.
+func Audit(input *parser.Input, parsers []parser.Parser, options parser.Options) (*document.Document, error) {
+	metadata, err := GetMetadata(input.Reader, input.Size)
+	if err != nil {
+		return nil, err
+	}
+	exported, err := ExportMetadata(input.Reader, input.Size)
+	if err != nil {
+		return nil, err
+	}
+	var findings []parser.Finding
+	for _, m := range metadata {
+		if m.Info.WritingApp != "" {
+			findings = append(findings, parser.Finding{Category: parser.SoftwareCategory, Source: "Info WritingApp", Value: m.Info.WritingApp})
+		}
+		if m.Info.MuxingApp != "" {
+			findings = append(findings, parser.Finding{Category: parser.SoftwareCategory, Source: "Info MuxingApp", Value: m.Info.MuxingApp})
+		}
+		if m.Info.DateUTC.Year() > 1970 {
+			findings = append(findings, parser.Finding{Category: parser.TimestampCategory, Source: "Info DateUTC", Value: m.Info.DateUTC.Format(time.RFC3339)})
+		}
+	}
+	exportedFindings, err := shared.AuditMetadata(exported)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, exportedFindings...)
+	doc := parser.DescribeFindings(findings, options.Policy)
+	for _, m := range metadata {
+		for i, a := range m.Attachments {
+			item := input.Item("attachment", i, a.Name, a.DataAt, a.Size)
+			if item.Depth > options.MaxNestingLevel() {
+				continue
+			}
+			content, err := parser.StartParsing(parsers, item, parser.AuditAction, options)
+			if errors.Is(err, parser.ErrUnsupportedAction) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error while auditing attachment [%s]: %w", a.Name, err)
+			}
+			if content != nil {
+				attachment := doc.AddSection(fmt.Sprintf("attachment.%d", i), "Attachment")
+				attachment.AddString("name", "Name", a.Name)
+				attachment.AddDocument(content)
+			}
+		}
+	}
+	return doc, nil
+}
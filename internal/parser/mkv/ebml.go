@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"os"
+	"io"
 	"time"
 )
 
@@ -13,7 +13,7 @@ type EBMLElement struct {
 	Size      uint64
 	StartAt   int64
 	DataAt    int64
-	File      *os.File
+	Reader    io.ReaderAt
 	Elements  []EBMLElement
 }
 
@@ -40,7 +40,7 @@ func (e *EBMLElement) FindFirstElement(elementId []byte, value []byte) *EBMLElem
 	for fileOffset < limit {
 		var element *EBMLElement
 		var err error
-		element, fileOffset, err = NewEBMLElement(e.File, fileOffset)
+		element, fileOffset, err = NewEBMLElement(e.Reader, fileOffset)
 		if err != nil {
 			return nil
 		}
@@ -64,7 +64,7 @@ func (e *EBMLElement) GetElements() []EBMLElement {
 	if e.Elements != nil {
 		return e.Elements
 	} else {
-		elements, _ := GetEBMLElements(e.File, e.DataAt, e.DataAt+int64(e.Size), 9999)
+		elements, _ := GetEBMLElements(e.Reader, e.DataAt, e.DataAt+int64(e.Size), 9999)
 		e.Elements = elements
 		return elements
 	}
@@ -72,7 +72,7 @@ func (e *EBMLElement) GetElements() []EBMLElement {
 
 func (e *EBMLElement) GetBytes() ([]byte, error) {
 	data := make([]byte, e.Size)
-	_, err := e.File.ReadAt(data, e.DataAt)
+	_, err := e.Reader.ReadAt(data, e.DataAt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
@@ -126,7 +126,7 @@ func (e *EBMLElement) DateValue() (time.Time, error) {
 	}
 }
 
-func (e *EBMLElement) ClearValue() error {
+func (e *EBMLElement) ClearValue(w io.WriterAt) error {
 	if e.Size == 0 {
 		return nil
 	}
@@ -134,21 +134,21 @@ func (e *EBMLElement) ClearValue() error {
 	for i := range data {
 		data[i] = 0
 	}
-	_, err := e.File.WriteAt(data, e.DataAt)
+	_, err := w.WriteAt(data, e.DataAt)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func GetEBMLElements(file *os.File, fileOffset int64, limit int64, count int) ([]EBMLElement, error) {
+func GetEBMLElements(r io.ReaderAt, fileOffset int64, limit int64, count int) ([]EBMLElement, error) {
 	var result []EBMLElement
 	c := 0
 
 	for fileOffset < limit && c < count {
 		var element *EBMLElement
 		var err error
-		element, fileOffset, err = NewEBMLElement(file, fileOffset)
+		element, fileOffset, err = NewEBMLElement(r, fileOffset)
 		if err != nil {
 			return nil, err
 		}
@@ -167,24 +167,24 @@ func SearchEBMLElements(elementID []byte, elements []EBMLElement) *EBMLElement {
 	return nil
 }
 
-func NewEBMLElement(file *os.File, fileOffset int64) (*EBMLElement, int64, error) {
+func NewEBMLElement(r io.ReaderAt, fileOffset int64) (*EBMLElement, int64, error) {
 	elementIdData := make([]byte, 8)
-	_, err := file.ReadAt(elementIdData, fileOffset)
-	if err != nil {
+	n, err := r.ReadAt(elementIdData, fileOffset)
+	if err != nil && (err != io.EOF || n == 0) {
 		return nil, fileOffset, fmt.Errorf("failed to read file: %w", err)
 	}
 	_, offset := GetVSize(elementIdData[:])
 	element := EBMLElement{
 		ElementID: elementIdData[0 : 0+offset],
 		StartAt:   fileOffset,
-		File:      file,
+		Reader:    r,
 	}
 	fileOffset += int64(offset)
 
 	// Retrieve elementSize
 	sizeData := make([]byte, 8)
-	_, err = file.ReadAt(sizeData, fileOffset)
-	if err != nil {
+	n, err = r.ReadAt(sizeData, fileOffset)
+	if err != nil && (err != io.EOF || n == 0) {
 		return nil, fileOffset, fmt.Errorf("failed to read file: %w", err)
 	}
 	size, offset := GetVSize(sizeData[:])
@@ -217,3 +217,62 @@ func GetVSize(v []byte) (uint64, int) {
 	}
 	return binary.BigEndian.Uint64(result), i
 }
+
+// Raw returns the content of the element including its ID and size.
+func (e *EBMLElement) Raw() ([]byte, error) {
+	data := make([]byte, e.DataAt-e.StartAt+int64(e.Size))
+	_, err := e.Reader.ReadAt(data, e.StartAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return data, nil
+}
+
+// EncodeVSize encodes value as a variable size integer of length bytes.  It returns nil if value doesn't fit, since
+// a value with all bits set is reserved for unknown sizes.
+func EncodeVSize(value uint64, length int) []byte {
+	if length < 1 || length > 8 || value >= (1<<(7*length))-1 {
+		return nil
+	}
+	result := make([]byte, 8)
+	binary.BigEndian.PutUint64(result, value)
+	result = result[8-length:]
+	result[0] |= byte(0x80) >> (length - 1)
+	return result
+}
+
+// EncodeElement returns an element with the smallest size field that can store data.
+func EncodeElement(elementId []byte, data []byte) []byte {
+	length := 1
+	for EncodeVSize(uint64(len(data)), length) == nil {
+		length++
+	}
+	return EncodeElementWithSizeLength(elementId, data, length)
+}
+
+func EncodeElementWithSizeLength(elementId []byte, data []byte, length int) []byte {
+	result := append([]byte{}, elementId...)
+	result = append(result, EncodeVSize(uint64(len(data)), length)...)
+	return append(result, data...)
+}
+
+// VoidElement returns a Void element of size bytes, which must be at least 2.
+func VoidElement(size int64) []byte {
+	for length := 1; length <= 8; length++ {
+		dataSize := size - 1 - int64(length)
+		if dataSize >= 0 && EncodeVSize(uint64(dataSize), length) != nil {
+			return EncodeElementWithSizeLength([]byte{0xEC}, make([]byte, dataSize), length)
+		}
+	}
+	return nil
+}
+
+// EncodeUint encodes value as an unsigned integer of length bytes.  It returns nil if value doesn't fit.
+func EncodeUint(value uint64, length int) []byte {
+	if length < 8 && value >= 1<<(8*length) {
+		return nil
+	}
+	result := make([]byte, 8)
+	binary.BigEndian.PutUint64(result, value)
+	return result[8-length:]
+}
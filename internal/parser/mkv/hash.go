@@ -0,0 +1,47 @@
+package mkv
+
+import (
+	"bytes"
+	"io"
+	"jch-metadata/internal/parser"
+)
+
+// EssenceRanges returns the Cluster elements of every segment, which store the blocks of the tracks.
+func EssenceRanges(r io.ReaderAt, size int64) ([]parser.Range, error) {
+	var result []parser.Range
+	for offset := int64(0); offset < size; {
+		segment, next, err := NewEBMLElement(r, offset)
+		if err != nil {
+			return nil, err
+		}
+		end := next
+		if isUnknownSize(segment) || end > size {
+			end = size
+		}
+		if bytes.Equal(segment.ElementID, []byte{0x18, 0x53, 0x80, 0x67}) {
+			for child := segment.DataAt; child < end; {
+				element, childNext, err := NewEBMLElement(r, child)
+				if err != nil {
+					return nil, err
+				}
+				if isUnknownSize(element) || childNext > end {
+					// Clusters of live streams extend to the end of the segment
+					childNext = end
+				}
+				if bytes.Equal(element.ElementID, []byte{0x1F, 0x43, 0xB6, 0x75}) {
+					result = append(result, parser.Range{Offset: element.StartAt, Size: childNext - element.StartAt})
+				}
+				child = childNext
+			}
+		}
+		offset = end
+	}
+	return result, nil
+}
+
+// isUnknownSize returns true if all bits of the size of element are set, which means that it extends to the end of
+// its parent.
+func isUnknownSize(element *EBMLElement) bool {
+	sizeLength := int(element.DataAt - element.StartAt - int64(len(element.ElementID)))
+	return element.Size == (1<<(7*sizeLength))-1
+}
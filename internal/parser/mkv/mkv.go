@@ -3,8 +3,10 @@ package mkv
 import (
 	"bytes"
 	"fmt"
-	"jch-metadata/internal/output"
+	"io"
+	"jch-metadata/internal/document"
 	"jch-metadata/internal/parser"
+	"jch-metadata/internal/safewrite"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,121 +16,169 @@ import (
 var Parser = parser.Parser{
 	Name:      "Mkv (Matroska)",
 	Container: true,
-	Support: func(file *os.File, startOffset int64, length int64) (bool, error) {
-		return IsMkv(file, startOffset)
+	Support: func(r io.ReaderAt, size int64) (bool, error) {
+		return IsMkv(r)
 	},
-	Handle: func(file *os.File, action parser.Action, startOffset int64, length int64, parsers []parser.Parser) error {
+	Handle: func(input *parser.Input, action parser.Action, parsers []parser.Parser, options parser.Options) (*document.Document, error) {
 		if action == parser.ShowAction {
-			metadata, err := GetMetadata(file)
+			metadata, err := GetMetadata(input.Reader, input.Size)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			for _, m := range metadata {
-				err := Show(m, file, parsers)
+			doc := &document.Document{}
+			for i, m := range metadata {
+				err = m.Describe(doc, input, parsers, options, i)
 				if err != nil {
-					return err
+					return nil, err
 				}
 			}
+			return doc, nil
 		} else if action == parser.ClearAction {
-			err := ClearMetadata(file)
+			changes, err := ClearMetadata(input.Reader, input.Size, input.Writer, options.Selector, options.DryRun)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			output.Println(false, "Metadata cleared")
+			if options.DryRun {
+				return parser.DescribeChanges(changes), nil
+			}
+			if len(changes) == 0 {
+				return document.Message("No metadata to clear!"), nil
+			}
+			doc := &document.Document{}
+			doc.AddMessage("Values of %d elements have been removed!", len(changes))
+			doc.AddMessage("Metadata cleared")
+			return doc, nil
+		} else if action == parser.SetAction {
+			changes, err := SetFields(input.Reader, input.Size, input.Writer, options.Fields, options.DryRun)
+			if err != nil {
+				return nil, err
+			}
+			if options.DryRun {
+				return parser.DescribeChanges(changes), nil
+			}
+			return document.Message("Metadata has been written!"), nil
+		} else if action == parser.CopyAction {
+			var changes []parser.Change
+			if len(options.Source.Comments) > 0 {
+				var err error
+				changes, err = SetFields(input.Reader, input.Size, input.Writer, options.Source.Comments, options.DryRun)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return parser.DescribeCopy(options.Source, []string{parser.CommentsKind}, changes, options.DryRun), nil
+		} else if action == parser.VerifyAction {
+			problems, err := Verify(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeProblems(problems), nil
+		} else if action == parser.AuditAction {
+			return Audit(input, parsers, options)
+		} else if action == parser.HashAction {
+			ranges, err := EssenceRanges(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeHash(input.Reader, ranges)
 		} else if action == parser.ExtractAction {
-			attachmentElement, err := GetElementFromSeek(file, []byte{0x19, 0x41, 0xA4, 0x69})
+			attachmentElement, err := GetElementFromSeek(input.Reader, input.Size, []byte{0x19, 0x41, 0xA4, 0x69})
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if attachmentElement == nil {
-				fmt.Println("No attachment to extract!")
-				return nil
+				return document.Message("No attachment to extract!"), nil
 			}
+			doc := &document.Document{}
 			attachments := NewAttachments(attachmentElement)
 			for _, a := range attachments {
-				output.Printf(false, "Extracting attachment %s...", a.Name)
-				err = ExtractAttachment(file, a)
+				filename, err := ExtractAttachment(input.Reader, input.Name, a, options.OutputDir)
 				if err != nil {
-					return err
+					return nil, err
 				}
+				doc.AddMessage("Attachment %s has been extracted to %s", a.Name, filename)
 			}
-		} else {
-			output.Printf(false, "Unsupported action: %s\n", action)
+			return doc, nil
 		}
-		return nil
+		return document.Message("Unsupported action: %s", action), nil
+	},
+	Export: func(input *parser.Input) (*parser.Metadata, error) {
+		return ExportMetadata(input.Reader, input.Size)
+	},
+	Items: func(input *parser.Input) ([]*parser.Input, error) {
+		metadata, err := GetMetadata(input.Reader, input.Size)
+		if err != nil {
+			return nil, err
+		}
+		var result []*parser.Input
+		for _, m := range metadata {
+			for i, a := range m.Attachments {
+				result = append(result, input.Item("attachment", i, a.Name, a.DataAt, a.Size))
+			}
+		}
+		return result, nil
 	},
 }
 
-func Show(m Metadata, file *os.File, parsers []parser.Parser) error {
-	output.PrintHeader(false, "Info")
-	output.PrintForm(false, "Filename", m.Info.Filename, 13)
-	var dateStr string
+// Describe adds the content of a segment to doc.  Supported attachments are parsed into nested documents.
+func (m *Metadata) Describe(doc *document.Document, input *parser.Input, parsers []parser.Parser, options parser.Options, segment int) error {
+	prefix := ""
+	if segment > 0 {
+		prefix = fmt.Sprintf("segment.%d.", segment)
+	}
+	info := doc.AddSection(prefix+"info", "Info")
+	info.AddString("filename", "Filename", m.Info.Filename)
 	if m.Info.DateUTC.Year() <= 1970 {
-		dateStr = ""
+		info.AddString("date", "Date", "")
 	} else {
-		dateStr = m.Info.DateUTC.String()
+		info.AddTime("date", "Date", m.Info.DateUTC)
 	}
-	output.PrintForm(false, "Date", dateStr, 13)
-	output.PrintForm(false, "Title", m.Info.Title, 13)
-	output.PrintForm(false, "Muxing App", m.Info.MuxingApp, 13)
-	output.PrintForm(false, "Writing App", m.Info.WritingApp, 13)
-	output.Println(false)
+	info.AddString("title", "Title", m.Info.Title)
+	info.AddString("muxingApp", "Muxing App", m.Info.MuxingApp)
+	info.AddString("writingApp", "Writing App", m.Info.WritingApp)
 
 	for _, t := range m.Tracks {
-		output.PrintHeader(false, "Track %d", t.Number)
-		output.PrintForm(false, "Name", t.Name, 13)
-		output.PrintForm(false, "Type", GetTrackType(t.Type), 13)
-		output.PrintForm(false, "Language", t.Language, 13)
-		output.Println(false)
-	}
-
-	for _, a := range m.Attachments {
-		output.PrintHeader(false, "Attachment")
-		output.PrintForm(false, "Name", a.Name, 13)
-		output.PrintForm(false, "Media Type", a.MediaType, 13)
-		output.PrintForm(false, "Description", a.Description, 13)
-		output.Println(false)
-		parsed, err := parser.StartParsing(parsers, file, parser.ShowAction, a.DataAt, a.Size)
+		track := doc.AddSection(fmt.Sprintf("%strack.%d", prefix, t.Number), fmt.Sprintf("Track %d", t.Number))
+		track.AddString("name", "Name", t.Name)
+		track.AddString("type", "Type", GetTrackType(t.Type))
+		track.AddString("language", "Language", t.Language)
+	}
+
+	for i, a := range m.Attachments {
+		attachment := doc.AddSection(fmt.Sprintf("%sattachment.%d", prefix, i), "Attachment")
+		attachment.AddString("name", "Name", a.Name)
+		attachment.AddString("mediaType", "Media Type", a.MediaType)
+		attachment.AddString("description", "Description", a.Description)
+		err := parser.DescribeItem(attachment, parsers, input.Item("attachment", i, a.Name, a.DataAt, a.Size), parser.ShowAction, options)
 		if err != nil {
-			return fmt.Errorf("error while processing attachment [%s]: %w", a.Name, err)
-		}
-		if !parsed {
-			output.Println(true, "Unsupported file type")
+			return err
 		}
-		output.Println(false)
 	}
 
-	for _, t := range m.Tags {
+	for i, t := range m.Tags {
 		if t.Name == "BPS" {
 			continue
 		}
-		output.PrintHeader(false, "Tag")
-		output.PrintForm(false, "Name", t.Name, 13)
-		output.PrintForm(false, "Target Type", t.TargetType, 13)
-		output.PrintForm(false, "Language", t.Language, 13)
-		output.PrintForm(false, "Value", t.Value, 13)
-		output.Println(false)
+		tag := doc.AddSection(fmt.Sprintf("%stag.%d", prefix, i), "Tag")
+		tag.AddString("name", "Name", t.Name)
+		tag.AddString("targetType", "Target Type", t.TargetType)
+		tag.AddString("language", "Language", t.Language)
+		tag.AddString("value", "Value", t.Value)
 	}
-
 	return nil
 }
 
-func IsMkv(file *os.File, startOffset int64) (bool, error) {
+func IsMkv(r io.ReaderAt) (bool, error) {
 	magicBytes := make([]byte, 4)
-	_, err := file.ReadAt(magicBytes, startOffset)
+	_, err := r.ReadAt(magicBytes, 0)
 	if err != nil {
 		return false, fmt.Errorf("failed to read file: %w", err)
 	}
 	return bytes.Equal(magicBytes, []byte{0x1A, 0x45, 0xDF, 0xA3}), nil
 }
 
-func ParseFile(file *os.File) ([]EBMLElement, error) {
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file stat: %w", err)
-	}
-	fileSize := fileInfo.Size()
-	return GetEBMLElements(file, 0, fileSize, 9999)
+func ParseFile(r io.ReaderAt, size int64) ([]EBMLElement, error) {
+	return GetEBMLElements(r, 0, size, 9999)
 }
 
 func GetStringValue(elementId []byte, elements []EBMLElement) string {
@@ -158,13 +208,14 @@ func GetDateValue(elementId []byte, elements []EBMLElement) time.Time {
 	return v
 }
 
-func ClearValue(elementId []byte, elements []EBMLElement) error {
+func ClearValue(w io.WriterAt, elementId []byte, elements []EBMLElement) error {
 	element := SearchEBMLElements(elementId, elements)
 	if element == nil {
 		return nil
 	}
-	return element.ClearValue()
+	return element.ClearValue(w)
 }
+
 func GetTrackType(value uint64) string {
 	switch value {
 	case 1:
@@ -188,10 +239,10 @@ func GetTrackType(value uint64) string {
 	}
 }
 
-func GetMetadata(file *os.File) ([]Metadata, error) {
+func GetMetadata(r io.ReaderAt, size int64) ([]Metadata, error) {
 	var result []Metadata
 	metadata := Metadata{}
-	infoElement, err := GetElementFromSeek(file, []byte{0x15, 0x49, 0xA9, 0x66})
+	infoElement, err := GetElementFromSeek(r, size, []byte{0x15, 0x49, 0xA9, 0x66})
 	if err != nil {
 		return nil, err
 	}
@@ -203,7 +254,7 @@ func GetMetadata(file *os.File) ([]Metadata, error) {
 	metadata.Info.WritingApp = GetStringValue([]byte{0x57, 0x41}, infoElements)
 
 	var tracks []Track
-	trackElement, err := GetElementFromSeek(file, []byte{0x16, 0x54, 0xAE, 0x6B})
+	trackElement, err := GetElementFromSeek(r, size, []byte{0x16, 0x54, 0xAE, 0x6B})
 	if err != nil {
 		return nil, err
 	}
@@ -225,7 +276,7 @@ func GetMetadata(file *os.File) ([]Metadata, error) {
 	metadata.Tracks = tracks
 
 	var attachments []Attachment
-	attachmentElement, err := GetElementFromSeek(file, []byte{0x19, 0x41, 0xA4, 0x69})
+	attachmentElement, err := GetElementFromSeek(r, size, []byte{0x19, 0x41, 0xA4, 0x69})
 	if err != nil {
 		return nil, err
 	}
@@ -235,7 +286,7 @@ func GetMetadata(file *os.File) ([]Metadata, error) {
 	metadata.Attachments = attachments
 
 	var tags []Tag
-	tagElement, err := GetElementFromSeek(file, []byte{0x12, 0x54, 0xC3, 0x67})
+	tagElement, err := GetElementFromSeek(r, size, []byte{0x12, 0x54, 0xC3, 0x67})
 	if err != nil {
 		return nil, err
 	}
@@ -266,46 +317,133 @@ func GetMetadata(file *os.File) ([]Metadata, error) {
 	return result, nil
 }
 
-func ClearMetadata(file *os.File) error {
-	elements, err := ParseFile(file)
+// statisticsTags are the SimpleTags describing a single track that are not copied to other files.
+var statisticsTags = map[string]bool{
+	"BPS":                          true,
+	"DURATION":                     true,
+	"NUMBER_OF_FRAMES":             true,
+	"NUMBER_OF_BYTES":              true,
+	"_STATISTICS_TAGS":             true,
+	"_STATISTICS_WRITING_APP":      true,
+	"_STATISTICS_WRITING_DATE_UTC": true,
+}
+
+// ExportMetadata returns the values of the SimpleTags of the first segment.  The title of the Info element is used
+// as TITLE if there is no SimpleTag with that name.
+func ExportMetadata(r io.ReaderAt, size int64) (*parser.Metadata, error) {
+	metadata, err := GetMetadata(r, size)
+	if err != nil {
+		return nil, err
+	}
+	result := parser.Metadata{}
+	if len(metadata) == 0 {
+		return &result, nil
+	}
+	hasTitle := false
+	for _, t := range metadata[0].Tags {
+		name := strings.ToUpper(t.Name)
+		if statisticsTags[name] {
+			continue
+		}
+		hasTitle = hasTitle || name == "TITLE"
+		result.AddComment(name, t.Value)
+	}
+	if !hasTitle {
+		result.AddComment("TITLE", metadata[0].Info.Title)
+	}
+	return &result, nil
+}
+
+// ClearMetadata zeroes the values of Info elements chosen by selector in every segment and returns the changes.
+// Values of SimpleTag elements are only zeroed if "mkv.tags" is selected.  If dryRun is true, nothing is written.
+func ClearMetadata(r io.ReaderAt, size int64, w io.WriterAt, selector parser.Selector, dryRun bool) ([]parser.Change, error) {
+	elements, err := ParseFile(r, size)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	var cleared []*EBMLElement
+	var changes []parser.Change
 	for _, v := range elements {
 		if !bytes.Equal(v.ElementID, []byte{0x18, 0x53, 0x80, 0x67}) {
 			continue
 		}
 		e := v.GetElements()
 
-		output.Println(false, "Removing all values from Info elements...")
 		infoElement := SearchEBMLElements([]byte{0x15, 0x49, 0xA9, 0x66}, e)
 		infoElements := infoElement.GetElements()
-		err := ClearValue([]byte{0x73, 0x84}, infoElements)
-		if err != nil {
-			return err
-		}
-		err = ClearValue([]byte{0x7B, 0xA9}, infoElements)
-		if err != nil {
-			return err
+		for _, info := range infoValues {
+			if !selector.Removes("mkv.info." + strings.ToLower(info.name)) {
+				continue
+			}
+			element := SearchEBMLElements(info.elementId, infoElements)
+			if element == nil || element.Size == 0 {
+				continue
+			}
+			cleared = append(cleared, element)
+			changes = append(changes, parser.Change{
+				Operation: parser.ZeroOperation,
+				Target:    fmt.Sprintf("Info.%s element", info.name),
+				Offset:    element.DataAt,
+				Size:      int64(element.Size),
+			})
 		}
-		err = ClearValue([]byte{0x44, 0x61}, infoElements)
-		if err != nil {
-			return err
+
+		tagsElement := SearchEBMLElements([]byte{0x12, 0x54, 0xC3, 0x67}, e)
+		if tagsElement != nil && selector.Selects("mkv.tags") {
+			for _, element := range FindTagStrings(tagsElement.GetElements()) {
+				if element.Size == 0 {
+					continue
+				}
+				cleared = append(cleared, element)
+				changes = append(changes, parser.Change{
+					Operation: parser.ZeroOperation,
+					Target:    "SimpleTag.TagString element",
+					Offset:    element.DataAt,
+					Size:      int64(element.Size),
+				})
+			}
 		}
-		err = ClearValue([]byte{0x4D, 0x80}, infoElements)
+	}
+	if dryRun {
+		return changes, nil
+	}
+	for _, element := range cleared {
+		err := element.ClearValue(w)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		err = ClearValue([]byte{0x57, 0x41}, infoElements)
-		if err != nil {
-			return err
+	}
+	return changes, nil
+}
+
+// FindTagStrings returns the TagString elements of every SimpleTag, including nested SimpleTags, in elements.
+func FindTagStrings(elements []EBMLElement) []*EBMLElement {
+	var result []*EBMLElement
+	for i := range elements {
+		element := &elements[i]
+		if bytes.Equal(element.ElementID, []byte{0x44, 0x87}) {
+			result = append(result, element)
+		} else if bytes.Equal(element.ElementID, []byte{0x73, 0x73}) || bytes.Equal(element.ElementID, []byte{0x67, 0xC8}) {
+			result = append(result, FindTagStrings(element.GetElements())...)
 		}
 	}
-	return nil
+	return result
+}
+
+// infoValues are the Info elements cleared by ClearMetadata.
+var infoValues = []struct {
+	elementId []byte
+	name      string
+}{
+	{[]byte{0x73, 0x84}, "Filename"},
+	{[]byte{0x7B, 0xA9}, "Title"},
+	{[]byte{0x44, 0x61}, "DateUTC"},
+	{[]byte{0x4D, 0x80}, "MuxingApp"},
+	{[]byte{0x57, 0x41}, "WritingApp"},
 }
 
-func GetElementFromSeek(file *os.File, elementId []byte) (*EBMLElement, error) {
-	elements, err := ParseFile(file)
+func GetElementFromSeek(r io.ReaderAt, size int64, elementId []byte) (*EBMLElement, error) {
+	elements, err := ParseFile(r, size)
 	if err != nil {
 		return nil, err
 	}
@@ -326,7 +464,7 @@ func GetElementFromSeek(file *os.File, elementId []byte) (*EBMLElement, error) {
 	if err != nil {
 		return nil, err
 	}
-	result, err := GetEBMLElements(file, rootElement.DataAt+int64(offset), rootElement.DataAt+int64(rootElement.Size), 1)
+	result, err := GetEBMLElements(r, rootElement.DataAt+int64(offset), rootElement.DataAt+int64(rootElement.Size), 1)
 	if err != nil {
 		return nil, err
 	}
@@ -363,15 +501,15 @@ func NewAttachments(attachmentElement *EBMLElement) []Attachment {
 	return attachments
 }
 
-func ExtractAttachment(file *os.File, attachment Attachment) error {
+func ExtractAttachment(r io.ReaderAt, name string, attachment Attachment, outputDir string) (string, error) {
 	data := make([]byte, attachment.Size)
-	_, err := file.ReadAt(data, attachment.DataAt)
+	_, err := r.ReadAt(data, attachment.DataAt)
 	if err != nil {
-		return err
+		return "", err
 	}
-	err = os.MkdirAll("output", os.ModePerm)
+	err = os.MkdirAll(outputDir, 0755)
 	if err != nil {
-		return fmt.Errorf("error creating output directory: %w", err)
+		return "", fmt.Errorf("error creating output directory: %w", err)
 	}
 	ext := "raw"
 	if attachment.MediaType != "" {
@@ -380,48 +518,47 @@ func ExtractAttachment(file *os.File, attachment Attachment) error {
 			ext = m[1]
 		}
 	}
-	basename := filepath.Base(strings.TrimSuffix(file.Name(), ".mkv"))
-	filename := filepath.Join("output", fmt.Sprintf("%s_attachment_%02d.%s", basename, attachment.Index, ext))
-	err = os.WriteFile(filename, data, os.ModePerm)
+	basename := filepath.Base(strings.TrimSuffix(name, ".mkv"))
+	filename := filepath.Join(outputDir, fmt.Sprintf("%s_attachment_%02d.%s", basename, attachment.Index, ext))
+	err = safewrite.WriteFile(filename, data, 0644)
 	if err != nil {
-		return fmt.Errorf("error writing attachment %02d: %w", attachment.Index, err)
+		return "", fmt.Errorf("error writing attachment %02d: %w", attachment.Index, err)
 	}
-	output.Printf(false, "Attachment has been extracted to %s\n", filename)
-	return nil
+	return filename, nil
 }
 
 type Metadata struct {
 	Info struct {
-		Filename   string
-		DateUTC    time.Time
-		Title      string
-		MuxingApp  string
-		WritingApp string
-	}
-	Tracks      []Track
-	Attachments []Attachment
-	Tags        []Tag
+		Filename   string    `json:"filename"`
+		DateUTC    time.Time `json:"dateUTC"`
+		Title      string    `json:"title"`
+		MuxingApp  string    `json:"muxingApp"`
+		WritingApp string    `json:"writingApp"`
+	} `json:"info"`
+	Tracks      []Track      `json:"tracks,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Tags        []Tag        `json:"tags,omitempty"`
 }
 
 type Track struct {
-	Number   uint64
-	Name     string
-	Type     uint64
-	Language string
+	Number   uint64 `json:"number"`
+	Name     string `json:"name"`
+	Type     uint64 `json:"type"`
+	Language string `json:"language"`
 }
 
 type Attachment struct {
-	Index       int
-	Name        string
-	MediaType   string
-	Description string
-	DataAt      int64
-	Size        int64
+	Index       int    `json:"index"`
+	Name        string `json:"name"`
+	MediaType   string `json:"mediaType"`
+	Description string `json:"description"`
+	DataAt      int64  `json:"offset"`
+	Size        int64  `json:"size"`
 }
 
 type Tag struct {
-	Name       string
-	TargetType string
-	Language   string
-	Value      string
+	Name       string `json:"name"`
+	TargetType string `json:"targetType"`
+	Language   string `json:"language"`
+	Value      string `json:"value"`
 }
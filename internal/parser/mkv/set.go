@@ -0,0 +1,366 @@
+package mkv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+	"sort"
+	"strings"
+)
+
+// elementUpdate is a master element of the segment that is replaced by new content.
+type elementUpdate struct {
+	name    string
+	element *EBMLElement
+	data    []byte
+}
+
+// crcElementId is the ID of CRC-32 elements, which hold a checksum of the other children of their parent.
+var crcElementId = []byte{0xBF}
+
+// patch is a range of the file overwritten by the set action.
+type patch struct {
+	offset int64
+	data   []byte
+}
+
+// SetFields writes fields into the first segment.  Fields named "info.<name>", such as "info.title", set the string
+// values of the Info element and other fields set the value of SimpleTags with the same name, adding a new Tag if
+// needed.  An empty value removes the element.
+//
+// Elements are rewritten in place using the space of a following Void element.  If there is not enough room, the
+// element is replaced by a Void element and moved to the end of the segment, which must be the end of the file.  The
+// Info element can't be moved after the Clusters and the Tags element can only be moved there if the SeekHead
+// references it.  Segments of unknown size and elements protected by a CRC-32 element are not supported.  If dryRun
+// is true, the changes are returned without writing them.
+func SetFields(r io.ReaderAt, size int64, w parser.Writer, fields []parser.Field, dryRun bool) ([]parser.Change, error) {
+	elements, err := ParseFile(r, size)
+	if err != nil {
+		return nil, err
+	}
+	segment := SearchEBMLElements([]byte{0x18, 0x53, 0x80, 0x67}, elements)
+	if segment == nil {
+		return nil, fmt.Errorf("segment not found")
+	}
+	sizeLength := int(segment.DataAt - segment.StartAt - int64(len(segment.ElementID)))
+	if segment.Size == (1<<(7*sizeLength))-1 {
+		return nil, fmt.Errorf("segment of unknown size is not supported")
+	}
+	children := segment.GetElements()
+	if SearchEBMLElements(crcElementId, children) != nil {
+		return nil, fmt.Errorf("segment protected by a CRC-32 element is not supported")
+	}
+	hasClusters := SearchEBMLElements([]byte{0x1F, 0x43, 0xB6, 0x75}, children) != nil
+
+	var infoFields, tagFields []parser.Field
+	for _, f := range fields {
+		if strings.HasPrefix(strings.ToLower(f.Key), "info.") {
+			infoFields = append(infoFields, f)
+		} else {
+			tagFields = append(tagFields, f)
+		}
+	}
+	var updates []elementUpdate
+	if len(infoFields) > 0 {
+		info := SearchEBMLElements([]byte{0x15, 0x49, 0xA9, 0x66}, children)
+		if info == nil {
+			return nil, fmt.Errorf("Info element not found")
+		}
+		data, err := setInfoValues(info, infoFields)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, elementUpdate{name: "Info", element: info, data: data})
+	}
+	if len(tagFields) > 0 {
+		tags := SearchEBMLElements([]byte{0x12, 0x54, 0xC3, 0x67}, children)
+		if tags == nil {
+			return nil, fmt.Errorf("Tags element not found, adding a new Tags element is not supported")
+		}
+		data, err := setSimpleTags(tags, tagFields)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, elementUpdate{name: "Tags", element: tags, data: data})
+	}
+
+	var changes []parser.Change
+	var patches []patch
+	var appended []byte
+	segmentEnd := segment.DataAt + int64(segment.Size)
+	for _, u := range updates {
+		regionEnd := u.element.DataAt + int64(u.element.Size)
+		for _, c := range children {
+			if c.StartAt == regionEnd && bytes.Equal(c.ElementID, []byte{0xEC}) {
+				regionEnd = c.DataAt + int64(c.Size)
+				break
+			}
+		}
+		regionSize := regionEnd - u.element.StartAt
+		if encoded := fitElement(u.element.ElementID, u.data, regionSize); encoded != nil {
+			patches = append(patches, patch{offset: u.element.StartAt, data: encoded})
+			changes = append(changes, parser.Change{
+				Operation: parser.WriteOperation,
+				Target:    fmt.Sprintf("%s element", u.name),
+				Offset:    u.element.StartAt,
+				Size:      regionSize,
+			})
+			continue
+		}
+
+		// Move the element to the end of the segment and update its position in the SeekHead
+		position := segmentEnd + int64(len(appended))
+		seekPosition := findSeekPosition(children, u.element.ElementID)
+		if hasClusters && (u.name == "Info" || seekPosition == nil) {
+			return nil, fmt.Errorf("not enough room to write %s element in place and it can't be moved after the Clusters", u.name)
+		}
+		if seekPosition != nil {
+			value := EncodeUint(uint64(position-segment.DataAt), int(seekPosition.Size))
+			if value == nil {
+				return nil, fmt.Errorf("new position of %s element doesn't fit in SeekHead", u.name)
+			}
+			patches = append(patches, patch{offset: seekPosition.DataAt, data: value})
+		}
+		patches = append(patches, patch{offset: u.element.StartAt, data: VoidElement(regionSize)})
+		appended = append(appended, EncodeElement(u.element.ElementID, u.data)...)
+		changes = append(changes, parser.Change{
+			Operation: parser.RewriteOperation,
+			Target:    fmt.Sprintf("%s element (replaced by Void element)", u.name),
+			Offset:    u.element.StartAt,
+			Size:      regionSize,
+		}, parser.Change{
+			Operation: parser.RewriteOperation,
+			Target:    fmt.Sprintf("%s element (moved to the end of segment)", u.name),
+			Offset:    position,
+			Size:      int64(len(appended)) - (position - segmentEnd),
+		})
+	}
+	if len(appended) > 0 {
+		if segmentEnd != size {
+			return nil, fmt.Errorf("not enough room to write metadata in place and segment doesn't end at the end of file")
+		}
+		segmentSize := EncodeVSize(segment.Size+uint64(len(appended)), sizeLength)
+		if segmentSize == nil {
+			return nil, fmt.Errorf("new segment size doesn't fit in %d bytes", sizeLength)
+		}
+		patches = append(patches, patch{offset: segment.StartAt + int64(len(segment.ElementID)), data: segmentSize})
+	}
+	if dryRun {
+		return changes, nil
+	}
+
+	if len(appended) == 0 {
+		for _, p := range patches {
+			_, err = w.WriteAt(p.data, p.offset)
+			if err != nil {
+				return nil, fmt.Errorf("error writing to file: %w", err)
+			}
+		}
+		return changes, nil
+	}
+	sort.Slice(patches, func(i, j int) bool { return patches[i].offset < patches[j].offset })
+	err = w.Replace(func(writer io.Writer) error {
+		offset := int64(0)
+		for _, p := range patches {
+			_, err := io.Copy(writer, io.NewSectionReader(r, offset, p.offset-offset))
+			if err != nil {
+				return err
+			}
+			_, err = writer.Write(p.data)
+			if err != nil {
+				return err
+			}
+			offset = p.offset + int64(len(p.data))
+		}
+		_, err := io.Copy(writer, io.NewSectionReader(r, offset, size-offset))
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(appended)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// fitElement encodes an element that fills exactly size bytes, adding a Void element or widening the size field to
+// use the remaining space.  It returns nil if the element doesn't fit.
+func fitElement(elementId []byte, data []byte, size int64) []byte {
+	encoded := EncodeElement(elementId, data)
+	remaining := size - int64(len(encoded))
+	if remaining == 0 {
+		return encoded
+	} else if remaining == 1 {
+		sizeLength := len(encoded) - len(elementId) - len(data)
+		if sizeLength < 8 {
+			return EncodeElementWithSizeLength(elementId, data, sizeLength+1)
+		}
+	} else if remaining > 1 {
+		return append(encoded, VoidElement(remaining)...)
+	}
+	return nil
+}
+
+// findSeekPosition returns the SeekPosition element of elementId in the SeekHead elements of the segment.
+func findSeekPosition(segmentElements []EBMLElement, elementId []byte) *EBMLElement {
+	for i := range segmentElements {
+		if !bytes.Equal(segmentElements[i].ElementID, []byte{0x11, 0x4D, 0x9B, 0x74}) {
+			continue
+		}
+		for _, seek := range segmentElements[i].GetElements() {
+			seekElements := seek.GetElements()
+			seekId := SearchEBMLElements([]byte{0x53, 0xAB}, seekElements)
+			if seekId == nil {
+				continue
+			}
+			if value, _ := seekId.GetBytes(); bytes.Equal(value, elementId) {
+				return SearchEBMLElements([]byte{0x53, 0xAC}, seekElements)
+			}
+		}
+	}
+	return nil
+}
+
+// setInfoValues returns the content of info with string values replaced by fields.
+func setInfoValues(info *EBMLElement, fields []parser.Field) ([]byte, error) {
+	values := make(map[string]*parser.Field)
+	for i, f := range fields {
+		name := strings.TrimPrefix(strings.ToLower(f.Key), "info.")
+		found := false
+		for _, v := range infoValues {
+			if strings.ToLower(v.name) == name && v.name != "DateUTC" {
+				values[string(v.elementId)] = &fields[i]
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unsupported Info element: %s", f.Key)
+		}
+	}
+	var result []byte
+	for _, e := range info.GetElements() {
+		if bytes.Equal(e.ElementID, crcElementId) {
+			return nil, fmt.Errorf("Info element protected by a CRC-32 element is not supported")
+		}
+		f, found := values[string(e.ElementID)]
+		if !found {
+			raw, err := e.Raw()
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, raw...)
+			continue
+		}
+		delete(values, string(e.ElementID))
+		if f.Value != "" {
+			result = append(result, EncodeElement(e.ElementID, []byte(f.Value))...)
+		}
+	}
+	for _, v := range infoValues {
+		if f, found := values[string(v.elementId)]; found && f.Value != "" {
+			result = append(result, EncodeElement(v.elementId, []byte(f.Value))...)
+		}
+	}
+	return result, nil
+}
+
+// setSimpleTags returns the content of tags with the values of SimpleTags replaced by fields.  Fields without a
+// matching SimpleTag are added as new Tags.
+func setSimpleTags(tags *EBMLElement, fields []parser.Field) ([]byte, error) {
+	found := make(map[int]bool)
+	var result []byte
+	for _, tag := range tags.GetElements() {
+		if bytes.Equal(tag.ElementID, crcElementId) {
+			return nil, fmt.Errorf("Tags element protected by a CRC-32 element is not supported")
+		}
+		if !bytes.Equal(tag.ElementID, []byte{0x73, 0x73}) {
+			raw, err := tag.Raw()
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, raw...)
+			continue
+		}
+		var tagData []byte
+		simpleTags := 0
+		removed := 0
+		for _, e := range tag.GetElements() {
+			if bytes.Equal(e.ElementID, crcElementId) {
+				return nil, fmt.Errorf("Tag element protected by a CRC-32 element is not supported")
+			}
+			if bytes.Equal(e.ElementID, []byte{0x67, 0xC8}) {
+				name := GetStringValue([]byte{0x45, 0xA3}, e.GetElements())
+				index := -1
+				for i, f := range fields {
+					if strings.EqualFold(f.Key, name) {
+						index = i
+					}
+				}
+				if index >= 0 {
+					found[index] = true
+					if fields[index].Value == "" {
+						removed += 1
+						continue
+					}
+					simpleTag, err := setTagString(&e, fields[index].Value)
+					if err != nil {
+						return nil, err
+					}
+					tagData = append(tagData, simpleTag...)
+					simpleTags += 1
+					continue
+				}
+				simpleTags += 1
+			}
+			raw, err := e.Raw()
+			if err != nil {
+				return nil, err
+			}
+			tagData = append(tagData, raw...)
+		}
+		if simpleTags > 0 || removed == 0 {
+			result = append(result, EncodeElement(tag.ElementID, tagData)...)
+		}
+	}
+	for i, f := range fields {
+		if found[i] || f.Value == "" {
+			continue
+		}
+		simpleTag := EncodeElement([]byte{0x45, 0xA3}, []byte(f.Key))
+		simpleTag = append(simpleTag, EncodeElement([]byte{0x44, 0x87}, []byte(f.Value))...)
+		tag := EncodeElement([]byte{0x63, 0xC0}, nil)
+		tag = append(tag, EncodeElement([]byte{0x67, 0xC8}, simpleTag)...)
+		result = append(result, EncodeElement([]byte{0x73, 0x73}, tag)...)
+	}
+	return result, nil
+}
+
+// setTagString returns simpleTag with its TagString replaced by value.
+func setTagString(simpleTag *EBMLElement, value string) ([]byte, error) {
+	var result []byte
+	written := false
+	for _, e := range simpleTag.GetElements() {
+		if bytes.Equal(e.ElementID, crcElementId) {
+			return nil, fmt.Errorf("SimpleTag element protected by a CRC-32 element is not supported")
+		}
+		if bytes.Equal(e.ElementID, []byte{0x44, 0x87}) {
+			if !written {
+				result = append(result, EncodeElement(e.ElementID, []byte(value))...)
+				written = true
+			}
+			continue
+		}
+		raw, err := e.Raw()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, raw...)
+	}
+	if !written {
+		result = append(result, EncodeElement([]byte{0x44, 0x87}, []byte(value))...)
+	}
+	return EncodeElement(simpleTag.ElementID, result), nil
+}
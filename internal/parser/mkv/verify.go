@@ -0,0 +1,77 @@
+package mkv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+)
+
+// masterElements are the elements whose children are checked by Verify.  Clusters are skipped because they only
+// contain media data.
+var masterElements = map[string]string{
+	"\x18\x53\x80\x67": "Segment",
+	"\x11\x4D\x9B\x74": "SeekHead",
+	"\x4D\xBB":         "Seek",
+	"\x15\x49\xA9\x66": "Info",
+	"\x16\x54\xAE\x6B": "Tracks",
+	"\xAE":             "TrackEntry",
+	"\x19\x41\xA4\x69": "Attachments",
+	"\x61\xA7":         "AttachedFile",
+	"\x12\x54\xC3\x67": "Tags",
+	"\x73\x73":         "Tag",
+	"\x63\xC0":         "Targets",
+	"\x67\xC8":         "SimpleTag",
+	"\x10\x43\xA7\x70": "Chapters",
+}
+
+// Verify checks that the file starts with an EBML header and that no element extends past its parent element or
+// the end of the file.
+func Verify(r io.ReaderAt, size int64) ([]parser.Problem, error) {
+	problems, err := verifyElements(r, 0, size, "file")
+	if err != nil {
+		return nil, err
+	}
+	header, _, err := NewEBMLElement(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header.ElementID, []byte{0x1A, 0x45, 0xDF, 0xA3}) {
+		problems = append([]parser.Problem{parser.NewError("EBML header", 0, "file doesn't start with an EBML header")}, problems...)
+	}
+	return problems, nil
+}
+
+func verifyElements(r io.ReaderAt, start int64, end int64, parent string) ([]parser.Problem, error) {
+	var problems []parser.Problem
+	for offset := start; offset < end; {
+		element, next, err := NewEBMLElement(r, offset)
+		if err != nil {
+			return nil, err
+		}
+		name, master := masterElements[string(element.ElementID)]
+		if !master {
+			name = fmt.Sprintf("0x%X", element.ElementID)
+		}
+		target := fmt.Sprintf("%s element", name)
+		if element.ElementID[0] == 0 || len(element.ElementID) > 4 {
+			problems = append(problems, parser.NewError(target, offset, "invalid element ID"))
+			break
+		}
+		if isUnknownSize(element) {
+			next = end
+		} else if next > end {
+			problems = append(problems, parser.NewError(target, offset, "element ends %d bytes past the end of %s", next-end, parent))
+			break
+		}
+		if master {
+			children, err := verifyElements(r, element.DataAt, next, name)
+			if err != nil {
+				return nil, err
+			}
+			problems = append(problems, children...)
+		}
+		offset = next
+	}
+	return problems, nil
+}
@@ -0,0 +1,43 @@
+package mp4
+
+import (
+	"io"
+	"jch-metadata/internal/parser"
+	"jch-metadata/internal/parser/shared"
+	"time"
+)
+
+// Audit returns the creation time of the movie header and the mdta entries that may leak personal data, such as
+// com.apple.quicktime.location.ISO6709.
+func Audit(r io.ReaderAt, size int64) ([]parser.Finding, error) {
+	boxes, err := GetBoxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	var result []parser.Finding
+	for _, b := range boxes {
+		if b.GetType() != "moov" {
+			continue
+		}
+		mvhd, ok := b.FindNestedBoxByType("mvhd").(MvhdBox)
+		if ok {
+			header, err := mvhd.GetHeader()
+			if err != nil {
+				return nil, err
+			}
+			if header.CreationTime.After(BaseTime) {
+				result = append(result, parser.Finding{Category: parser.TimestampCategory, Source: "mvhd creation time", Value: header.CreationTime.Format(time.RFC3339)})
+			}
+		}
+		break
+	}
+	metadata, err := ExportMetadata(r, size)
+	if err != nil {
+		return nil, err
+	}
+	findings, err := shared.AuditMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return append(result, findings...), nil
+}
@@ -3,19 +3,19 @@ package mp4
 import (
 	"encoding/binary"
 	"fmt"
-	"jch-metadata/internal/output"
-	"strings"
+	"jch-metadata/internal/document"
 	"time"
 )
 
-type PrintableBox interface {
+type ParsedBox interface {
 	GetType() string
 	GetData() ([]byte, error)
-	FindNestedBoxByType(boxType string) PrintableBox
-	Print()
+	Raw() ([]byte, error)
+	FindNestedBoxByType(boxType string) ParsedBox
+	Describe(parent *document.Section) error
 }
 
-func ConvertBox(box Box) PrintableBox {
+func ConvertBox(box Box) ParsedBox {
 	switch box.Type {
 	case "ftyp":
 		return FileTypeBox{&box}
@@ -67,52 +67,53 @@ func (b FileTypeBox) GetFileType() (*FileType, error) {
 	return &result, nil
 }
 
-func (b FileTypeBox) Print() {
+func (b FileTypeBox) Describe(parent *document.Section) error {
 	fileType, err := b.GetFileType()
 	if err != nil {
-		output.Printf(false, "Failed to read file type box: %s\n", err)
-		return
+		return fmt.Errorf("failed to read file type box: %w", err)
 	}
-	output.PrintHeader(false, "File Type")
-	output.PrintForm(false, "Brand", fileType.Brand, 35)
-	output.PrintForm(false, "Minor Version", fmt.Sprintf("%d", fileType.MinorVersion), 35)
-	output.PrintForm(false, "Compatible Brands", strings.Join(fileType.CompatibleBrands, ", "), 35)
-	output.Println(false)
+	section := parent.AddSection("ftyp", "File Type")
+	section.AddString("brand", "Brand", fileType.Brand)
+	section.AddUint("minorVersion", "Minor Version", uint64(fileType.MinorVersion))
+	section.AddList("compatibleBrands", "Compatible Brands", fileType.CompatibleBrands)
+	return nil
 }
 
 type MoovBox struct {
 	*Box
 }
 
-func (b MoovBox) Print() {
-	output.PrintHeader(false, "Movie Metadata (moov)")
-	output.Println(false)
-	boxes := b.GetBoxes()
-	for _, b := range boxes {
-		b.Print()
+func (b MoovBox) Describe(parent *document.Section) error {
+	section := parent.AddSection("moov", "Movie Metadata (moov)")
+	for _, box := range b.GetBoxes() {
+		err := box.Describe(section)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 type FileType struct {
-	Brand            string
-	MinorVersion     uint32
-	CompatibleBrands []string
+	Brand            string   `json:"brand"`
+	MinorVersion     uint32   `json:"minorVersion"`
+	CompatibleBrands []string `json:"compatibleBrands"`
 }
 
 type MvhdBox struct {
 	*Box
 }
 
-func (b MvhdBox) Print() {
-	output.Println(false, "Movie Header (mvhd)")
-	result, err := b.GetHeader()
+func (b MvhdBox) Describe(parent *document.Section) error {
+	header, err := b.GetHeader()
 	if err != nil {
-		output.Printf(false, "Error parsing movie header: %s\n", err)
+		return fmt.Errorf("error parsing movie header: %w", err)
 	}
-	output.PrintForm(false, "Creation Time", result.LocalCreationTime(), 20)
-	output.PrintForm(false, "Modification Time", result.LocalModificationTime(), 20)
-	output.PrintForm(false, "Duration (sec)", result.DurationString(), 20)
-	output.Println(false)
+	section := parent.AddSection("mvhd", "Movie Header (mvhd)")
+	section.AddTime("creationTime", "Creation Time", header.CreationTime)
+	section.AddTime("modificationTime", "Modification Time", header.ModificationTime)
+	section.AddString("duration", "Duration (sec)", header.DurationString())
+	return nil
 }
 
 func (b MvhdBox) GetHeader() (*Header, error) {
@@ -140,10 +141,10 @@ func (b MvhdBox) GetHeader() (*Header, error) {
 }
 
 type Header struct {
-	CreationTime     time.Time
-	ModificationTime time.Time
-	Timescale        uint32
-	Duration         uint64
+	CreationTime     time.Time `json:"creationTime"`
+	ModificationTime time.Time `json:"modificationTime"`
+	Timescale        uint32    `json:"timescale"`
+	Duration         uint64    `json:"duration"`
 }
 
 func (h Header) LocalCreationTime() string {
@@ -162,21 +163,29 @@ type UdtaBox struct {
 	*Box
 }
 
-func (b UdtaBox) Print() {
+func (b UdtaBox) GetEntries() ([][]byte, error) {
 	data, err := b.GetData()
 	if err != nil {
-		fmt.Printf("Error retrieving udta content: %s\n", err)
-		return
+		return nil, err
 	}
-	output.PrintHeader(false, "User data (udta)")
-	output.Println(false)
+	var result [][]byte
 	offset := 0
 	for offset < len(data) {
 		length := binary.BigEndian.Uint32(data[offset : offset+4])
-		dataValue := data[offset : offset+int(length)]
-		output.PrintHexDump(false, dataValue)
-		output.Println(false)
+		result = append(result, data[offset:offset+int(length)])
 		offset += int(length)
 	}
-	output.Println(false)
+	return result, nil
+}
+
+func (b UdtaBox) Describe(parent *document.Section) error {
+	entries, err := b.GetEntries()
+	if err != nil {
+		return fmt.Errorf("error retrieving udta content: %w", err)
+	}
+	section := parent.AddSection("udta", "User data (udta)")
+	for i, entry := range entries {
+		section.AddBinary(fmt.Sprintf("entry.%d", i), "", entry)
+	}
+	return nil
 }
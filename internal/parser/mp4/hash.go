@@ -0,0 +1,40 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+)
+
+// EssenceRanges returns the data of the top-level mdat boxes.  The sample tables in moov are skipped because their
+// chunk offsets change when metadata before mdat is resized.
+func EssenceRanges(r io.ReaderAt, size int64) ([]parser.Range, error) {
+	var result []parser.Range
+	header := make([]byte, 16)
+	for offset := int64(0); offset+8 <= size; {
+		n, err := r.ReadAt(header, offset)
+		if err != nil && (err != io.EOF || n < 8) {
+			return nil, fmt.Errorf("error reading box header: %w", err)
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerSize := int64(8)
+		if boxSize == 1 {
+			if n < 16 {
+				return nil, fmt.Errorf("box at offset %d is truncated", offset)
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		} else if boxSize == 0 {
+			boxSize = size - offset
+		}
+		if boxSize < headerSize || offset+boxSize > size {
+			return nil, fmt.Errorf("invalid size %d of box %s at offset %d", boxSize, header[4:8], offset)
+		}
+		if string(header[4:8]) == "mdat" {
+			result = append(result, parser.Range{Offset: offset + headerSize, Size: boxSize - headerSize})
+		}
+		offset += boxSize
+	}
+	return result, nil
+}
@@ -3,55 +3,45 @@ package mp4
 import (
 	"encoding/binary"
 	"fmt"
-	"jch-metadata/internal/output"
+	"io"
+	"jch-metadata/internal/document"
+	"jch-metadata/internal/parser"
+	"sort"
+	"strings"
 )
 
 type MetaBox struct {
 	*Box
 }
 
-func (b MetaBox) Print() {
-	output.Println(false, "Metadata (meta)")
+func (b MetaBox) Describe(parent *document.Section) error {
+	section := parent.AddSection("meta", "Metadata (meta)")
 	handlerBox := b.FindNestedBoxByType("hdlr")
 	if handlerBox == nil {
-		output.Printf(false, "Failed to retrieve handler for meta box\n")
-		return
+		section.AddString("handler", "Handler", "")
+		return nil
 	}
 	handler, err := handlerBox.(HandlerBox).GetHandler()
 	if err != nil {
-		output.Printf(false, "Failed to retrieve handler for meta box: %s\n", err)
-		return
+		return fmt.Errorf("failed to retrieve handler for meta box: %w", err)
 	}
-	if handler.Type == "mdta" {
-		mdta, err := b.GetMdta()
-		if err != nil {
-			output.Printf(false, "Failed to parse mdta: %s\n", err)
-		}
-		width := 30
-		for k := range mdta {
-			if len(k) > width {
-				width = len(k)
-			}
-		}
-		for k, v := range mdta {
-			output.PrintForm(false, k, v.String(), width)
-		}
-		output.Println(false)
-	} else {
-		output.Printf(false, "Skip parsing unsupported handler %s\n", handler.Type)
+	section.AddString("handler", "Handler", fmt.Sprintf("%s (%s)", handler.Type, handler.Name))
+	if handler.Type != "mdta" {
+		return nil
 	}
-
-	boxes := b.GetBoxes()
-	if boxes == nil {
-		output.Println(false, "Empty meta box")
-	} else {
-		for _, b := range boxes {
-			if b.GetType() != "mdta" && b.GetType() != "hdlr" && b.GetType() != "keys" && b.GetType() != "ilst" {
-				output.Printf(false, "Skip parsing box %s\n", b.GetType())
-			}
-		}
+	mdta, err := b.GetMdta()
+	if err != nil {
+		return fmt.Errorf("failed to parse mdta: %w", err)
+	}
+	keys := make([]string, 0, len(mdta))
+	for k := range mdta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		section.AddString(k, k, mdta[k].String())
 	}
-	output.Println(false)
+	return nil
 }
 
 func (b MetaBox) GetMdta() (map[string]KeyValue, error) {
@@ -74,9 +64,55 @@ func (b MetaBox) GetMdta() (map[string]KeyValue, error) {
 	return result, nil
 }
 
+// ExportMetadata returns the textual mdta entries of the moov.meta box.  The "com.apple.quicktime." prefix is removed
+// from the keys.
+func ExportMetadata(r io.ReaderAt, size int64) (*parser.Metadata, error) {
+	boxes, err := GetBoxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	result := parser.Metadata{}
+	for _, b := range boxes {
+		if b.GetType() != "moov" {
+			continue
+		}
+		metaBox := b.FindNestedBoxByType("meta")
+		if metaBox == nil {
+			break
+		}
+		handlerBox := metaBox.FindNestedBoxByType("hdlr")
+		if handlerBox == nil {
+			break
+		}
+		handler, err := handlerBox.(HandlerBox).GetHandler()
+		if err != nil {
+			return nil, err
+		}
+		if handler.Type != "mdta" {
+			break
+		}
+		keys, err := metaBox.(MetaBox).GetKeys()
+		if err != nil {
+			return nil, err
+		}
+		values, err := metaBox.(MetaBox).GetValues()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			if v.Type > 5 || v.Index < 1 || int(v.Index) > len(keys) {
+				continue
+			}
+			result.AddComment(strings.TrimPrefix(keys[v.Index-1], "com.apple.quicktime."), v.String())
+		}
+		break
+	}
+	return &result, nil
+}
+
 type Handler struct {
-	Type string
-	Name string
+	Type string `json:"type"`
+	Name string `json:"name"`
 }
 
 func (b MetaBox) GetKeys() ([]string, error) {
@@ -88,16 +124,27 @@ func (b MetaBox) GetKeys() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	entryCount := int(binary.BigEndian.Uint32(data[4:8]))
+	if len(data) < 8 {
+		return nil, fmt.Errorf("keys box is too small: %d bytes", len(data))
+	}
+	entryCount := int64(binary.BigEndian.Uint32(data[4:8]))
+	// Every key entry has a size and a namespace of 4 bytes each
+	if entryCount > int64(len(data)-8)/8 {
+		return nil, fmt.Errorf("invalid number of keys: %d", entryCount)
+	}
 	startOffset := 8
 	var result []string
-	for i := 1; i <= entryCount; i++ {
-		size := binary.BigEndian.Uint32(data[startOffset : startOffset+4])
-		start := startOffset + 8
-		end := start + int(size) - 8
-		value := string(data[start:end])
+	for i := int64(1); i <= entryCount; i++ {
+		if startOffset+8 > len(data) {
+			return nil, fmt.Errorf("key %d is outside of the keys box", i)
+		}
+		size := int(binary.BigEndian.Uint32(data[startOffset : startOffset+4]))
+		if size < 8 || size > len(data)-startOffset {
+			return nil, fmt.Errorf("invalid size of key %d: %d", i, size)
+		}
+		value := string(data[startOffset+8 : startOffset+size])
 		result = append(result, value)
-		startOffset += int(size)
+		startOffset += size
 	}
 	return result, nil
 }
@@ -113,19 +160,25 @@ func (b MetaBox) GetValues() ([]KeyValue, error) {
 	}
 	var result []KeyValue
 	for i := 0; i < len(data); {
-		size := binary.BigEndian.Uint32(data[i : i+4])
-		dataSize := binary.BigEndian.Uint32(data[i+8 : i+12])
+		if i+24 > len(data) {
+			return nil, fmt.Errorf("ilst item at offset %d is truncated", i)
+		}
+		size := int(binary.BigEndian.Uint32(data[i : i+4]))
+		dataSize := int(binary.BigEndian.Uint32(data[i+8 : i+12]))
 		if string(data[i+12:i+16]) != "data" {
 			return nil, fmt.Errorf("invalid data identifier: %s", string(data[i+12:i+16]))
 		}
+		if size < 24 || size > len(data)-i || dataSize < 16 || dataSize > size-8 {
+			return nil, fmt.Errorf("invalid size of ilst item at offset %d: %d", i, size)
+		}
 		keyValue := KeyValue{
 			Index:           binary.BigEndian.Uint32(data[i+4 : i+8]),
 			Type:            binary.BigEndian.Uint32(data[i+16 : i+20]),
 			LocaleIndicator: binary.BigEndian.Uint32(data[i+20 : i+24]),
-			Value:           data[i+24 : i+24+int(dataSize)-16],
+			Value:           data[i+24 : i+8+dataSize],
 		}
 		result = append(result, keyValue)
-		i += int(size)
+		i += size
 	}
 	return result, nil
 }
@@ -140,6 +193,8 @@ type KeyValue struct {
 func (k KeyValue) String() string {
 	if k.Type <= 5 {
 		return string(k.Value[:])
+	} else if len(k.Value) < 4 {
+		return fmt.Sprintf("% X", k.Value)
 	} else {
 		return fmt.Sprintf("%v", binary.BigEndian.Uint32(k.Value[:]))
 	}
@@ -160,11 +215,11 @@ func (b HandlerBox) GetHandler() (*Handler, error) {
 	}, nil
 }
 
-func (b HandlerBox) Print() {
+func (b HandlerBox) Describe(parent *document.Section) error {
 	handler, err := b.GetHandler()
 	if err != nil {
-		output.Printf(false, "Error retrieving handler data: %s\n", err)
-		return
+		return fmt.Errorf("error retrieving handler data: %w", err)
 	}
-	output.PrintForm(false, "Handler", fmt.Sprintf("%s (%s)", handler.Type, handler.Name), 20)
+	parent.AddString("handler", "Handler", fmt.Sprintf("%s (%s)", handler.Type, handler.Name))
+	return nil
 }
@@ -4,9 +4,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"jch-metadata/internal/output"
+	"jch-metadata/internal/document"
 	"jch-metadata/internal/parser"
-	"os"
+	"strings"
 	"time"
 )
 
@@ -15,43 +15,89 @@ var BaseTime = time.Date(1904, time.January, 1, 0, 0, 0, 0, time.UTC)
 var Parser = parser.Parser{
 	Name:      "MP4",
 	Container: true,
-	Support: func(file *os.File, startOffset int64, length int64) (bool, error) {
-		return IsMP4(file)
+	Support: func(r io.ReaderAt, size int64) (bool, error) {
+		return IsMP4(r)
 	},
-	Handle: func(file *os.File, action parser.Action, startOffset int64, length int64, parsers []parser.Parser) error {
-		if action == parser.ShowAction {
-			boxes, err := GetBoxes(file, startOffset, length)
+	Handle: func(input *parser.Input, action parser.Action, parsers []parser.Parser, options parser.Options) (*document.Document, error) {
+		if action == parser.VerifyAction {
+			problems, err := Verify(input.Reader, input.Size)
 			if err != nil {
-				return err
+				return nil, err
 			}
+			return parser.DescribeProblems(problems), nil
+		} else if action == parser.AuditAction {
+			findings, err := Audit(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeFindings(findings, options.Policy), nil
+		} else if action == parser.HashAction {
+			ranges, err := EssenceRanges(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeHash(input.Reader, ranges)
+		} else if action == parser.ShowAction {
+			boxes, err := GetBoxes(input.Reader, 0, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			doc := &document.Document{}
 			for _, box := range boxes {
-				box.Print()
+				err = box.Describe(&doc.Section)
+				if err != nil {
+					doc.AddMessage("Error describing box %s: %s", box.GetType(), err)
+				}
 			}
+			return doc, nil
 		} else if action == parser.ClearAction {
-			err := ClearMetadata(file, startOffset, length)
+			return ClearMetadata(input.Reader, input.Size, input.Writer, options.Selector, options.DryRun)
+		} else if action == parser.SetAction {
+			changes, err := SetMetadata(input.Reader, input.Size, input.Writer, options.Fields, options.DryRun)
 			if err != nil {
-				return err
+				return nil, err
+			}
+			if options.DryRun {
+				return parser.DescribeChanges(changes), nil
 			}
-			output.Println(false, "Metadata has been cleared!")
+			return document.Message("Metadata has been written!"), nil
+		} else if action == parser.CopyAction {
+			var changes []parser.Change
+			if len(options.Source.Comments) > 0 {
+				var fields []parser.Field
+				for _, c := range options.Source.Comments {
+					fields = append(fields, parser.Field{Key: strings.ToLower(c.Key), Value: c.Value})
+				}
+				var err error
+				changes, err = SetMetadata(input.Reader, input.Size, input.Writer, fields, options.DryRun)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return parser.DescribeCopy(options.Source, []string{parser.CommentsKind}, changes, options.DryRun), nil
 		}
-		return nil
+		return document.Message("Unsupported action: %s", action), nil
+	},
+	Export: func(input *parser.Input) (*parser.Metadata, error) {
+		return ExportMetadata(input.Reader, input.Size)
 	},
 }
 
-func IsMP4(file *os.File) (bool, error) {
+func IsMP4(r io.ReaderAt) (bool, error) {
 	magicBytes := make([]byte, 4)
-	_, err := file.ReadAt(magicBytes, 4)
+	_, err := r.ReadAt(magicBytes, 4)
 	if err != nil {
 		return false, fmt.Errorf("failed to read file: %w", err)
 	}
 	return string(magicBytes[:]) == "ftyp", nil
 }
 
-func ClearMetadata(file *os.File, offset int64, length int64) error {
-	output.Println(false, "Turning moov.meta box into free space...")
-	boxes, err := GetBoxes(file, offset, length)
+// ClearMetadata turns the moov.meta box ("mp4.meta") and, if explicitly selected, the moov.udta box ("mp4.udta")
+// into free boxes.  If dryRun is true, the changes are returned without writing them.
+func ClearMetadata(r io.ReaderAt, size int64, w io.WriterAt, selector parser.Selector, dryRun bool) (*document.Document, error) {
+	boxes, err := GetBoxes(r, 0, size)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	var moovBox MoovBox
 	for _, b := range boxes {
@@ -60,37 +106,62 @@ func ClearMetadata(file *os.File, offset int64, length int64) error {
 			break
 		}
 	}
-	if moovBox.Size == 0 {
-		output.Println(false, "Can't find moov box!")
-		return nil
+	if moovBox.Box == nil || moovBox.Size == 0 {
+		return document.Message("Can't find moov box!"), nil
 	}
-	meta := moovBox.FindNestedBoxByType("meta")
-	if meta == nil {
-		output.Println(false, "Can't find meta box!")
-		return nil
+	var targets []*Box
+	if selector.Removes("mp4.meta") {
+		meta := moovBox.FindNestedBoxByType("meta")
+		if meta == nil {
+			return document.Message("Can't find meta box!"), nil
+		}
+		targets = append(targets, meta.(MetaBox).Box)
 	}
-	metaOffset, metaData, err := meta.(MetaBox).ToFreeBox()
-	if err != nil {
-		return err
+	if selector.Selects("mp4.udta") {
+		if udta, ok := moovBox.FindNestedBoxByType("udta").(UdtaBox); ok {
+			targets = append(targets, udta.Box)
+		}
 	}
-	_, err = file.WriteAt(metaData, metaOffset)
-	if err != nil {
-		return fmt.Errorf("error writing changes to file: %w", err)
+	if dryRun {
+		var changes []parser.Change
+		for _, b := range targets {
+			changes = append(changes, parser.Change{
+				Operation: parser.ZeroOperation,
+				Target:    fmt.Sprintf("moov.%s box (turned into free box)", b.Type),
+				Offset:    b.StartOffset,
+				Size:      int64(b.Size),
+			})
+		}
+		return parser.DescribeChanges(changes), nil
 	}
-	return nil
+	if len(targets) == 0 {
+		return document.Message("Nothing to clear"), nil
+	}
+	doc := &document.Document{}
+	for _, b := range targets {
+		offset, data, err := b.ToFreeBox()
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.WriteAt(data, offset)
+		if err != nil {
+			return nil, fmt.Errorf("error writing changes to file: %w", err)
+		}
+		doc.AddMessage("moov.%s box has been turned into free space!", b.Type)
+	}
+	doc.AddMessage("Metadata has been cleared!")
+	return doc, nil
 }
 
-func GetBoxes(file *os.File, startOffset int64, length int64) ([]PrintableBox, error) {
-	var result []PrintableBox
-	stat, _ := file.Stat()
-	fileSize := stat.Size()
+func GetBoxes(r io.ReaderAt, startOffset int64, length int64) ([]ParsedBox, error) {
+	var result []ParsedBox
 	for i := startOffset; ; {
 		box := Box{
 			StartOffset: i,
-			File:        file,
+			Reader:      r,
 		}
 		header := make([]byte, 8)
-		_, err := file.ReadAt(header, i)
+		_, err := r.ReadAt(header, i)
 		if err == io.EOF {
 			break
 		}
@@ -102,7 +173,7 @@ func GetBoxes(file *os.File, startOffset int64, length int64) ([]PrintableBox, e
 		}
 		i += 8
 		box.Size = uint64(binary.BigEndian.Uint32(header[0:4]))
-		if int64(box.Size) >= fileSize {
+		if int64(box.Size) >= startOffset+length {
 			return nil, fmt.Errorf("invalid box size: %d", box.Size)
 		}
 		box.Type = string(header[4:8])
@@ -113,7 +184,7 @@ func GetBoxes(file *os.File, startOffset int64, length int64) ([]PrintableBox, e
 		}
 		if box.Size == 1 {
 			largeSize := make([]byte, 8)
-			_, err := file.ReadAt(largeSize, i)
+			_, err := r.ReadAt(largeSize, i)
 			if err != nil {
 				return nil, fmt.Errorf("error reading box large size: %w", err)
 			}
@@ -121,7 +192,7 @@ func GetBoxes(file *os.File, startOffset int64, length int64) ([]PrintableBox, e
 			box.Size = binary.BigEndian.Uint64(largeSize)
 		} else if box.Type == "uuid" {
 			userType := make([]byte, 16)
-			_, err := file.ReadAt(userType, i)
+			_, err := r.ReadAt(userType, i)
 			if err != nil {
 				return nil, fmt.Errorf("error reading user type uuid: %w", err)
 			}
@@ -144,31 +215,41 @@ type Box struct {
 	StartOffset int64
 	StartData   int64
 	Size        uint64
-	File        *os.File
+	Reader      io.ReaderAt
 }
 
 func (b Box) GetType() string {
 	return b.Type
 }
 
+// Raw returns the content of the box including its header.
+func (b Box) Raw() ([]byte, error) {
+	data := make([]byte, b.Size)
+	_, err := b.Reader.ReadAt(data, b.StartOffset)
+	if err != nil {
+		return nil, fmt.Errorf("error reading box %s: %w", b.Type, err)
+	}
+	return data, nil
+}
+
 func (b Box) GetData() ([]byte, error) {
 	data := make([]byte, b.Size-uint64(b.StartData-b.StartOffset))
-	_, err := b.File.ReadAt(data, b.StartData)
+	_, err := b.Reader.ReadAt(data, b.StartData)
 	if err != nil && err != io.EOF {
 		return nil, fmt.Errorf("error reading box %s data: %w", b.Type, err)
 	}
 	return data, nil
 }
 
-func (b Box) GetBoxes() []PrintableBox {
-	boxes, err := GetBoxes(b.File, b.StartData, int64(b.Size))
+func (b Box) GetBoxes() []ParsedBox {
+	boxes, err := GetBoxes(b.Reader, b.StartData, int64(b.Size))
 	if err != nil {
 		return nil
 	}
 	return boxes
 }
 
-func (b Box) FindNestedBoxByType(boxType string) PrintableBox {
+func (b Box) FindNestedBoxByType(boxType string) ParsedBox {
 	boxes := b.GetBoxes()
 	if boxes == nil {
 		return nil
@@ -181,20 +262,21 @@ func (b Box) FindNestedBoxByType(boxType string) PrintableBox {
 	return nil
 }
 
-func (b Box) Print() {
-	output.Printf(false, "Skip parsing box type %s\n", b.Type)
-	boxes := b.GetBoxes()
-	if boxes != nil {
-		for _, b := range boxes {
-			b.Print()
+func (b Box) Describe(parent *document.Section) error {
+	section := parent.AddSection(b.Type, fmt.Sprintf("Box (%s)", b.Type))
+	section.AddUint("size", "Size", b.Size)
+	for _, box := range b.GetBoxes() {
+		err := box.Describe(section)
+		if err != nil {
+			return err
 		}
 	}
-	output.Println(false)
+	return nil
 }
 
 func (b Box) ToFreeBox() (int64, []byte, error) {
 	data := make([]byte, b.Size)
-	_, err := b.File.ReadAt(data, b.StartOffset)
+	_, err := b.Reader.ReadAt(data, b.StartOffset)
 	if err != nil {
 		return 0, nil, fmt.Errorf("error reading data: %w", err)
 	}
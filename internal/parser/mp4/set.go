@@ -0,0 +1,322 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+	"strings"
+)
+
+// SetMetadata writes fields as mdta entries of the moov.meta box, creating the box if it doesn't exist.  Keys without
+// a dot are prefixed with "com.apple.quicktime.", so "title" sets "com.apple.quicktime.title".  An empty value
+// removes the entry.
+//
+// The meta box is written in place if it fits in the space of the current box and the free boxes that follow it.
+// Otherwise, the moov box is rewritten and the chunk offsets of the tracks are updated if the media data follows the
+// moov box.  If dryRun is true, the changes are returned without writing them.
+func SetMetadata(r io.ReaderAt, size int64, w parser.Writer, fields []parser.Field, dryRun bool) ([]parser.Change, error) {
+	boxes, err := GetBoxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	var moovBox MoovBox
+	for _, b := range boxes {
+		if b.GetType() == "moov" {
+			moovBox = b.(MoovBox)
+			break
+		}
+	}
+	if moovBox.Box == nil || moovBox.Size == 0 {
+		return nil, fmt.Errorf("can't find moov box")
+	}
+
+	children := moovBox.GetBoxes()
+	metaIndex := -1
+	for i, b := range children {
+		if b.GetType() == "meta" {
+			metaIndex = i
+			break
+		}
+	}
+	var metaBox *MetaBox
+	if metaIndex >= 0 {
+		m := children[metaIndex].(MetaBox)
+		metaBox = &m
+	}
+	meta, err := encodeMeta(metaBox, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	if metaBox != nil {
+		regionSize := int64(metaBox.Size)
+		for _, b := range children[metaIndex+1:] {
+			if b.GetType() != "free" && b.GetType() != "skip" {
+				break
+			}
+			raw, err := b.Raw()
+			if err != nil {
+				return nil, err
+			}
+			regionSize += int64(len(raw))
+		}
+		remaining := regionSize - int64(len(meta))
+		if remaining == 0 || remaining >= 8 {
+			if remaining > 0 {
+				meta = append(meta, encodeBox("free", make([]byte, remaining-8))...)
+			}
+			changes := []parser.Change{{
+				Operation: parser.WriteOperation,
+				Target:    "moov.meta box",
+				Offset:    metaBox.StartOffset,
+				Size:      regionSize,
+			}}
+			if dryRun {
+				return changes, nil
+			}
+			_, err = w.WriteAt(meta, metaBox.StartOffset)
+			if err != nil {
+				return nil, fmt.Errorf("error writing changes to file: %w", err)
+			}
+			return changes, nil
+		}
+	}
+
+	// Rewrite the moov box with the new meta box
+	if moovBox.StartData-moovBox.StartOffset != 8 {
+		return nil, fmt.Errorf("rewriting moov box with large size is not supported")
+	}
+	var moov []byte
+	for i, b := range children {
+		if i == metaIndex {
+			moov = append(moov, meta...)
+			continue
+		}
+		raw, err := b.Raw()
+		if err != nil {
+			return nil, err
+		}
+		moov = append(moov, raw...)
+	}
+	if metaIndex < 0 {
+		moov = append(moov, meta...)
+	}
+	if len(moov)+8 > 0xFFFFFFFF {
+		return nil, fmt.Errorf("moov box is too large")
+	}
+	moovEnd := moovBox.StartOffset + int64(moovBox.Size)
+	delta := int64(len(moov)+8) - int64(moovBox.Size)
+	err = adjustChunkOffsets(moov, uint64(moovEnd), delta)
+	if err != nil {
+		return nil, err
+	}
+	moov = encodeBox("moov", moov)
+	changes := []parser.Change{{
+		Operation: parser.RewriteOperation,
+		Target:    fmt.Sprintf("moov box (media data moved by %d bytes)", delta),
+		Offset:    moovBox.StartOffset,
+		Size:      int64(len(moov)),
+	}}
+	if dryRun {
+		return changes, nil
+	}
+	err = w.Replace(func(writer io.Writer) error {
+		_, err := io.Copy(writer, io.NewSectionReader(r, 0, moovBox.StartOffset))
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(moov)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(writer, io.NewSectionReader(r, moovEnd, size-moovEnd))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// encodeMeta returns a meta box with the mdta entries of metaBox updated by fields.  A new meta box is created if
+// metaBox is nil.
+func encodeMeta(metaBox *MetaBox, fields []parser.Field) ([]byte, error) {
+	var keys []string
+	var items [][]byte
+	var children []ParsedBox
+	if metaBox != nil {
+		handlerBox := metaBox.FindNestedBoxByType("hdlr")
+		if handlerBox == nil {
+			return nil, fmt.Errorf("handler for meta box not found")
+		}
+		handler, err := handlerBox.(HandlerBox).GetHandler()
+		if err != nil {
+			return nil, err
+		}
+		if handler.Type != "mdta" {
+			return nil, fmt.Errorf("unsupported meta box handler: %s", handler.Type)
+		}
+		children = metaBox.GetBoxes()
+		if metaBox.FindNestedBoxByType("keys") != nil {
+			keys, err = metaBox.GetKeys()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if ilst := metaBox.FindNestedBoxByType("ilst"); ilst != nil {
+			data, err := ilst.GetData()
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i+8 <= len(data); {
+				itemSize := int(binary.BigEndian.Uint32(data[i : i+4]))
+				if itemSize < 8 || i+itemSize > len(data) {
+					return nil, fmt.Errorf("invalid ilst item size: %d", itemSize)
+				}
+				items = append(items, data[i:i+itemSize])
+				i += itemSize
+			}
+		}
+	}
+
+	for _, f := range fields {
+		key := f.Key
+		if !strings.Contains(key, ".") {
+			key = "com.apple.quicktime." + key
+		}
+		index := 0
+		for i, k := range keys {
+			if k == key {
+				index = i + 1
+			}
+		}
+		if index == 0 {
+			if f.Value == "" {
+				continue
+			}
+			keys = append(keys, key)
+			index = len(keys)
+		}
+		var result [][]byte
+		for _, item := range items {
+			if binary.BigEndian.Uint32(item[4:8]) != uint32(index) {
+				result = append(result, item)
+			}
+		}
+		if f.Value != "" {
+			value := binary.BigEndian.AppendUint32(nil, 1)
+			value = binary.BigEndian.AppendUint32(value, 0)
+			value = append(value, f.Value...)
+			dataBox := encodeBox("data", value)
+			item := binary.BigEndian.AppendUint32(nil, uint32(len(dataBox)+8))
+			item = binary.BigEndian.AppendUint32(item, uint32(index))
+			result = append(result, append(item, dataBox...))
+		}
+		items = result
+	}
+
+	keysData := binary.BigEndian.AppendUint32(nil, 0)
+	keysData = binary.BigEndian.AppendUint32(keysData, uint32(len(keys)))
+	for _, k := range keys {
+		keysData = append(keysData, encodeBox("mdta", []byte(k))...)
+	}
+	var ilstData []byte
+	for _, item := range items {
+		ilstData = append(ilstData, item...)
+	}
+
+	var meta []byte
+	if metaBox == nil {
+		handler := make([]byte, 25)
+		copy(handler[8:12], "mdta")
+		meta = append(meta, encodeBox("hdlr", handler)...)
+	}
+	writtenKeys, writtenItems := false, false
+	for _, b := range children {
+		switch b.GetType() {
+		case "keys":
+			meta = append(meta, encodeBox("keys", keysData)...)
+			writtenKeys = true
+		case "ilst":
+			if !writtenKeys {
+				meta = append(meta, encodeBox("keys", keysData)...)
+				writtenKeys = true
+			}
+			meta = append(meta, encodeBox("ilst", ilstData)...)
+			writtenItems = true
+		default:
+			raw, err := b.Raw()
+			if err != nil {
+				return nil, err
+			}
+			meta = append(meta, raw...)
+		}
+	}
+	if !writtenKeys {
+		meta = append(meta, encodeBox("keys", keysData)...)
+	}
+	if !writtenItems {
+		meta = append(meta, encodeBox("ilst", ilstData)...)
+	}
+	return encodeBox("meta", meta), nil
+}
+
+func encodeBox(boxType string, data []byte) []byte {
+	result := binary.BigEndian.AppendUint32(nil, uint32(len(data)+8))
+	result = append(result, boxType...)
+	return append(result, data...)
+}
+
+// adjustChunkOffsets adds delta to the chunk offsets in the stco and co64 boxes of data, the content of a moov box,
+// that point at or after offset.
+func adjustChunkOffsets(data []byte, offset uint64, delta int64) error {
+	for i := 0; i+8 <= len(data); {
+		boxSize := int(binary.BigEndian.Uint32(data[i : i+4]))
+		if boxSize < 8 || i+boxSize > len(data) {
+			return fmt.Errorf("invalid box size: %d", boxSize)
+		}
+		content := data[i+8 : i+boxSize]
+		boxType := string(data[i+4 : i+8])
+		if (boxType == "stco" || boxType == "co64") && len(content) < 8 {
+			return fmt.Errorf("invalid %s box size: %d", boxType, boxSize)
+		}
+		switch boxType {
+		case "trak", "mdia", "minf", "stbl":
+			err := adjustChunkOffsets(content, offset, delta)
+			if err != nil {
+				return err
+			}
+		case "stco":
+			count := int(binary.BigEndian.Uint32(content[4:8]))
+			if 8+count*4 > len(content) {
+				return fmt.Errorf("invalid stco entry count: %d", count)
+			}
+			for j := 0; j < count; j++ {
+				entry := content[8+j*4 : 12+j*4]
+				value := uint64(binary.BigEndian.Uint32(entry))
+				if value >= offset {
+					value = uint64(int64(value) + delta)
+					if value > 0xFFFFFFFF {
+						return fmt.Errorf("chunk offset doesn't fit in stco box")
+					}
+					binary.BigEndian.PutUint32(entry, uint32(value))
+				}
+			}
+		case "co64":
+			count := int(binary.BigEndian.Uint32(content[4:8]))
+			if 8+count*8 > len(content) {
+				return fmt.Errorf("invalid co64 entry count: %d", count)
+			}
+			for j := 0; j < count; j++ {
+				entry := content[8+j*8 : 16+j*8]
+				value := binary.BigEndian.Uint64(entry)
+				if value >= offset {
+					binary.BigEndian.PutUint64(entry, uint64(int64(value)+delta))
+				}
+			}
+		}
+		i += boxSize
+	}
+	return nil
+}
@@ -3,33 +3,40 @@ package mp4
 import (
 	"encoding/binary"
 	"fmt"
-	"jch-metadata/internal/output"
+	"jch-metadata/internal/document"
 )
 
 type TrakBox struct {
 	*Box
 }
 
-func (b TrakBox) Print() {
-	output.PrintHeader(false, "Track (trak)")
+func (b TrakBox) Describe(parent *document.Section) error {
+	section := parent.AddSection("trak", "Track (trak)")
 	for _, box := range b.GetBoxes() {
 		if box.GetType() == "edts" {
 			continue
 		}
-		box.Print()
+		err := box.Describe(section)
+		if err != nil {
+			return err
+		}
 	}
-	output.Println(false)
+	return nil
 }
 
 type TrackHeaderBox struct {
 	*Box
 }
 
-func (b TrackHeaderBox) Print() {
+type TrackHeader struct {
+	TrackID uint32 `json:"trackId"`
+	Flag    string `json:"flag"`
+}
+
+func (b TrackHeaderBox) GetTrackHeader() (*TrackHeader, error) {
 	data, err := b.GetData()
 	if err != nil {
-		output.Printf(false, "Error retrieving track header: %s\n", err)
-		return
+		return nil, err
 	}
 	var trackId uint32
 	if data[0] == 1 {
@@ -49,33 +56,49 @@ func (b TrackHeaderBox) Print() {
 	if data[3]&0x4 == 1 {
 		flag += ", Preview"
 	}
-	output.PrintForm(false, "Track ID", fmt.Sprintf("%d", trackId), 20)
-	output.PrintForm(false, "Flag", flag, 20)
+	return &TrackHeader{
+		TrackID: trackId,
+		Flag:    flag,
+	}, nil
+}
+
+func (b TrackHeaderBox) Describe(parent *document.Section) error {
+	header, err := b.GetTrackHeader()
+	if err != nil {
+		return fmt.Errorf("error retrieving track header: %w", err)
+	}
+	parent.AddUint("trackId", "Track ID", uint64(header.TrackID))
+	parent.AddString("flag", "Flag", header.Flag)
+	return nil
 }
 
 type TrackMediaBox struct {
 	*Box
 }
 
-func (b TrackMediaBox) Print() {
+func (b TrackMediaBox) Describe(parent *document.Section) error {
 	for _, box := range b.GetBoxes() {
-		box.Print()
+		err := box.Describe(parent)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 type TrackMediaHeader struct {
 	MvhdBox
 }
 
-func (b TrackMediaHeader) Print() {
+func (b TrackMediaHeader) Describe(parent *document.Section) error {
 	header, err := b.GetHeader()
 	if err != nil {
-		output.Printf(false, "Error retrieving media header: %s\n", err)
-		return
+		return fmt.Errorf("error retrieving media header: %w", err)
 	}
-	output.PrintForm(false, "Creation Time", header.LocalCreationTime(), 20)
-	output.PrintForm(false, "Modification Time", header.LocalModificationTime(), 20)
-	output.PrintForm(false, "Duration", header.DurationString(), 20)
+	parent.AddTime("creationTime", "Creation Time", header.CreationTime)
+	parent.AddTime("modificationTime", "Modification Time", header.ModificationTime)
+	parent.AddString("duration", "Duration", header.DurationString())
+	return nil
 }
 
 type Media struct {
@@ -87,38 +110,60 @@ type MediaInformationBox struct {
 	*Box
 }
 
-func (b MediaInformationBox) Print() {
+func (b MediaInformationBox) Describe(parent *document.Section) error {
 	for _, box := range b.GetBoxes() {
 		if box.GetType() == "nmhd" || box.GetType() == "stbl" || box.GetType() == "smhd" || box.GetType() == "vmhd" {
 			continue
 		}
-		box.Print()
+		err := box.Describe(parent)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 type DataInformationBox struct {
 	*Box
 }
 
-func (b DataInformationBox) Print() {
+func (b DataInformationBox) GetRefs() ([]string, error) {
+	var result []string
 	for _, box := range b.GetBoxes() {
+		if box.GetType() != "dref" {
+			continue
+		}
 		data, err := box.GetData()
 		if err != nil {
-			output.Printf(false, "Error retrieving data information: %s", err)
+			return nil, err
 		}
-		if box.GetType() == "dref" {
-			numberOfEntries := binary.BigEndian.Uint32(data[4:8])
-			offset := 8
-			for i := 0; i < int(numberOfEntries); i++ {
-				size := binary.BigEndian.Uint32(data[offset : offset+4])
-				refType := string(data[offset+4 : offset+8])
-				value := string(data[offset+12 : offset+int(size)])
-				output.PrintForm(false, "Ref", fmt.Sprintf("%s %s", refType, value), 20)
-				offset += int(size)
-			}
-		} else {
-			box.Print()
+		numberOfEntries := binary.BigEndian.Uint32(data[4:8])
+		offset := 8
+		for i := 0; i < int(numberOfEntries); i++ {
+			size := binary.BigEndian.Uint32(data[offset : offset+4])
+			refType := string(data[offset+4 : offset+8])
+			value := string(data[offset+12 : offset+int(size)])
+			result = append(result, fmt.Sprintf("%s %s", refType, value))
+			offset += int(size)
 		}
+	}
+	return result, nil
+}
 
+func (b DataInformationBox) Describe(parent *document.Section) error {
+	refs, err := b.GetRefs()
+	if err != nil {
+		return fmt.Errorf("error retrieving data information: %w", err)
+	}
+	parent.AddList("refs", "Refs", refs)
+	for _, box := range b.GetBoxes() {
+		if box.GetType() == "dref" {
+			continue
+		}
+		err = box.Describe(parent)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -0,0 +1,105 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+)
+
+// containerBoxes are the boxes whose content is a list of boxes.
+var containerBoxes = map[string]bool{
+	"moov": true,
+	"trak": true,
+	"mdia": true,
+	"minf": true,
+	"stbl": true,
+	"udta": true,
+	"edts": true,
+	"dinf": true,
+	"mvex": true,
+	"moof": true,
+	"traf": true,
+	"meta": true,
+}
+
+// Verify checks that the size of every box fits in its parent box or in the file.
+func Verify(r io.ReaderAt, size int64) ([]parser.Problem, error) {
+	return verifyBoxes(r, 0, size, "")
+}
+
+func verifyBoxes(r io.ReaderAt, start int64, end int64, parent string) ([]parser.Problem, error) {
+	var problems []parser.Problem
+	container := "file"
+	if parent != "" {
+		container = parent + " box"
+	}
+	for offset := start; offset < end; {
+		if offset+8 > end {
+			// QuickTime allows a 32-bit zero terminator at the end of some containers
+			problems = append(problems, parser.NewWarning(container, offset, "%d bytes after the last box", end-offset))
+			break
+		}
+		header := make([]byte, 16)
+		n, err := r.ReadAt(header, offset)
+		if err != nil && (err != io.EOF || n < 8) {
+			return nil, fmt.Errorf("error reading box header: %w", err)
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		boxType := string(header[4:8])
+		path := boxType
+		if parent != "" {
+			path = parent + "." + boxType
+		}
+		target := fmt.Sprintf("%s box", path)
+		headerSize := int64(8)
+		if boxSize == 1 {
+			if offset+16 > end {
+				problems = append(problems, parser.NewError(target, offset, "large size overflows %s", container))
+				break
+			}
+			largeSize := binary.BigEndian.Uint64(header[8:16])
+			if largeSize > 1<<62 {
+				problems = append(problems, parser.NewError(target, offset, "box size %d overflows %s", largeSize, container))
+				break
+			}
+			boxSize = int64(largeSize)
+			headerSize = 16
+		} else if boxSize == 0 {
+			// The last box extends to the end of the file
+			if parent != "" {
+				problems = append(problems, parser.NewWarning(target, offset, "box size 0 is only allowed for top-level boxes"))
+			}
+			boxSize = end - offset
+		}
+		if boxSize < headerSize {
+			problems = append(problems, parser.NewError(target, offset, "invalid box size %d", boxSize))
+			break
+		}
+		if offset+boxSize > end {
+			problems = append(problems, parser.NewError(target, offset, "box size %d overflows %s by %d bytes", boxSize, container, offset+boxSize-end))
+			break
+		}
+		if containerBoxes[boxType] {
+			dataAt := offset + headerSize
+			if boxType == "meta" && boxSize >= headerSize+12 {
+				// The ISO meta box has version and flags before its children, the QuickTime meta box doesn't
+				child := make([]byte, 4)
+				_, err = r.ReadAt(child, dataAt+4)
+				if err != nil {
+					return nil, fmt.Errorf("error reading box header: %w", err)
+				}
+				if string(child) != "hdlr" {
+					dataAt += 4
+				}
+			}
+			children, err := verifyBoxes(r, dataAt, offset+boxSize, path)
+			if err != nil {
+				return nil, err
+			}
+			problems = append(problems, children...)
+		}
+		offset += boxSize
+	}
+	return problems, nil
+}
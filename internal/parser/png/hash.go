@@ -0,0 +1,22 @@
+package png
+
+import (
+	"io"
+	"jch-metadata/internal/parser"
+)
+
+// EssenceRanges returns the data of the IDAT chunks.  Their length and CRC are skipped, so splitting the image data
+// into chunks of a different size doesn't change the hash.
+func EssenceRanges(r io.ReaderAt, size int64) ([]parser.Range, error) {
+	chunks, err := GetChunks(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var result []parser.Range
+	for _, c := range chunks {
+		if string(c.ChunkType) == "IDAT" {
+			result = append(result, parser.Range{Offset: c.StartAt + 8, Size: int64(c.Length)})
+		}
+	}
+	return result, nil
+}
@@ -2,75 +2,145 @@ package png
 
 import (
 	"bytes"
+	"compress/zlib"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
-	"jch-metadata/internal/output"
+	"jch-metadata/internal/document"
 	"jch-metadata/internal/parser"
-	"os"
+	"jch-metadata/internal/parser/shared"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 var Parser = parser.Parser{
 	Name:      "PNG",
 	Container: false,
-	Support: func(file *os.File, startOffset int64, length int64) (bool, error) {
-		return IsPNG(file, startOffset)
+	Support: func(r io.ReaderAt, size int64) (bool, error) {
+		return IsPNG(r)
 	},
-	Handle: func(file *os.File, action parser.Action, startOffset int64, length int64, parsers []parser.Parser) error {
+	Handle: func(input *parser.Input, action parser.Action, parsers []parser.Parser, options parser.Options) (*document.Document, error) {
 		if action == parser.ShowAction {
-			textData, err := GetTextData(file, startOffset, length)
+			textData, err := GetTextData(input.Reader, input.Size)
 			if err != nil {
-				return err
+				return nil, err
+			}
+			metadata := Metadata{TextData: textData}
+			return metadata.Describe(), nil
+		} else if action == parser.ClearAction {
+			textData, err := GetTextData(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
 			}
 			if len(textData) == 0 {
-				output.Println(startOffset > 0, "Textual data not found!")
-				return nil
+				return document.Message("There is no textual data to remove!"), nil
 			}
-			width := 13
-			for k := range textData {
-				if len(k) > width {
-					width = len(k)
+			if !options.Selector.Removes("text", "comments", "png.text") {
+				if options.DryRun {
+					return parser.DescribeChanges(nil), nil
 				}
+				return document.Message("Textual data has been kept!"), nil
 			}
-			for k, v := range textData {
-				output.PrintForm(startOffset > 0, k, v, width)
+			if options.DryRun {
+				chunks, err := GetChunks(input.Reader, input.Size)
+				if err != nil {
+					return nil, err
+				}
+				var changes []parser.Change
+				for _, c := range chunks {
+					if c.IsText() {
+						changes = append(changes, parser.Change{
+							Operation: parser.RemoveOperation,
+							Target:    fmt.Sprintf("%s chunk", c.ChunkType),
+							Offset:    c.StartAt,
+							Size:      int64(c.Length) + 12,
+						})
+					}
+				}
+				return parser.DescribeChanges(changes), nil
 			}
-		} else if action == parser.ClearAction {
-			textData, err := GetTextData(file, startOffset, length)
+			err = RemoveTextData(input.Reader, input.Size, input.Writer)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			if len(textData) == 0 {
-				fmt.Println("There is no textual data to remove!")
-				return nil
+			return document.Message("Textual data has been removed!"), nil
+		} else if action == parser.SetAction {
+			changes, err := SetTextData(input.Reader, input.Size, input.Writer, options.Fields, options.DryRun)
+			if err != nil {
+				return nil, err
+			}
+			if options.DryRun {
+				return parser.DescribeChanges(changes), nil
+			}
+			return document.Message("Textual data has been written!"), nil
+		} else if action == parser.CopyAction {
+			var fields []parser.Field
+			for _, c := range options.Source.Comments {
+				fields = append(fields, parser.Field{Key: TextKeyword(c.Key), Value: c.Value})
 			}
-			err = RemoveTextData(file)
+			if len(options.Source.XMP) > 0 {
+				fields = append(fields, parser.Field{Key: xmpKeyword, Value: string(options.Source.XMP)})
+			}
+			var changes []parser.Change
+			if len(fields) > 0 {
+				var err error
+				changes, err = SetTextData(input.Reader, input.Size, input.Writer, fields, options.DryRun)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return parser.DescribeCopy(options.Source, []string{parser.CommentsKind, parser.XMPKind}, changes, options.DryRun), nil
+		} else if action == parser.VerifyAction {
+			problems, err := Verify(input.Reader, input.Size)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			fmt.Println("Textual data has been removed!")
-		} else {
-			fmt.Printf("Unssuported action: %s\n", action)
+			return parser.DescribeProblems(problems), nil
+		} else if action == parser.AuditAction {
+			metadata, err := ExportMetadata(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			findings, err := shared.AuditMetadata(metadata)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeFindings(findings, options.Policy), nil
+		} else if action == parser.HashAction {
+			ranges, err := EssenceRanges(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeHash(input.Reader, ranges)
 		}
-		return nil
+		return document.Message("Unssuported action: %s", action), nil
+	},
+	Export: func(input *parser.Input) (*parser.Metadata, error) {
+		return ExportMetadata(input.Reader, input.Size)
 	},
 }
 
-func IsPNG(file *os.File, startOffset int64) (bool, error) {
+// xmpKeyword is the keyword of the iTXt chunk storing the XMP packet.
+const xmpKeyword = "XML:com.adobe.xmp"
+
+func IsPNG(r io.ReaderAt) (bool, error) {
 	magicBytes := make([]byte, 8)
-	_, err := file.ReadAt(magicBytes, startOffset)
+	_, err := r.ReadAt(magicBytes, 0)
 	if err != nil {
 		return false, fmt.Errorf("failed to read file: %w", err)
 	}
 	return bytes.Equal(magicBytes, []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0xA, 0x1A, 0x0A}), nil
 }
 
-func GetChunks(file *os.File, startOffset int64, length int64) ([]Chunk, error) {
+func GetChunks(r io.ReaderAt, size int64) ([]Chunk, error) {
 	var result []Chunk
-	offset := startOffset + int64(8)
+	offset := int64(8)
 	for {
 		header := make([]byte, 8)
-		_, err := file.ReadAt(header, offset)
+		_, err := r.ReadAt(header, offset)
 		if err == io.EOF {
 			break
 		}
@@ -81,25 +151,25 @@ func GetChunks(file *os.File, startOffset int64, length int64) ([]Chunk, error)
 			Length:    binary.BigEndian.Uint32(header[0:4]),
 			ChunkType: header[4:8],
 			StartAt:   offset,
-			File:      file,
+			Reader:    r,
 		}
 		result = append(result, chunk)
 		offset += 12 + int64(chunk.Length)
-		if offset >= (startOffset + length) {
+		if offset >= size {
 			break
 		}
 	}
 	return result, nil
 }
 
-func GetTextData(file *os.File, startOffset int64, length int64) (map[string]string, error) {
+func GetTextData(r io.ReaderAt, size int64) (map[string]string, error) {
 	result := make(map[string]string)
-	chunks, err := GetChunks(file, startOffset, length)
+	chunks, err := GetChunks(r, size)
 	if err != nil {
 		return nil, err
 	}
 	for _, chunk := range chunks {
-		if bytes.Equal(chunk.ChunkType, []byte{0x74, 0x45, 0x58, 0x74}) {
+		if chunk.IsText() {
 			keyword, value := chunk.ParseText()
 			result[keyword] = value
 		}
@@ -107,60 +177,300 @@ func GetTextData(file *os.File, startOffset int64, length int64) (map[string]str
 	return result, nil
 }
 
-func RemoveTextData(file *os.File) error {
-	fileInfo, _ := file.Stat()
-	chunks, err := GetChunks(file, 0, fileInfo.Size())
+// ExportMetadata returns the textual data as comments, except for the XMP packet.
+func ExportMetadata(r io.ReaderAt, size int64) (*parser.Metadata, error) {
+	textData, err := GetTextData(r, size)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	tempFile, err := os.CreateTemp("", "jch_metadata_tmp_*")
-	if err != nil {
-		return err
+	keys := make([]string, 0, len(textData))
+	for k := range textData {
+		keys = append(keys, k)
 	}
-	_, err = tempFile.Write([]byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0xA, 0x1A, 0x0A})
+	sort.Strings(keys)
+	result := parser.Metadata{}
+	for _, k := range keys {
+		if k == xmpKeyword {
+			result.XMP = []byte(textData[k])
+		} else {
+			result.AddComment(k, textData[k])
+		}
+	}
+	return &result, nil
+}
+
+// TextKeyword converts an upper case comment name such as "CREATION TIME" into the usual form of PNG keywords, such as
+// "Creation Time".
+func TextKeyword(name string) string {
+	words := strings.Fields(strings.ToLower(name))
+	for i, w := range words {
+		first, size := utf8.DecodeRuneInString(w)
+		words[i] = string(unicode.ToUpper(first)) + w[size:]
+	}
+	return strings.Join(words, " ")
+}
+
+func RemoveTextData(r io.ReaderAt, size int64, w parser.Writer) error {
+	chunks, err := GetChunks(r, size)
 	if err != nil {
 		return err
 	}
-	for _, chunk := range chunks {
-		if bytes.Equal(chunk.ChunkType, []byte{0x74, 0x45, 0x58, 0x74}) {
-			continue
-		}
-		chunkData := make([]byte, chunk.Length+12)
-		_, err = file.ReadAt(chunkData, chunk.StartAt)
+	return w.Replace(func(writer io.Writer) error {
+		_, err := writer.Write([]byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0xA, 0x1A, 0x0A})
 		if err != nil {
 			return err
 		}
-		_, err = tempFile.Write(chunkData)
-		if err != nil {
-			return err
+		for _, chunk := range chunks {
+			if chunk.IsText() {
+				continue
+			}
+			chunkData := make([]byte, chunk.Length+12)
+			_, err = r.ReadAt(chunkData, chunk.StartAt)
+			if err != nil {
+				return err
+			}
+			_, err = writer.Write(chunkData)
+			if err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+}
+
+type Metadata struct {
+	TextData map[string]string `json:"text"`
+}
+
+func (m *Metadata) Describe() *document.Document {
+	doc := &document.Document{}
+	if len(m.TextData) == 0 {
+		doc.AddMessage("Textual data not found!")
+		return doc
 	}
-	err = tempFile.Close()
-	if err != nil {
-		return err
+	keys := make([]string, 0, len(m.TextData))
+	for k := range m.TextData {
+		keys = append(keys, k)
 	}
-	err = os.Rename(tempFile.Name(), file.Name())
-	if err != nil {
-		return err
+	sort.Strings(keys)
+	text := doc.AddSection("text", "Textual Data")
+	for _, k := range keys {
+		text.AddString(k, k, m.TextData[k])
 	}
-	return nil
+	return doc
 }
 
 type Chunk struct {
 	Length    uint32
 	ChunkType []byte
 	StartAt   int64
-	File      *os.File
+	Reader    io.ReaderAt
 }
 
 func (c *Chunk) ParseText() (string, string) {
 	data := make([]byte, c.Length)
-	_, _ = c.File.ReadAt(data, c.StartAt+8)
-	i := 0
-	for ; i < len(data); i++ {
-		if data[i] == 0 {
+	_, _ = c.Reader.ReadAt(data, c.StartAt+8)
+	keyword, text, _ := bytes.Cut(data, []byte{0})
+	switch string(c.ChunkType) {
+	case "zTXt":
+		if len(text) > 0 {
+			text = inflate(text[1:])
+		}
+	case "iTXt":
+		if len(text) < 2 {
+			return decodeLatin1(keyword), ""
+		}
+		compressed := text[0] == 1
+		_, text, _ = bytes.Cut(text[2:], []byte{0})
+		_, text, _ = bytes.Cut(text, []byte{0})
+		if compressed {
+			text = inflate(text)
+		}
+		return decodeLatin1(keyword), string(text)
+	}
+	return decodeLatin1(keyword), decodeLatin1(text)
+}
+
+// IsText returns true for tEXt, zTXt and iTXt chunks.
+func (c *Chunk) IsText() bool {
+	switch string(c.ChunkType) {
+	case "tEXt", "zTXt", "iTXt":
+		return true
+	}
+	return false
+}
+
+func inflate(data []byte) []byte {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	result, _ := io.ReadAll(reader)
+	return result
+}
+
+func decodeLatin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// SetTextData writes fields as textual chunks.  Chunks with the same keyword are replaced, or removed if the value is
+// empty, and new chunks are added before the IEND chunk.  Chunks are overwritten in place if their size doesn't
+// change, otherwise the file is rewritten.  If dryRun is true, the changes are returned without writing them.
+func SetTextData(r io.ReaderAt, size int64, w parser.Writer, fields []parser.Field, dryRun bool) ([]parser.Change, error) {
+	chunks, err := GetChunks(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	values := make(map[string]string)
+	for _, f := range fields {
+		if len(f.Key) > 79 {
+			return nil, fmt.Errorf("keyword %s is longer than 79 bytes", f.Key)
+		}
+		if _, found := values[f.Key]; !found {
+			keys = append(keys, f.Key)
+		}
+		values[f.Key] = f.Value
+	}
+
+	replaced := make(map[int][]byte)
+	existing := make(map[string]bool)
+	inPlace := true
+	end := len(chunks)
+	for i, c := range chunks {
+		if string(c.ChunkType) == "IEND" {
+			end = i
+		}
+		if !c.IsText() {
+			continue
+		}
+		keyword, _ := c.ParseText()
+		value, found := values[keyword]
+		if !found {
+			continue
+		}
+		existing[keyword] = true
+		if value == "" {
+			replaced[i] = nil
+		} else {
+			replaced[i] = EncodeText(keyword, value)
+		}
+		if len(replaced[i]) != int(c.Length)+12 {
+			inPlace = false
+		}
+	}
+	var added [][]byte
+	for _, k := range keys {
+		if values[k] != "" && !existing[k] {
+			added = append(added, EncodeText(k, values[k]))
+			inPlace = false
+		}
+	}
+
+	var changes []parser.Change
+	for i, c := range chunks {
+		data, found := replaced[i]
+		if !found {
+			continue
+		}
+		change := parser.Change{
+			Operation: parser.WriteOperation,
+			Target:    fmt.Sprintf("%s chunk", c.ChunkType),
+			Offset:    c.StartAt,
+			Size:      int64(len(data)),
+		}
+		if data == nil {
+			change.Operation = parser.RemoveOperation
+			change.Size = int64(c.Length) + 12
+		} else if !inPlace {
+			change.Operation = parser.RewriteOperation
+		}
+		changes = append(changes, change)
+	}
+	for _, data := range added {
+		offset := size
+		if end < len(chunks) {
+			offset = chunks[end].StartAt
+		}
+		changes = append(changes, parser.Change{
+			Operation: parser.RewriteOperation,
+			Target:    fmt.Sprintf("new %s chunk", data[4:8]),
+			Offset:    offset,
+			Size:      int64(len(data)),
+		})
+	}
+	if dryRun {
+		return changes, nil
+	}
+
+	if inPlace {
+		for i, data := range replaced {
+			_, err = w.WriteAt(data, chunks[i].StartAt)
+			if err != nil {
+				return nil, fmt.Errorf("error writing to file: %w", err)
+			}
+		}
+		return changes, nil
+	}
+	err = w.Replace(func(writer io.Writer) error {
+		_, err := writer.Write([]byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0xA, 0x1A, 0x0A})
+		if err != nil {
+			return err
+		}
+		for i, chunk := range chunks {
+			if i == end {
+				for _, data := range added {
+					_, err = writer.Write(data)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			chunkData, found := replaced[i]
+			if !found {
+				chunkData = make([]byte, chunk.Length+12)
+				_, err = r.ReadAt(chunkData, chunk.StartAt)
+				if err != nil {
+					return err
+				}
+			}
+			_, err = writer.Write(chunkData)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// EncodeText returns a complete textual chunk.  A tEXt chunk is used for ASCII values, otherwise the value is stored
+// as UTF-8 in an iTXt chunk.  XMP packets are always stored in an iTXt chunk.
+func EncodeText(keyword string, value string) []byte {
+	chunkType := "tEXt"
+	data := append([]byte(keyword), 0)
+	if keyword == xmpKeyword {
+		chunkType = "iTXt"
+		data = append(data, 0, 0, 0, 0)
+	}
+	for _, c := range value {
+		if c >= utf8.RuneSelf && chunkType == "tEXt" {
+			chunkType = "iTXt"
+			data = append(data, 0, 0, 0, 0)
 			break
 		}
 	}
-	return string(data[0:i]), string(data[i+1:])
+	data = append(data, value...)
+	result := make([]byte, 8, len(data)+12)
+	binary.BigEndian.PutUint32(result[0:4], uint32(len(data)))
+	copy(result[4:8], chunkType)
+	result = append(result, data...)
+	return binary.BigEndian.AppendUint32(result, crc32.ChecksumIEEE(result[4:]))
 }
@@ -0,0 +1,57 @@
+package png
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"jch-metadata/internal/parser"
+)
+
+// Verify checks that chunks fit in the file and have a valid CRC, that the first chunk is IHDR and that the file ends
+// with an IEND chunk.
+func Verify(r io.ReaderAt, size int64) ([]parser.Problem, error) {
+	var problems []parser.Problem
+	offset := int64(8)
+	index := 0
+	for offset < size {
+		if offset+8 > size {
+			problems = append(problems, parser.NewError("file", offset, "%d bytes after the last chunk are not a chunk", size-offset))
+			return problems, nil
+		}
+		header := make([]byte, 8)
+		_, err := r.ReadAt(header, offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		chunkType := string(header[4:8])
+		target := fmt.Sprintf("%s chunk", chunkType)
+		if index == 0 && chunkType != "IHDR" {
+			problems = append(problems, parser.NewError(target, offset, "first chunk should be IHDR"))
+		}
+		if length > 0x7FFFFFFF || offset+12+length > size {
+			problems = append(problems, parser.NewError(target, offset, "chunk length %d overflows the end of file", length))
+			return problems, nil
+		}
+		data := make([]byte, length+8)
+		_, err = r.ReadAt(data, offset+4)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		expected := binary.BigEndian.Uint32(data[length+4:])
+		if actual := crc32.ChecksumIEEE(data[:length+4]); actual != expected {
+			problems = append(problems, parser.NewError(target, offset, "CRC is 0x%08X but should be 0x%08X", expected, actual))
+		}
+		offset += 12 + length
+		index += 1
+		if chunkType == "IEND" {
+			if offset < size {
+				problems = append(problems, parser.NewWarning("file", offset, "%d bytes after IEND chunk", size-offset))
+			}
+			return problems, nil
+		}
+	}
+	problems = append(problems, parser.NewError("file", size, "IEND chunk not found"))
+	return problems, nil
+}
@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"fmt"
+	"jch-metadata/internal/document"
+)
+
+const (
+	ErrorSeverity   = "error"
+	WarningSeverity = "warning"
+)
+
+// Problem is an inconsistency in the structure of a file found by the verify action.
+type Problem struct {
+	Severity string
+	Target   string
+	Offset   int64
+	Message  string
+}
+
+// NewError returns a problem that makes the file invalid.
+func NewError(target string, offset int64, format string, a ...any) Problem {
+	return Problem{Severity: ErrorSeverity, Target: target, Offset: offset, Message: fmt.Sprintf(format, a...)}
+}
+
+// NewWarning returns a problem that is tolerated by most readers, such as data after the end of the file.
+func NewWarning(target string, offset int64, format string, a ...any) Problem {
+	return Problem{Severity: WarningSeverity, Target: target, Offset: offset, Message: fmt.Sprintf(format, a...)}
+}
+
+// DescribeProblems returns a document listing the problems found by the verify action.
+func DescribeProblems(problems []Problem) *document.Document {
+	doc := &document.Document{}
+	errors := 0
+	for i, p := range problems {
+		section := doc.AddSection(fmt.Sprintf("problem.%d", i), fmt.Sprintf("Problem %d", i+1))
+		section.AddString("severity", "Severity", p.Severity)
+		section.AddString("target", "Target", p.Target)
+		section.AddInt("offset", "Offset", p.Offset)
+		section.AddString("message", "Message", p.Message)
+		if p.Severity == ErrorSeverity {
+			errors += 1
+		}
+	}
+	if len(problems) == 0 {
+		doc.AddMessage("No problems found")
+	} else {
+		doc.AddMessage("Found %d errors and %d warnings", errors, len(problems)-errors)
+	}
+	return doc
+}
+
+// CountErrors returns the number of problems with error severity listed in doc and its nested documents.
+func CountErrors(doc *document.Document) int {
+	return countErrors(&doc.Section)
+}
+
+func countErrors(section *document.Section) int {
+	result := 0
+	for _, s := range section.Sections {
+		if severity := s.FindField("severity"); severity != nil && severity.Value == ErrorSeverity {
+			result += 1
+		}
+		result += countErrors(s)
+	}
+	for _, d := range section.Documents {
+		result += countErrors(&d.Section)
+	}
+	return result
+}
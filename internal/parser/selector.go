@@ -0,0 +1,54 @@
+package parser
+
+import "strings"
+
+// Selector chooses the metadata removed by the clear action.  Metadata is identified by names such as "icc",
+// "exif.gps" or "mkv.tags".  A selector matches a name if it is equal to the name or to one of its parents, so
+// "exif" matches "exif.gps".  When both Keep and Remove match, the most specific selector wins.
+type Selector struct {
+	Keep   []string
+	Remove []string
+}
+
+// Removes returns true if metadata identified by any of names should be removed.  Without Remove selectors,
+// everything that is not kept is removed.
+func (s Selector) Removes(names ...string) bool {
+	keep := longestMatch(s.Keep, names)
+	remove := longestMatch(s.Remove, names)
+	if keep < 0 && remove < 0 {
+		return len(s.Remove) == 0
+	}
+	return remove > keep
+}
+
+// Selects returns true if metadata identified by any of names is explicitly removed.  It is used for metadata
+// that is not removed by default.
+func (s Selector) Selects(names ...string) bool {
+	remove := longestMatch(s.Remove, names)
+	return remove >= 0 && remove > longestMatch(s.Keep, names)
+}
+
+// KeepsPart returns true if a Keep selector chooses a part of the metadata identified by any of names, such as
+// "exif.gps" for "exif".  Such metadata can't be removed as a whole.
+func (s Selector) KeepsPart(names ...string) bool {
+	for _, selector := range s.Keep {
+		for _, name := range names {
+			if strings.HasPrefix(selector, name+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func longestMatch(selectors []string, names []string) int {
+	result := -1
+	for _, selector := range selectors {
+		for _, name := range names {
+			if (name == selector || strings.HasPrefix(name, selector+".")) && len(selector) > result {
+				result = len(selector)
+			}
+		}
+	}
+	return result
+}
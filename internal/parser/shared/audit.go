@@ -0,0 +1,228 @@
+package shared
+
+import (
+	"fmt"
+	"jch-metadata/internal/parser"
+	"regexp"
+	"strings"
+)
+
+type auditTag struct {
+	Category string
+	Name     string
+}
+
+// exifAuditTags are the EXIF tags reported by the audit action.
+var exifAuditTags = map[uint16]auditTag{
+	0x0131: {parser.SoftwareCategory, "Software"},
+	0x0132: {parser.TimestampCategory, "DateTime"},
+	0x013B: {parser.PersonCategory, "Artist"},
+	0x8298: {parser.PersonCategory, "Copyright"},
+	0x9003: {parser.TimestampCategory, "DateTimeOriginal"},
+	0x9004: {parser.TimestampCategory, "DateTimeDigitized"},
+	0xA430: {parser.PersonCategory, "CameraOwnerName"},
+	0xA431: {parser.SerialCategory, "BodySerialNumber"},
+	0xA435: {parser.SerialCategory, "LensSerialNumber"},
+	0xC62F: {parser.SerialCategory, "CameraSerialNumber"},
+}
+
+// xmpAuditProperties are the XMP properties reported by the audit action.
+var xmpAuditProperties = []auditTag{
+	{parser.LocationCategory, "exif:GPSLatitude"},
+	{parser.LocationCategory, "exif:GPSLongitude"},
+	{parser.LocationCategory, "photoshop:City"},
+	{parser.LocationCategory, "Iptc4xmpCore:Location"},
+	{parser.SerialCategory, "aux:SerialNumber"},
+	{parser.SerialCategory, "aux:LensSerialNumber"},
+	{parser.SerialCategory, "exifEX:BodySerialNumber"},
+	{parser.SerialCategory, "exifEX:LensSerialNumber"},
+	{parser.PersonCategory, "dc:creator"},
+	{parser.PersonCategory, "dc:rights"},
+	{parser.PersonCategory, "xmpRights:Owner"},
+	{parser.PersonCategory, "photoshop:Credit"},
+	{parser.SoftwareCategory, "xmp:CreatorTool"},
+	{parser.TimestampCategory, "xmp:CreateDate"},
+	{parser.TimestampCategory, "xmp:ModifyDate"},
+	{parser.TimestampCategory, "xmp:MetadataDate"},
+	{parser.TimestampCategory, "photoshop:DateCreated"},
+	{parser.TimestampCategory, "exif:DateTimeOriginal"},
+}
+
+// commentAuditNames are the upper case names of textual values reported by the audit action.
+var commentAuditNames = map[string]string{
+	"LOCATION":           parser.LocationCategory,
+	"LOCATION.ISO6709":   parser.LocationCategory,
+	"RECORDING_LOCATION": parser.LocationCategory,
+	"SERIAL_NUMBER":      parser.SerialCategory,
+	"AUTHOR":             parser.PersonCategory,
+	"ARTIST":             parser.PersonCategory,
+	"COPYRIGHT":          parser.PersonCategory,
+	"ENCODED_BY":         parser.PersonCategory,
+	"ENCODED-BY":         parser.PersonCategory,
+	"ENCODER":            parser.SoftwareCategory,
+	"SOFTWARE":           parser.SoftwareCategory,
+	"WRITING_APP":        parser.SoftwareCategory,
+	"DATE":               parser.TimestampCategory,
+	"CREATION TIME":      parser.TimestampCategory,
+	"CREATIONDATE":       parser.TimestampCategory,
+	"DATE_RECORDED":      parser.TimestampCategory,
+	"DATE_ENCODED":       parser.TimestampCategory,
+}
+
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// AuditMetadata returns the values of metadata that may leak personal data.
+func AuditMetadata(metadata *parser.Metadata) ([]parser.Finding, error) {
+	var result []parser.Finding
+	if len(metadata.Exif) > 0 {
+		findings, err := AuditExif(append([]byte(ExifHeader), metadata.Exif...))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, findings...)
+	}
+	if len(metadata.XMP) > 0 {
+		result = append(result, AuditXMP(string(metadata.XMP))...)
+	}
+	for _, c := range metadata.Comments {
+		if category, found := commentAuditNames[strings.ToUpper(c.Key)]; found {
+			result = append(result, parser.Finding{Category: category, Source: fmt.Sprintf("comment %s", c.Key), Value: c.Value})
+		}
+	}
+	return result, nil
+}
+
+// AuditExif returns the EXIF values that may leak personal data, such as GPS coordinates or serial numbers, including
+// the identifying values of MakerNotes.  raw starts with the "Exif\0\0" prefix.
+func AuditExif(raw []byte) ([]parser.Finding, error) {
+	if len(raw) < 14 || string(raw[0:4]) != "Exif" {
+		return nil, nil
+	}
+	byteOrder, err := NewByteOrder(raw[6:8])
+	if err != nil {
+		return nil, err
+	}
+	ifds, err := ParseExif(raw)
+	if err != nil {
+		return nil, err
+	}
+	result := auditIFD(raw, byteOrder, int(byteOrder.getUint32(raw[10:14]))+6, "IFD0", 0)
+	for _, ifd := range ifds {
+		result = append(result, auditMakerNote(ifd)...)
+	}
+	return result, nil
+}
+
+func auditIFD(raw []byte, byteOrder ByteOrder, offset int, name string, depth int) []parser.Finding {
+	var result []parser.Finding
+	if depth > 2 || offset < 6 || offset+2 > len(raw) {
+		return nil
+	}
+	numberOfTags := int(byteOrder.getUint16(raw[offset : offset+2]))
+	for c := 0; c < numberOfTags; c++ {
+		entry := offset + 2 + c*12
+		if entry+12 > len(raw) {
+			break
+		}
+		tagId := byteOrder.getUint16(raw[entry : entry+2])
+		valueOffset := int(byteOrder.getUint32(raw[entry+8:entry+12])) + 6
+		if tagId == 0x8769 {
+			result = append(result, auditIFD(raw, byteOrder, valueOffset, "Exif IFD", depth+1)...)
+		} else if tagId == 0x8825 {
+			result = append(result, auditGPS(raw, byteOrder, valueOffset)...)
+		} else if tag, found := exifAuditTags[tagId]; found {
+			value := strings.TrimSpace(exifString(raw, byteOrder, entry))
+			if value != "" {
+				result = append(result, parser.Finding{Category: tag.Category, Source: fmt.Sprintf("EXIF %s %s", name, tag.Name), Value: value})
+			}
+		}
+	}
+	return result
+}
+
+// auditGPS returns a finding for the GPS IFD at offset with the coordinates when they are available.
+func auditGPS(raw []byte, byteOrder ByteOrder, offset int) []parser.Finding {
+	if offset+2 > len(raw) {
+		return nil
+	}
+	numberOfTags := int(byteOrder.getUint16(raw[offset : offset+2]))
+	values := make(map[uint16]string)
+	for c := 0; c < numberOfTags; c++ {
+		entry := offset + 2 + c*12
+		if entry+12 > len(raw) {
+			break
+		}
+		tagId := byteOrder.getUint16(raw[entry : entry+2])
+		tagType := byteOrder.getUint16(raw[entry+2 : entry+4])
+		if tagType == 2 {
+			values[tagId] = exifString(raw, byteOrder, entry)
+		} else if tagType == 5 && byteOrder.getUint32(raw[entry+4:entry+8]) == 3 {
+			values[tagId] = exifCoordinate(raw, byteOrder, int(byteOrder.getUint32(raw[entry+8:entry+12]))+6)
+		}
+	}
+	if numberOfTags == 0 || (numberOfTags == 1 && len(values) == 0) {
+		// Only GPSVersionID or an empty IFD, which don't reveal a location
+		return nil
+	}
+	value := fmt.Sprintf("%d tags", numberOfTags)
+	if values[0x0002] != "" && values[0x0004] != "" {
+		value = fmt.Sprintf("%s %s, %s %s", values[0x0002], values[0x0001], values[0x0004], values[0x0003])
+	}
+	return []parser.Finding{{Category: parser.LocationCategory, Source: "EXIF GPS IFD", Value: value}}
+}
+
+// exifString returns the ASCII value of the IFD entry at entry.  Values of 4 bytes or less are stored in the entry.
+func exifString(raw []byte, byteOrder ByteOrder, entry int) string {
+	if byteOrder.getUint16(raw[entry+2:entry+4]) != 2 {
+		return ""
+	}
+	count := int(byteOrder.getUint32(raw[entry+4 : entry+8]))
+	start := entry + 8
+	if count > 4 {
+		start = int(byteOrder.getUint32(raw[entry+8:entry+12])) + 6
+	}
+	if count < 0 || start+count > len(raw) {
+		return ""
+	}
+	return strings.TrimRight(string(raw[start:start+count]), "\x00")
+}
+
+// exifCoordinate formats three rationals (degrees, minutes and seconds) at offset as decimal degrees.
+func exifCoordinate(raw []byte, byteOrder ByteOrder, offset int) string {
+	if offset+24 > len(raw) {
+		return ""
+	}
+	result := 0.0
+	for i, scale := range []float64{1, 60, 3600} {
+		numerator := byteOrder.getUint32(raw[offset+i*8 : offset+i*8+4])
+		denominator := byteOrder.getUint32(raw[offset+i*8+4 : offset+i*8+8])
+		if denominator != 0 {
+			result += float64(numerator) / float64(denominator) / scale
+		}
+	}
+	return fmt.Sprintf("%.6f", result)
+}
+
+// AuditXMP returns the values of XMP properties that may leak personal data, such as the creators.  Properties can
+// be written as attributes or as elements.
+func AuditXMP(xmp string) []parser.Finding {
+	var result []parser.Finding
+	for _, p := range xmpAuditProperties {
+		name := regexp.QuoteMeta(p.Name)
+		attribute := regexp.MustCompile(`\s` + name + `="([^"]*)"`)
+		element := regexp.MustCompile(`(?s)<` + name + `(?:\s[^>]*)?>(.*?)</` + name + `>`)
+		var values []string
+		for _, m := range attribute.FindAllStringSubmatch(xmp, -1) {
+			values = append(values, m[1])
+		}
+		for _, m := range element.FindAllStringSubmatch(xmp, -1) {
+			values = append(values, strings.Join(strings.Fields(tagPattern.ReplaceAllString(m[1], " ")), " "))
+		}
+		for _, v := range values {
+			if v != "" {
+				result = append(result, parser.Finding{Category: p.Category, Source: fmt.Sprintf("XMP %s", p.Name), Value: v})
+			}
+		}
+	}
+	return result
+}
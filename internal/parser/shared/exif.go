@@ -2,126 +2,292 @@ package shared
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
-	"jch-metadata/internal/output"
+	"jch-metadata/internal/document"
 	"sort"
+	"time"
 )
 
+// ExifHeader is the prefix of EXIF data in JPEG APP1 segments and WebP EXIF chunks.
+const ExifHeader = "Exif\x00\x00"
+
 type IFD struct {
 	StartOffset uint32
-	Tags        map[uint16]string
+	Kind        IFDKind
+	Tags        map[uint16]Value
+	// Thumbnail is the JPEG thumbnail referenced by the JPEGInterchangeFormat tag of a TIFF IFD.
+	Thumbnail []byte
+	// Strips are the image data referenced by the StripOffsets tag of a TIFF IFD.
+	Strips [][]byte
+}
+
+func (ifd IFD) MarshalJSON() ([]byte, error) {
+	tags := make(map[string]Value)
+	for k, v := range ifd.Tags {
+		tags[fmt.Sprintf("0x%04X", k)] = v
+	}
+	return json.Marshal(struct {
+		StartOffset uint32           `json:"offset"`
+		Kind        IFDKind          `json:"kind"`
+		Tags        map[string]Value `json:"tags"`
+	}{ifd.StartOffset, ifd.Kind, tags})
 }
 
-func ParseExif(raw []byte) []IFD {
+// ParseExif decodes the IFDs of EXIF data that starts with the "Exif\0\0" prefix.  IFD0, the Exif IFDs and the
+// following IFDs of the chain come first, followed by the GPS and Interoperability IFDs and the MakerNote IFDs.  It
+// returns nil if raw isn't EXIF data and an error if the byte order of the TIFF header is invalid.
+func ParseExif(raw []byte) ([]IFD, error) {
+	if len(raw) < 14 {
+		return nil, nil
+	}
 	if string(raw[0:4]) != "Exif" {
-		return nil
+		return nil, nil
 	}
 	if raw[4] != 0x00 && raw[5] != 0x00 {
-		return nil
+		return nil, nil
 	}
-	byteOrder := ByteOrder{
-		byteOrder: raw[6:8],
+	byteOrder, err := NewByteOrder(raw[6:8])
+	if err != nil {
+		return nil, err
 	}
 	if byteOrder.getUint16(raw[8:10]) != 0x002A {
-		return nil
+		return nil, nil
 	}
 	offsetIFD := byteOrder.getUint32(raw[10:14])
-	var result []IFD
-	ifd := IFD{}
-	for {
-		ifd.StartOffset = offsetIFD
+	var result, subIFDs, makerNotes []IFD
+	visited := make(map[uint32]bool)
+	for offsetIFD != 0 && !visited[offsetIFD] {
+		visited[offsetIFD] = true
+		ifd := IFD{StartOffset: offsetIFD, Kind: TIFFIFD}
 		links, next := ParseIFD(&ifd, raw, byteOrder)
+		readImageData(&ifd, raw)
 		result = append(result, ifd)
-		for _, link := range links {
-			ifd = IFD{
-				StartOffset: link,
+		for len(links) > 0 {
+			link := links[0]
+			links = links[1:]
+			if visited[link.StartOffset] {
+				continue
+			}
+			visited[link.StartOffset] = true
+			nested, _ := ParseIFD(&link, raw, byteOrder)
+			links = append(links, nested...)
+			if link.Kind == ExifIFD {
+				result = append(result, link)
+				makerNotes = append(makerNotes, parseMakerNote(raw, byteOrder, link, result[0].Tags[0x010F].String())...)
+			} else {
+				subIFDs = append(subIFDs, link)
 			}
-			_, _ = ParseIFD(&ifd, raw, byteOrder)
-			result = append(result, ifd)
-		}
-		if next == 0 {
-			break
 		}
 		offsetIFD = next
-		ifd = IFD{}
 	}
-	return result
+	return append(append(result, subIFDs...), makerNotes...), nil
+}
+
+// ifdPointers are the tags that link to another IFD and the kind of the linked IFD.
+var ifdPointers = map[uint16]IFDKind{
+	0x8769: ExifIFD,
+	0x8825: GPSIFD,
+	0xA005: InteropIFD,
 }
 
-func ParseIFD(ifd *IFD, raw []byte, byteOrder ByteOrder) ([]uint32, uint32) {
-	i := ifd.StartOffset + 6
+// ParseIFD decodes the entries of ifd.  Values of 4 bytes or less are stored in the entry and larger values are
+// read from their offset.  It returns the Exif, GPS and Interoperability IFDs linked from ifd, which aren't parsed
+// yet, and the offset of the next IFD.
+func ParseIFD(ifd *IFD, raw []byte, byteOrder ByteOrder) ([]IFD, uint32) {
+	var links []IFD
+	ifd.Tags = make(map[uint16]Value)
+	i := int(ifd.StartOffset) + 6
+	if i+2 > len(raw) {
+		return links, 0
+	}
 	numberOfTags := int(byteOrder.getUint16(raw[i : i+2]))
 	i += 2
-	links := make([]uint32, 0)
-	ifd.Tags = make(map[uint16]string)
 	for c := 0; c < numberOfTags; c++ {
+		if i+12 > len(raw) {
+			return links, 0
+		}
 		tagId := byteOrder.getUint16(raw[i : i+2])
-		i += 2
-		tagType := byteOrder.getUint16(raw[i : i+2])
-		i += 2
-		valueCount := byteOrder.getUint32(raw[i : i+4])
-		i += 4
-		valueOffset := byteOrder.getUint32(raw[i : i+4])
-		i += 4
-		if tagId == 0x8769 {
-			links = append(links, valueOffset)
-		} else {
-			if tagType == 2 || tagType == 129 {
-				start := valueOffset + 6
-				end := start + valueCount - 1
-				if int(end) > len(raw) {
-					continue
-				}
-				ifd.Tags[tagId] = string(raw[start:end])
-			} else {
-				ifd.Tags[tagId] = fmt.Sprintf("%v", valueOffset)
+		tagType := byteOrder.getUint16(raw[i+2 : i+4])
+		valueCount := byteOrder.getUint32(raw[i+4 : i+8])
+		valueOffset := byteOrder.getUint32(raw[i+8 : i+12])
+		entry := i
+		i += 12
+		if kind, found := ifdPointers[tagId]; found && (ifd.Kind == TIFFIFD || ifd.Kind == ExifIFD) {
+			links = append(links, IFD{StartOffset: valueOffset, Kind: kind})
+			if kind == ExifIFD {
+				continue
 			}
 		}
+		if !validType(tagType) {
+			continue
+		}
+		size := int64(valueCount) * int64(typeSize(tagType))
+		start := int64(entry) + 8
+		if size > 4 {
+			start = int64(valueOffset) + 6
+		}
+		if start+size > int64(len(raw)) {
+			continue
+		}
+		value := DecodeValue(tagType, valueCount, raw[start:start+size], byteOrder)
+		if size > 4 {
+			value.Offset = valueOffset
+		}
+		ifd.Tags[tagId] = value
+	}
+	if i+4 > len(raw) {
+		return links, 0
 	}
 	next := byteOrder.getUint32(raw[i : i+4])
 	return links, next
 }
 
+// readImageData keeps the thumbnail or strips referenced by a TIFF IFD, so EncodeExif can write them back.
+func readImageData(ifd *IFD, raw []byte) {
+	if offset, ok := ifd.Tags[0x0201].Uint(0); ok {
+		length, _ := ifd.Tags[0x0202].Uint(0)
+		if offset+6+length <= uint64(len(raw)) {
+			ifd.Thumbnail = raw[offset+6 : offset+6+length]
+		}
+	}
+	for i := 0; ; i++ {
+		offset, ok := ifd.Tags[0x0111].Uint(i)
+		length, found := ifd.Tags[0x0117].Uint(i)
+		if !ok || !found || offset+6+length > uint64(len(raw)) {
+			break
+		}
+		ifd.Strips = append(ifd.Strips, raw[offset+6:offset+6+length])
+	}
+}
+
+// typeSize returns the size in bytes of a single value of an EXIF type.
+func typeSize(tagType uint16) int {
+	switch tagType {
+	case 3, 8:
+		return 2
+	case 4, 9, 11, 13:
+		return 4
+	case 5, 10, 12:
+		return 8
+	default:
+		return 1
+	}
+}
+
+// ByteOrder is the byte order of a TIFF header, "II" for little endian or "MM" for big endian.
 type ByteOrder struct {
 	byteOrder []byte
 }
 
-func (o *ByteOrder) getUint16(value []byte) uint16 {
-	if o.byteOrder[0] == 0x49 && o.byteOrder[1] == 0x49 {
-		return binary.LittleEndian.Uint16(value)
-	} else if o.byteOrder[0] == 0x4D && o.byteOrder[1] == 0x4D {
-		return binary.BigEndian.Uint16(value)
+// NewByteOrder returns the byte order of a TIFF header.  It returns an error if order is neither "II" nor "MM".
+func NewByteOrder(order []byte) (ByteOrder, error) {
+	if string(order) != "II" && string(order) != "MM" {
+		return ByteOrder{}, fmt.Errorf("invalid byte order: % X", order)
 	}
-	fmt.Printf("Invalid byte order: %v", o.byteOrder)
-	return 0
+	return ByteOrder{byteOrder: order}, nil
 }
 
-func (o *ByteOrder) getUint32(value []byte) uint32 {
+// Byte orders of TIFF headers.
+var (
+	LittleEndian = ByteOrder{byteOrder: []byte("II")}
+	BigEndian    = ByteOrder{byteOrder: []byte("MM")}
+)
+
+// binaryOrder is a byte order of encoding/binary that can both read and append values.
+type binaryOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// binaryOrder returns the encoding/binary equivalent of the byte order.
+func (o *ByteOrder) binaryOrder() binaryOrder {
 	if o.byteOrder[0] == 0x49 && o.byteOrder[1] == 0x49 {
-		return binary.LittleEndian.Uint32(value)
-	} else if o.byteOrder[0] == 0x4D && o.byteOrder[1] == 0x4D {
-		return binary.BigEndian.Uint32(value)
+		return binary.LittleEndian
 	}
-	fmt.Printf("Invalid byte order: %v", o.byteOrder)
-	return 0
+	return binary.BigEndian
 }
 
-func PrintExif(indented bool, ifds []IFD) {
-	if ifds == nil || len(ifds) == 0 {
-		return
-	}
-	for _, ifd := range ifds {
-		output.PrintHeader(indented, "EXIF IFD Offset 0x%0X", ifd.StartOffset)
+func (o *ByteOrder) getUint16(value []byte) uint16 {
+	return o.binaryOrder().Uint16(value)
+}
+
+func (o *ByteOrder) getUint32(value []byte) uint32 {
+	return o.binaryOrder().Uint32(value)
+}
+
+func (o *ByteOrder) getUint64(value []byte) uint64 {
+	return o.binaryOrder().Uint64(value)
+}
+
+func ExifSections(ifds []IFD) []*document.Section {
+	var result []*document.Section
+	for i, ifd := range ifds {
+		section := &document.Section{
+			Key:   fmt.Sprintf("exif.%d", i),
+			Title: fmt.Sprintf("EXIF IFD Offset 0x%0X", ifd.StartOffset),
+		}
+		switch ifd.Kind {
+		case GPSIFD:
+			section.Key = "exif.gps"
+			section.Title = fmt.Sprintf("EXIF GPS IFD Offset 0x%0X", ifd.StartOffset)
+			addGPSFields(section, DecodeGPS(ifd))
+		case InteropIFD:
+			section.Key = "exif.interop"
+			section.Title = fmt.Sprintf("EXIF Interoperability IFD Offset 0x%0X", ifd.StartOffset)
+		}
+		vendor, isMakerNote := makerNoteVendors[ifd.Kind]
+		if isMakerNote {
+			section.Key = "exif." + string(ifd.Kind)
+			section.Title = fmt.Sprintf("%s IFD Offset 0x%0X", vendor.Title, ifd.StartOffset)
+		}
 		tags := make([]uint16, len(ifd.Tags))
-		i := 0
+		j := 0
 		for k := range ifd.Tags {
-			tags[i] = k
-			i++
+			tags[j] = k
+			j++
 		}
 		sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
 		for _, t := range tags {
-			output.PrintForm(indented, fmt.Sprintf("0x%04X", t), ifd.Tags[t], 10)
+			if _, known := vendor.Tags[t]; isMakerNote && !known {
+				continue
+			}
+			id := fmt.Sprintf("0x%04X", t)
+			name := TagName(ifd.Kind, t)
+			value := ifd.Tags[t]
+			if text, ok := InterpretTag(ifd.Kind, t, value); ok {
+				section.AddString(id, name, text)
+			} else if u, ok := value.Uint(0); ok && value.Count == 1 {
+				section.AddUint(id, name, u)
+			} else if f, ok := value.Float(0); ok && value.Count == 1 && value.Type >= FloatType && value.Type <= DoubleType {
+				section.AddFloat(id, name, f)
+			} else {
+				section.AddString(id, name, value.String())
+			}
 		}
-		output.Println(indented)
+		result = append(result, section)
+	}
+	return result
+}
+
+// addGPSFields adds the decoded location before the tags of the GPS IFD.
+func addGPSFields(section *document.Section, gps GPSInfo) {
+	if gps.Latitude != nil {
+		section.AddFloat("latitude", "Latitude", *gps.Latitude)
+	}
+	if gps.Longitude != nil {
+		section.AddFloat("longitude", "Longitude", *gps.Longitude)
+	}
+	if gps.Altitude != nil {
+		section.AddFloat("altitude", "Altitude (m)", *gps.Altitude)
+	}
+	if gps.Time != nil {
+		section.AddString("timestamp", "Timestamp", gps.Time.Format(time.RFC3339Nano))
+	}
+	if gps.Speed != nil {
+		section.AddFloat("speed", fmt.Sprintf("Speed (%s)", gps.SpeedUnit), *gps.Speed)
+	}
+	if gps.Direction != nil {
+		section.AddFloat("direction", fmt.Sprintf("Direction (%s)", gps.DirectionRef), *gps.Direction)
 	}
 }
@@ -0,0 +1,106 @@
+package shared
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+// GPSInfo is the location stored in a GPS IFD.  Coordinates are signed decimal degrees, negative for the southern
+// and western hemispheres, and the altitude is negative below sea level.  Fields that aren't stored in the IFD are
+// nil.
+type GPSInfo struct {
+	Latitude  *float64
+	Longitude *float64
+	// Altitude is in meters.
+	Altitude *float64
+	Time     *time.Time
+	Speed    *float64
+	// SpeedUnit is "km/h", "mph" or "knots".
+	SpeedUnit string
+	// Direction is the direction of the image in degrees, or the direction of movement if it isn't available.
+	Direction *float64
+	// DirectionRef is "true north" or "magnetic north".
+	DirectionRef string
+}
+
+// DecodeGPS returns the location stored in a GPS IFD.
+func DecodeGPS(ifd IFD) GPSInfo {
+	var result GPSInfo
+	result.Latitude = gpsCoordinate(ifd.Tags[0x0002], ifd.Tags[0x0001], "S")
+	result.Longitude = gpsCoordinate(ifd.Tags[0x0004], ifd.Tags[0x0003], "W")
+	if altitude, ok := ifd.Tags[0x0006].Float(0); ok {
+		if ref, _ := ifd.Tags[0x0005].Uint(0); ref == 1 {
+			altitude = -altitude
+		}
+		result.Altitude = &altitude
+	}
+	result.Time = gpsTime(ifd.Tags[0x001D], ifd.Tags[0x0007])
+	if speed, ok := ifd.Tags[0x000D].Float(0); ok {
+		result.Speed = &speed
+		switch ifd.Tags[0x000C].String() {
+		case "M":
+			result.SpeedUnit = "mph"
+		case "N":
+			result.SpeedUnit = "knots"
+		default:
+			result.SpeedUnit = "km/h"
+		}
+	}
+	direction, ref := ifd.Tags[0x0011], ifd.Tags[0x0010]
+	if _, ok := direction.Float(0); !ok {
+		direction, ref = ifd.Tags[0x000F], ifd.Tags[0x000E]
+	}
+	if degrees, ok := direction.Float(0); ok {
+		result.Direction = &degrees
+		if ref.String() == "M" {
+			result.DirectionRef = "magnetic north"
+		} else {
+			result.DirectionRef = "true north"
+		}
+	}
+	return result
+}
+
+// gpsCoordinate converts degrees, minutes and seconds to decimal degrees.  The result is negative if ref is equal to
+// negativeRef.
+func gpsCoordinate(value Value, ref Value, negativeRef string) *float64 {
+	var dms [3]float64
+	for i := range dms {
+		f, ok := value.Float(i)
+		if !ok {
+			if i == 0 {
+				return nil
+			}
+			break
+		}
+		dms[i] = f
+	}
+	result := dms[0] + dms[1]/60 + dms[2]/3600
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return nil
+	}
+	if strings.EqualFold(strings.TrimSpace(ref.String()), negativeRef) {
+		result = -result
+	}
+	return &result
+}
+
+// gpsTime combines GPSDateStamp, formatted as "YYYY:MM:DD", and the hours, minutes and seconds of GPSTimeStamp into
+// a UTC time.
+func gpsTime(date Value, timeOfDay Value) *time.Time {
+	day, err := time.Parse("2006:01:02", strings.TrimSpace(date.String()))
+	if err != nil {
+		return nil
+	}
+	var hms [3]float64
+	for i := range hms {
+		hms[i], _ = timeOfDay.Float(i)
+	}
+	seconds := hms[0]*3600 + hms[1]*60 + hms[2]
+	if seconds < 0 || seconds >= 86400 {
+		return nil
+	}
+	result := day.Add(time.Duration(seconds * float64(time.Second))).UTC()
+	return &result
+}
@@ -0,0 +1,329 @@
+package shared
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// IFDKind identifies the tags that can be stored in an IFD, since GPS and Interoperability tag IDs overlap with
+// TIFF tag IDs.
+type IFDKind string
+
+const (
+	TIFFIFD    IFDKind = "tiff"
+	ExifIFD    IFDKind = "exif"
+	GPSIFD     IFDKind = "gps"
+	InteropIFD IFDKind = "interop"
+)
+
+// tiffTagNames are the TIFF tags used by EXIF 2.32 in IFD0 and IFD1.
+var tiffTagNames = map[uint16]string{
+	0x0100: "ImageWidth",
+	0x0101: "ImageLength",
+	0x0102: "BitsPerSample",
+	0x0103: "Compression",
+	0x0106: "PhotometricInterpretation",
+	0x010E: "ImageDescription",
+	0x010F: "Make",
+	0x0110: "Model",
+	0x0111: "StripOffsets",
+	0x0112: "Orientation",
+	0x0115: "SamplesPerPixel",
+	0x0116: "RowsPerStrip",
+	0x0117: "StripByteCounts",
+	0x011A: "XResolution",
+	0x011B: "YResolution",
+	0x011C: "PlanarConfiguration",
+	0x0128: "ResolutionUnit",
+	0x012D: "TransferFunction",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013B: "Artist",
+	0x013E: "WhitePoint",
+	0x013F: "PrimaryChromaticities",
+	0x0201: "JPEGInterchangeFormat",
+	0x0202: "JPEGInterchangeFormatLength",
+	0x0211: "YCbCrCoefficients",
+	0x0212: "YCbCrSubSampling",
+	0x0213: "YCbCrPositioning",
+	0x0214: "ReferenceBlackWhite",
+	0x8298: "Copyright",
+	0x8769: "ExifIFDPointer",
+	0x8825: "GPSInfoIFDPointer",
+	0xC62F: "CameraSerialNumber",
+}
+
+// exifTagNames are the tags of the Exif IFD defined by EXIF 2.32.
+var exifTagNames = map[uint16]string{
+	0x829A: "ExposureTime",
+	0x829D: "FNumber",
+	0x8822: "ExposureProgram",
+	0x8824: "SpectralSensitivity",
+	0x8827: "PhotographicSensitivity",
+	0x8828: "OECF",
+	0x8830: "SensitivityType",
+	0x8831: "StandardOutputSensitivity",
+	0x8832: "RecommendedExposureIndex",
+	0x8833: "ISOSpeed",
+	0x8834: "ISOSpeedLatitudeyyy",
+	0x8835: "ISOSpeedLatitudezzz",
+	0x9000: "ExifVersion",
+	0x9003: "DateTimeOriginal",
+	0x9004: "DateTimeDigitized",
+	0x9010: "OffsetTime",
+	0x9011: "OffsetTimeOriginal",
+	0x9012: "OffsetTimeDigitized",
+	0x9101: "ComponentsConfiguration",
+	0x9102: "CompressedBitsPerPixel",
+	0x9201: "ShutterSpeedValue",
+	0x9202: "ApertureValue",
+	0x9203: "BrightnessValue",
+	0x9204: "ExposureBiasValue",
+	0x9205: "MaxApertureValue",
+	0x9206: "SubjectDistance",
+	0x9207: "MeteringMode",
+	0x9208: "LightSource",
+	0x9209: "Flash",
+	0x920A: "FocalLength",
+	0x9214: "SubjectArea",
+	0x927C: "MakerNote",
+	0x9286: "UserComment",
+	0x9290: "SubSecTime",
+	0x9291: "SubSecTimeOriginal",
+	0x9292: "SubSecTimeDigitized",
+	0x9400: "Temperature",
+	0x9401: "Humidity",
+	0x9402: "Pressure",
+	0x9403: "WaterDepth",
+	0x9404: "Acceleration",
+	0x9405: "CameraElevationAngle",
+	0xA000: "FlashpixVersion",
+	0xA001: "ColorSpace",
+	0xA002: "PixelXDimension",
+	0xA003: "PixelYDimension",
+	0xA004: "RelatedSoundFile",
+	0xA005: "InteroperabilityIFDPointer",
+	0xA20B: "FlashEnergy",
+	0xA20C: "SpatialFrequencyResponse",
+	0xA20E: "FocalPlaneXResolution",
+	0xA20F: "FocalPlaneYResolution",
+	0xA210: "FocalPlaneResolutionUnit",
+	0xA214: "SubjectLocation",
+	0xA215: "ExposureIndex",
+	0xA217: "SensingMethod",
+	0xA300: "FileSource",
+	0xA301: "SceneType",
+	0xA302: "CFAPattern",
+	0xA401: "CustomRendered",
+	0xA402: "ExposureMode",
+	0xA403: "WhiteBalance",
+	0xA404: "DigitalZoomRatio",
+	0xA405: "FocalLengthIn35mmFilm",
+	0xA406: "SceneCaptureType",
+	0xA407: "GainControl",
+	0xA408: "Contrast",
+	0xA409: "Saturation",
+	0xA40A: "Sharpness",
+	0xA40B: "DeviceSettingDescription",
+	0xA40C: "SubjectDistanceRange",
+	0xA420: "ImageUniqueID",
+	0xA430: "CameraOwnerName",
+	0xA431: "BodySerialNumber",
+	0xA432: "LensSpecification",
+	0xA433: "LensMake",
+	0xA434: "LensModel",
+	0xA435: "LensSerialNumber",
+	0xA500: "Gamma",
+}
+
+// gpsTagNames are the tags of the GPS IFD defined by EXIF 2.32.
+var gpsTagNames = map[uint16]string{
+	0x0000: "GPSVersionID",
+	0x0001: "GPSLatitudeRef",
+	0x0002: "GPSLatitude",
+	0x0003: "GPSLongitudeRef",
+	0x0004: "GPSLongitude",
+	0x0005: "GPSAltitudeRef",
+	0x0006: "GPSAltitude",
+	0x0007: "GPSTimeStamp",
+	0x0008: "GPSSatellites",
+	0x0009: "GPSStatus",
+	0x000A: "GPSMeasureMode",
+	0x000B: "GPSDOP",
+	0x000C: "GPSSpeedRef",
+	0x000D: "GPSSpeed",
+	0x000E: "GPSTrackRef",
+	0x000F: "GPSTrack",
+	0x0010: "GPSImgDirectionRef",
+	0x0011: "GPSImgDirection",
+	0x0012: "GPSMapDatum",
+	0x0013: "GPSDestLatitudeRef",
+	0x0014: "GPSDestLatitude",
+	0x0015: "GPSDestLongitudeRef",
+	0x0016: "GPSDestLongitude",
+	0x0017: "GPSDestBearingRef",
+	0x0018: "GPSDestBearing",
+	0x0019: "GPSDestDistanceRef",
+	0x001A: "GPSDestDistance",
+	0x001B: "GPSProcessingMethod",
+	0x001C: "GPSAreaInformation",
+	0x001D: "GPSDateStamp",
+	0x001E: "GPSDifferential",
+	0x001F: "GPSHPositioningError",
+}
+
+// interopTagNames are the tags of the Interoperability IFD.
+var interopTagNames = map[uint16]string{
+	0x0001: "InteroperabilityIndex",
+	0x0002: "InteroperabilityVersion",
+	0x1000: "RelatedImageFileFormat",
+	0x1001: "RelatedImageWidth",
+	0x1002: "RelatedImageLength",
+}
+
+var orientationNames = map[uint64]string{
+	1: "Horizontal (normal)",
+	2: "Mirror horizontal",
+	3: "Rotate 180",
+	4: "Mirror vertical",
+	5: "Mirror horizontal and rotate 270 CW",
+	6: "Rotate 90 CW",
+	7: "Mirror horizontal and rotate 90 CW",
+	8: "Rotate 270 CW",
+}
+
+var resolutionUnitNames = map[uint64]string{
+	1: "None",
+	2: "Inches",
+	3: "Centimeters",
+}
+
+var meteringModeNames = map[uint64]string{
+	0:   "Unknown",
+	1:   "Average",
+	2:   "Center-weighted average",
+	3:   "Spot",
+	4:   "Multi-spot",
+	5:   "Pattern",
+	6:   "Partial",
+	255: "Other",
+}
+
+var colorSpaceNames = map[uint64]string{
+	1:      "sRGB",
+	0xFFFF: "Uncalibrated",
+}
+
+// TagName returns the name of tag id in an IFD of kind.  It returns the hexadecimal ID, such as "0x010F", if the
+// tag is unknown.
+func TagName(kind IFDKind, id uint16) string {
+	var name string
+	var found bool
+	switch kind {
+	case GPSIFD:
+		name, found = gpsTagNames[id]
+	case InteropIFD:
+		name, found = interopTagNames[id]
+	case TIFFIFD, ExifIFD:
+		name, found = tiffTagNames[id]
+		if !found {
+			name, found = exifTagNames[id]
+		}
+	default:
+		name, found = makerNoteVendors[kind].Tags[id]
+	}
+	if !found {
+		return fmt.Sprintf("0x%04X", id)
+	}
+	return name
+}
+
+// InterpretTag returns a human-readable description of value, such as "Rotate 90 CW" for Orientation or "1/125 s" for
+// ExposureTime.  It returns false if the tag has no interpretation.
+func InterpretTag(kind IFDKind, id uint16, value Value) (string, bool) {
+	if kind != TIFFIFD && kind != ExifIFD {
+		return "", false
+	}
+	switch id {
+	case 0x0112:
+		return interpretEnum(orientationNames, value)
+	case 0x0128, 0xA210:
+		return interpretEnum(resolutionUnitNames, value)
+	case 0x829A:
+		return interpretExposureTime(value)
+	case 0x829D:
+		if f, ok := value.Float(0); ok && f > 0 {
+			return "f/" + strconv.FormatFloat(f, 'f', -1, 64), true
+		}
+	case 0x920A:
+		if f, ok := value.Float(0); ok {
+			return strconv.FormatFloat(f, 'f', -1, 64) + " mm", true
+		}
+	case 0x9207:
+		return interpretEnum(meteringModeNames, value)
+	case 0x9209:
+		if u, ok := value.Uint(0); ok {
+			return FlashDescription(u), true
+		}
+	case 0xA001:
+		return interpretEnum(colorSpaceNames, value)
+	}
+	return "", false
+}
+
+func interpretEnum(names map[uint64]string, value Value) (string, bool) {
+	u, ok := value.Uint(0)
+	if !ok {
+		return "", false
+	}
+	if name, found := names[u]; found {
+		return name, true
+	}
+	return fmt.Sprintf("Unknown (%d)", u), true
+}
+
+// interpretExposureTime shows short exposures as a fraction of a second, as cameras do.
+func interpretExposureTime(value Value) (string, bool) {
+	f, ok := value.Float(0)
+	if !ok || f <= 0 {
+		return "", false
+	}
+	if f < 0.25 {
+		return fmt.Sprintf("1/%d s", int64(math.Round(1/f))), true
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64) + " s", true
+}
+
+// FlashDescription describes the bits of the Flash tag: whether the flash fired, its return light, its mode and
+// red-eye reduction.
+func FlashDescription(flash uint64) string {
+	if flash&0x20 != 0 {
+		return "No flash function"
+	}
+	var parts []string
+	if flash&0x01 != 0 {
+		parts = append(parts, "Fired")
+	} else {
+		parts = append(parts, "Did not fire")
+	}
+	switch (flash >> 3) & 0x03 {
+	case 1:
+		parts = append(parts, "compulsory flash mode")
+	case 2:
+		parts = append(parts, "compulsory flash suppression")
+	case 3:
+		parts = append(parts, "auto mode")
+	}
+	switch (flash >> 1) & 0x03 {
+	case 2:
+		parts = append(parts, "return light not detected")
+	case 3:
+		parts = append(parts, "return light detected")
+	}
+	if flash&0x40 != 0 {
+		parts = append(parts, "red-eye reduction")
+	}
+	return strings.Join(parts, ", ")
+}
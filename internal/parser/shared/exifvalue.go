@@ -0,0 +1,411 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// TIFF field types of IFD entries.
+const (
+	ByteType      uint16 = 1
+	ASCIIType     uint16 = 2
+	ShortType     uint16 = 3
+	LongType      uint16 = 4
+	RationalType  uint16 = 5
+	SByteType     uint16 = 6
+	UndefinedType uint16 = 7
+	SShortType    uint16 = 8
+	SLongType     uint16 = 9
+	SRationalType uint16 = 10
+	FloatType     uint16 = 11
+	DoubleType    uint16 = 12
+	IFDType       uint16 = 13
+	UTF8Type      uint16 = 129
+)
+
+type Rational struct {
+	Numerator   uint32 `json:"numerator"`
+	Denominator uint32 `json:"denominator"`
+}
+
+func (r Rational) Float() float64 {
+	if r.Denominator == 0 {
+		return 0
+	}
+	return float64(r.Numerator) / float64(r.Denominator)
+}
+
+func (r Rational) String() string {
+	if r.Denominator == 1 {
+		return strconv.FormatUint(uint64(r.Numerator), 10)
+	}
+	return fmt.Sprintf("%d/%d", r.Numerator, r.Denominator)
+}
+
+type SRational struct {
+	Numerator   int32 `json:"numerator"`
+	Denominator int32 `json:"denominator"`
+}
+
+func (r SRational) Float() float64 {
+	if r.Denominator == 0 {
+		return 0
+	}
+	return float64(r.Numerator) / float64(r.Denominator)
+}
+
+func (r SRational) String() string {
+	if r.Denominator == 1 {
+		return strconv.FormatInt(int64(r.Numerator), 10)
+	}
+	return fmt.Sprintf("%d/%d", r.Numerator, r.Denominator)
+}
+
+// Value is the decoded value of an IFD entry.  Data is a string for ASCII and UTF-8 values, []byte for BYTE and
+// UNDEFINED values and a slice for other types, such as []uint16 for SHORT, []Rational for RATIONAL or []uint32 for
+// the offsets of IFD values.
+type Value struct {
+	Type  uint16
+	Count uint32
+	Data  any
+	// Offset is the position of values larger than 4 bytes, relative to the TIFF header.  It is 0 for values stored
+	// in their entry.
+	Offset uint32
+}
+
+// validType returns true if values of tagType can be decoded.
+func validType(tagType uint16) bool {
+	return (tagType >= ByteType && tagType <= IFDType) || tagType == UTF8Type
+}
+
+// DecodeValue decodes count values of tagType from data, which holds exactly the bytes of the values.
+func DecodeValue(tagType uint16, count uint32, data []byte, byteOrder ByteOrder) Value {
+	result := Value{Type: tagType, Count: count}
+	size := typeSize(tagType)
+	n := len(data) / size
+	switch tagType {
+	case ASCIIType, UTF8Type:
+		result.Data = strings.TrimRight(string(data), "\x00")
+	case ByteType, UndefinedType:
+		result.Data = data
+	case SByteType:
+		values := make([]int8, n)
+		for i := range values {
+			values[i] = int8(data[i])
+		}
+		result.Data = values
+	case ShortType:
+		values := make([]uint16, n)
+		for i := range values {
+			values[i] = byteOrder.getUint16(data[i*2:])
+		}
+		result.Data = values
+	case SShortType:
+		values := make([]int16, n)
+		for i := range values {
+			values[i] = int16(byteOrder.getUint16(data[i*2:]))
+		}
+		result.Data = values
+	case LongType, IFDType:
+		values := make([]uint32, n)
+		for i := range values {
+			values[i] = byteOrder.getUint32(data[i*4:])
+		}
+		result.Data = values
+	case SLongType:
+		values := make([]int32, n)
+		for i := range values {
+			values[i] = int32(byteOrder.getUint32(data[i*4:]))
+		}
+		result.Data = values
+	case RationalType:
+		values := make([]Rational, n)
+		for i := range values {
+			values[i] = Rational{byteOrder.getUint32(data[i*8:]), byteOrder.getUint32(data[i*8+4:])}
+		}
+		result.Data = values
+	case SRationalType:
+		values := make([]SRational, n)
+		for i := range values {
+			values[i] = SRational{int32(byteOrder.getUint32(data[i*8:])), int32(byteOrder.getUint32(data[i*8+4:]))}
+		}
+		result.Data = values
+	case FloatType:
+		values := make([]float32, n)
+		for i := range values {
+			values[i] = math.Float32frombits(byteOrder.getUint32(data[i*4:]))
+		}
+		result.Data = values
+	case DoubleType:
+		values := make([]float64, n)
+		for i := range values {
+			values[i] = math.Float64frombits(byteOrder.getUint64(data[i*8:]))
+		}
+		result.Data = values
+	}
+	return result
+}
+
+// ParseValue parses text as values of tagType.  Numbers are separated by commas, as shown by String, and rationals
+// are written as "1/160" or as a decimal number.
+func ParseValue(tagType uint16, text string) (Value, error) {
+	result := Value{Type: tagType}
+	switch tagType {
+	case ASCIIType, UTF8Type:
+		result.Data = text
+		result.Count = uint32(len(text) + 1)
+		return result, nil
+	case UndefinedType:
+		result.Data = []byte(text)
+		result.Count = uint32(len(text))
+		return result, nil
+	}
+	parts := strings.Split(text, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	var values any
+	switch tagType {
+	case ByteType:
+		values = make([]byte, len(parts))
+	case SByteType:
+		values = make([]int8, len(parts))
+	case ShortType:
+		values = make([]uint16, len(parts))
+	case SShortType:
+		values = make([]int16, len(parts))
+	case LongType:
+		values = make([]uint32, len(parts))
+	case SLongType:
+		values = make([]int32, len(parts))
+	case RationalType:
+		values = make([]Rational, len(parts))
+	case SRationalType:
+		values = make([]SRational, len(parts))
+	case FloatType:
+		values = make([]float32, len(parts))
+	case DoubleType:
+		values = make([]float64, len(parts))
+	default:
+		return Value{}, fmt.Errorf("unsupported type %d", tagType)
+	}
+	for i, p := range parts {
+		err := parseItem(values, i, p)
+		if err != nil {
+			return Value{}, fmt.Errorf("invalid value %q: %w", text, err)
+		}
+	}
+	result.Data = values
+	result.Count = uint32(len(parts))
+	return result, nil
+}
+
+// parseItem parses text into values[i], where values is a slice created by ParseValue.
+func parseItem(values any, i int, text string) error {
+	switch values := values.(type) {
+	case []byte:
+		u, err := strconv.ParseUint(text, 10, 8)
+		values[i] = byte(u)
+		return err
+	case []int8:
+		n, err := strconv.ParseInt(text, 10, 8)
+		values[i] = int8(n)
+		return err
+	case []uint16:
+		u, err := strconv.ParseUint(text, 10, 16)
+		values[i] = uint16(u)
+		return err
+	case []int16:
+		n, err := strconv.ParseInt(text, 10, 16)
+		values[i] = int16(n)
+		return err
+	case []uint32:
+		u, err := strconv.ParseUint(text, 10, 32)
+		values[i] = uint32(u)
+		return err
+	case []int32:
+		n, err := strconv.ParseInt(text, 10, 32)
+		values[i] = int32(n)
+		return err
+	case []Rational:
+		n, d, err := parseFraction(text)
+		if err != nil {
+			return err
+		}
+		if n < 0 || n > math.MaxUint32 || d > math.MaxUint32 {
+			return fmt.Errorf("%s is out of range", text)
+		}
+		values[i] = Rational{uint32(n), uint32(d)}
+	case []SRational:
+		n, d, err := parseFraction(text)
+		if err != nil {
+			return err
+		}
+		if n < math.MinInt32 || n > math.MaxInt32 || d > math.MaxInt32 {
+			return fmt.Errorf("%s is out of range", text)
+		}
+		values[i] = SRational{int32(n), int32(d)}
+	case []float32:
+		f, err := strconv.ParseFloat(text, 32)
+		values[i] = float32(f)
+		return err
+	case []float64:
+		f, err := strconv.ParseFloat(text, 64)
+		values[i] = f
+		return err
+	}
+	return nil
+}
+
+// parseFraction parses "n/d" or a decimal number, which is stored with 4 decimal places.
+func parseFraction(text string) (int64, int64, error) {
+	if n, d, found := strings.Cut(text, "/"); found {
+		numerator, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		denominator, err := strconv.ParseInt(d, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		if denominator <= 0 {
+			return 0, 0, fmt.Errorf("invalid denominator in %s", text)
+		}
+		return numerator, denominator, nil
+	}
+	if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return n, 1, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if math.IsNaN(f) || math.Abs(f) > math.MaxInt32/10000 {
+		return 0, 0, fmt.Errorf("%s is out of range", text)
+	}
+	return int64(math.Round(f * 10000)), 10000, nil
+}
+
+// Uint returns the value at index as an unsigned integer.  It returns false if the value isn't an unsigned integer.
+func (v Value) Uint(index int) (uint64, bool) {
+	switch data := v.Data.(type) {
+	case []byte:
+		if v.Type == ByteType && index < len(data) {
+			return uint64(data[index]), true
+		}
+	case []uint16:
+		if index < len(data) {
+			return uint64(data[index]), true
+		}
+	case []uint32:
+		if index < len(data) {
+			return uint64(data[index]), true
+		}
+	}
+	return 0, false
+}
+
+// Float returns the numeric value at index, including rationals, as a float.  It returns false if the value isn't
+// a number.
+func (v Value) Float(index int) (float64, bool) {
+	if u, ok := v.Uint(index); ok {
+		return float64(u), true
+	}
+	switch data := v.Data.(type) {
+	case []int8:
+		if index < len(data) {
+			return float64(data[index]), true
+		}
+	case []int16:
+		if index < len(data) {
+			return float64(data[index]), true
+		}
+	case []int32:
+		if index < len(data) {
+			return float64(data[index]), true
+		}
+	case []Rational:
+		if index < len(data) {
+			return data[index].Float(), true
+		}
+	case []SRational:
+		if index < len(data) {
+			return data[index].Float(), true
+		}
+	case []float32:
+		if index < len(data) {
+			return float64(data[index]), true
+		}
+	case []float64:
+		if index < len(data) {
+			return data[index], true
+		}
+	}
+	return 0, false
+}
+
+// String formats the value for display.  Arrays are separated by commas and binary values are shown as text if they
+// are printable.
+func (v Value) String() string {
+	switch data := v.Data.(type) {
+	case string:
+		return data
+	case []byte:
+		if text := strings.TrimRight(string(data), "\x00"); text != "" && isPrintable(text) {
+			return text
+		}
+		if len(data) > 32 {
+			return fmt.Sprintf("%d bytes", len(data))
+		}
+		return fmt.Sprintf("% X", data)
+	case []float32:
+		values := make([]string, len(data))
+		for i, f := range data {
+			values[i] = strconv.FormatFloat(float64(f), 'g', -1, 32)
+		}
+		return strings.Join(values, ", ")
+	case nil:
+		return ""
+	}
+	text := fmt.Sprint(v.Data)
+	return strings.ReplaceAll(strings.Trim(text, "[]"), " ", ", ")
+}
+
+func isPrintable(text string) bool {
+	for _, c := range text {
+		if c < 0x20 || c > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalJSON writes text as a string, a single number as a number and other values as arrays.
+func (v Value) MarshalJSON() ([]byte, error) {
+	switch data := v.Data.(type) {
+	case string:
+		return json.Marshal(data)
+	case []byte:
+		return json.Marshal(v.String())
+	}
+	if v.Count == 1 {
+		switch data := v.Data.(type) {
+		case []Rational:
+			if len(data) == 1 {
+				return json.Marshal(data[0])
+			}
+		case []SRational:
+			if len(data) == 1 {
+				return json.Marshal(data[0])
+			}
+		default:
+			if f, ok := v.Float(0); ok {
+				return json.Marshal(f)
+			}
+		}
+	}
+	return json.Marshal(v.Data)
+}
@@ -0,0 +1,488 @@
+package shared
+
+import (
+	"fmt"
+	"jch-metadata/internal/parser"
+	"math"
+	"sort"
+	"strings"
+)
+
+// EncodeExif serializes ifds, as returned by ParseExif, into EXIF data with the "Exif\0\0" prefix.  TIFF IFDs form
+// the IFD chain and every Exif IFD is linked from the TIFF IFD before it.  The GPS IFD is linked from IFD0 and the
+// Interoperability IFD from the first Exif IFD, as required by the EXIF specification.  MakerNote IFDs are skipped
+// since the MakerNote is written as the value of its tag.  Offsets, pointer tags and the location of thumbnails are
+// recomputed, and empty IFDs other than IFD0 are left out.
+func EncodeExif(ifds []IFD, byteOrder ByteOrder) ([]byte, error) {
+	e := exifEncoder{byteOrder: byteOrder, order: byteOrder.binaryOrder()}
+	var chain []*exifNode
+	var gps, interop *exifNode
+	var firstExif *exifNode
+	for i := range ifds {
+		node := &exifNode{ifd: &ifds[i]}
+		switch ifds[i].Kind {
+		case TIFFIFD:
+			chain = append(chain, node)
+		case ExifIFD:
+			if len(chain) == 0 {
+				chain = append(chain, &exifNode{ifd: &IFD{Kind: TIFFIFD}})
+			}
+			parent := chain[len(chain)-1]
+			parent.children = append(parent.children, exifLink{0x8769, node})
+			if firstExif == nil {
+				firstExif = node
+			}
+		case GPSIFD:
+			gps = node
+		case InteropIFD:
+			interop = node
+		}
+	}
+	if len(chain) == 0 {
+		chain = append(chain, &exifNode{ifd: &IFD{Kind: TIFFIFD}})
+	}
+	if firstExif != nil && interop != nil {
+		firstExif.children = append(firstExif.children, exifLink{0xA005, interop})
+	}
+	if gps != nil {
+		chain[0].children = append(chain[0].children, exifLink{0x8825, gps})
+	}
+	e.cameraMake = chain[0].ifd.Tags[0x010F].String()
+
+	e.buf = append([]byte(ExifHeader), byteOrder.byteOrder...)
+	e.buf = e.order.AppendUint16(e.buf, 0x002A)
+	e.buf = e.order.AppendUint32(e.buf, 8)
+	next := len(ExifHeader) + 4
+	for i, node := range chain {
+		if i > 0 && node.empty() {
+			continue
+		}
+		e.putUint32(next, e.offset())
+		var err error
+		next, err = e.writeNode(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return e.buf, nil
+}
+
+// exifNode is an IFD with the IFDs it links to.
+type exifNode struct {
+	ifd      *IFD
+	children []exifLink
+}
+
+type exifLink struct {
+	tagId uint16
+	node  *exifNode
+}
+
+// empty returns true if the IFD and the IFDs it links to have no tags.
+func (n *exifNode) empty() bool {
+	for id, t := range n.ifd.Tags {
+		if _, pointer := ifdPointers[id]; !pointer && t.Data != nil {
+			return false
+		}
+	}
+	for _, c := range n.children {
+		if !c.node.empty() {
+			return false
+		}
+	}
+	return true
+}
+
+type exifEncoder struct {
+	byteOrder  ByteOrder
+	order      binaryOrder
+	buf        []byte
+	cameraMake string
+}
+
+// offset returns the position of the end of the data, relative to the TIFF header.
+func (e *exifEncoder) offset() uint32 {
+	return uint32(len(e.buf) - len(ExifHeader))
+}
+
+func (e *exifEncoder) putUint32(position int, value uint32) {
+	e.order.PutUint32(e.buf[position:position+4], value)
+}
+
+// align pads the data to a word boundary, since values must start at an even offset.
+func (e *exifEncoder) align() {
+	if e.offset()%2 == 1 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+// writeNode writes the IFD of node, its values, the IFDs it links to and its image data.  It returns the position
+// of the offset of the next IFD.
+func (e *exifEncoder) writeNode(node *exifNode) (int, error) {
+	tags := make(map[uint16]Value, len(node.ifd.Tags))
+	for id, value := range node.ifd.Tags {
+		if _, pointer := ifdPointers[id]; !pointer && value.Data != nil {
+			tags[id] = value
+		}
+	}
+	var children []exifLink
+	for _, c := range node.children {
+		if !c.node.empty() {
+			children = append(children, c)
+			tags[c.tagId] = Value{Type: LongType, Count: 1, Data: []uint32{0}}
+		}
+	}
+	delete(tags, 0x0201)
+	delete(tags, 0x0202)
+	if node.ifd.Thumbnail != nil {
+		tags[0x0201] = Value{Type: LongType, Count: 1, Data: []uint32{0}}
+		tags[0x0202] = Value{Type: LongType, Count: 1, Data: []uint32{uint32(len(node.ifd.Thumbnail))}}
+	}
+	delete(tags, 0x0111)
+	delete(tags, 0x0117)
+	if len(node.ifd.Strips) > 0 {
+		offsets := make([]uint32, len(node.ifd.Strips))
+		counts := make([]uint32, len(node.ifd.Strips))
+		for i, s := range node.ifd.Strips {
+			counts[i] = uint32(len(s))
+		}
+		tags[0x0111] = Value{Type: LongType, Count: uint32(len(offsets)), Data: offsets}
+		tags[0x0117] = Value{Type: LongType, Count: uint32(len(counts)), Data: counts}
+	}
+
+	ids := make([]uint16, 0, len(tags))
+	for id := range tags {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	e.align()
+	start := len(e.buf)
+	e.buf = e.order.AppendUint16(e.buf, uint16(len(ids)))
+	e.buf = append(e.buf, make([]byte, len(ids)*12+4)...)
+	next := start + 2 + len(ids)*12
+	// values holds the position of the value of each tag, so pointers and image offsets can be updated
+	values := make(map[uint16]int, len(ids))
+	for i, id := range ids {
+		value := tags[id]
+		data, err := encodeValue(value, e.order)
+		if err != nil {
+			return 0, fmt.Errorf("can't encode tag 0x%04X: %w", id, err)
+		}
+		entry := start + 2 + i*12
+		count := uint32(len(data) / typeSize(value.Type))
+		e.order.PutUint16(e.buf[entry:entry+2], id)
+		e.order.PutUint16(e.buf[entry+2:entry+4], value.Type)
+		e.order.PutUint32(e.buf[entry+4:entry+8], count)
+		if len(data) <= 4 {
+			copy(e.buf[entry+8:entry+12], data)
+			values[id] = entry + 8
+			continue
+		}
+		e.align()
+		if id == 0x927C && value.Offset != 0 {
+			data = rebaseMakerNote(data, e.byteOrder, int64(e.offset())-int64(value.Offset), e.cameraMake)
+		}
+		e.putUint32(entry+8, e.offset())
+		values[id] = len(e.buf)
+		e.buf = append(e.buf, data...)
+	}
+	for _, c := range children {
+		e.putUint32(values[c.tagId], e.offset())
+		_, err := e.writeNode(c.node)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if node.ifd.Thumbnail != nil {
+		e.putUint32(values[0x0201], e.offset())
+		e.buf = append(e.buf, node.ifd.Thumbnail...)
+	}
+	for i, s := range node.ifd.Strips {
+		e.align()
+		e.putUint32(values[0x0111]+i*4, e.offset())
+		e.buf = append(e.buf, s...)
+	}
+	if len(e.buf)-len(ExifHeader) > math.MaxUint32 {
+		return 0, fmt.Errorf("EXIF data is too large")
+	}
+	return next, nil
+}
+
+// encodeValue returns the bytes of value in the given byte order.  Text is terminated by a NUL byte.
+func encodeValue(value Value, order binaryOrder) ([]byte, error) {
+	var result []byte
+	switch data := value.Data.(type) {
+	case string:
+		result = append([]byte(data), 0)
+	case []byte:
+		result = append(result, data...)
+	case []int8:
+		for _, v := range data {
+			result = append(result, byte(v))
+		}
+	case []uint16:
+		for _, v := range data {
+			result = order.AppendUint16(result, v)
+		}
+	case []int16:
+		for _, v := range data {
+			result = order.AppendUint16(result, uint16(v))
+		}
+	case []uint32:
+		for _, v := range data {
+			result = order.AppendUint32(result, v)
+		}
+	case []int32:
+		for _, v := range data {
+			result = order.AppendUint32(result, uint32(v))
+		}
+	case []Rational:
+		for _, v := range data {
+			result = order.AppendUint32(order.AppendUint32(result, v.Numerator), v.Denominator)
+		}
+	case []SRational:
+		for _, v := range data {
+			result = order.AppendUint32(order.AppendUint32(result, uint32(v.Numerator)), uint32(v.Denominator))
+		}
+	case []float32:
+		for _, v := range data {
+			result = order.AppendUint32(result, math.Float32bits(v))
+		}
+	case []float64:
+		for _, v := range data {
+			result = order.AppendUint64(result, math.Float64bits(v))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported value %T", value.Data)
+	}
+	if len(result)%typeSize(value.Type) != 0 {
+		return nil, fmt.Errorf("%d bytes doesn't match type %d", len(result), value.Type)
+	}
+	return result, nil
+}
+
+// ExifTagPath returns the name of tag id of ifds[i] used by selectors and by the set action, without the "exif."
+// prefix.  Tags of IFD0 and of the Exif IFD are named after the tag, such as "Software", and tags of the other IFDs
+// are prefixed with "thumbnail.", "gps." or "interop.".  The MakerNote tag is "makernote".
+func ExifTagPath(ifds []IFD, i int, id uint16) string {
+	name := TagName(ifds[i].Kind, id)
+	switch ifds[i].Kind {
+	case GPSIFD:
+		return "gps." + name
+	case InteropIFD:
+		return "interop." + name
+	case TIFFIFD:
+		for _, ifd := range ifds[:i] {
+			if ifd.Kind == TIFFIFD {
+				return "thumbnail." + name
+			}
+		}
+	case ExifIFD:
+		if id == 0x927C {
+			return "makernote"
+		}
+	}
+	return name
+}
+
+// RemoveExifTags removes the tags of EXIF data chosen by selector, such as "exif.Software", "exif.gps" or
+// "exif.thumbnail", and encodes the remaining tags.  format is the prefix of format specific selectors, such as
+// "jpeg" for "jpeg.exif.gps".  It returns the paths of the removed tags, and raw itself if nothing was removed.  The
+// returned data is nil if no tag is left.
+func RemoveExifTags(raw []byte, selector parser.Selector, format string) ([]byte, []string, error) {
+	ifds, err := ParseExif(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ifds == nil {
+		return raw, nil, nil
+	}
+	var removed []string
+	empty := true
+	for i := range ifds {
+		ifd := &ifds[i]
+		if _, found := makerNoteVendors[ifd.Kind]; found {
+			continue
+		}
+		ids := make([]uint16, 0, len(ifd.Tags))
+		for id := range ifd.Tags {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			// Pointers follow their IFD and lengths follow the offset of the image data
+			if _, pointer := ifdPointers[id]; pointer || (ifd.Kind == TIFFIFD && (id == 0x0202 || id == 0x0117)) {
+				continue
+			}
+			path := ExifTagPath(ifds, i, id)
+			if !selector.Removes("exif."+path, format+".exif."+path) {
+				continue
+			}
+			delete(ifd.Tags, id)
+			removed = append(removed, path)
+			if ifd.Kind == TIFFIFD && id == 0x0201 {
+				delete(ifd.Tags, 0x0202)
+				ifd.Thumbnail = nil
+			} else if ifd.Kind == TIFFIFD && id == 0x0111 {
+				delete(ifd.Tags, 0x0117)
+				ifd.Strips = nil
+			}
+		}
+		for id := range ifd.Tags {
+			if _, pointer := ifdPointers[id]; !pointer {
+				empty = false
+			}
+		}
+	}
+	if len(removed) == 0 {
+		return raw, nil, nil
+	}
+	if empty {
+		return nil, removed, nil
+	}
+	result, err := EncodeExif(ifds, ByteOrder{byteOrder: raw[6:8]})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, removed, nil
+}
+
+// newTagTypes are the types of the tags that can be added by SetExifTags.  Existing tags keep their type.
+var newTagTypes = map[uint16]uint16{
+	0x010E: ASCIIType,
+	0x010F: ASCIIType,
+	0x0110: ASCIIType,
+	0x0112: ShortType,
+	0x0131: ASCIIType,
+	0x0132: ASCIIType,
+	0x013B: ASCIIType,
+	0x8298: ASCIIType,
+	0x9003: ASCIIType,
+	0x9004: ASCIIType,
+	0x9010: ASCIIType,
+	0x9011: ASCIIType,
+	0x9012: ASCIIType,
+	0x9286: UndefinedType,
+	0xA420: ASCIIType,
+	0xA430: ASCIIType,
+	0xA431: ASCIIType,
+	0xA433: ASCIIType,
+	0xA434: ASCIIType,
+	0xA435: ASCIIType,
+}
+
+// SetExifTags writes fields to EXIF data and encodes it.  Keys are "exif." followed by the name of a tag of IFD0 or
+// of the Exif IFD, such as "exif.Artist", and an empty value removes the tag.  Values are parsed according to the
+// type of the existing tag.  raw can be nil to create EXIF data.  It returns the paths of the changed tags, without
+// the tags that already have the value of their field, and the returned data is nil if no tag is left.
+func SetExifTags(raw []byte, fields []parser.Field) ([]byte, []string, error) {
+	byteOrder := BigEndian
+	var ifds []IFD
+	if raw != nil {
+		var err error
+		ifds, err = ParseExif(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ifds == nil {
+			return nil, nil, fmt.Errorf("invalid EXIF data")
+		}
+		byteOrder = ByteOrder{byteOrder: raw[6:8]}
+	}
+	if len(ifds) == 0 || ifds[0].Kind != TIFFIFD {
+		ifds = append([]IFD{{Kind: TIFFIFD, Tags: make(map[uint16]Value)}}, ifds...)
+	}
+	exif := -1
+	for i := range ifds {
+		if ifds[i].Kind == ExifIFD {
+			exif = i
+			break
+		}
+	}
+
+	var changed []string
+	for _, f := range fields {
+		name := strings.TrimPrefix(f.Key, "exif.")
+		if name == f.Key {
+			return nil, nil, fmt.Errorf("unsupported field %s, EXIF fields start with exif.", f.Key)
+		}
+		id, kind, found := findTag(name)
+		if !found {
+			return nil, nil, fmt.Errorf("unknown EXIF tag %s", name)
+		}
+		if _, pointer := ifdPointers[id]; pointer || id == 0x0111 || id == 0x0117 || id == 0x0201 || id == 0x0202 || id == 0x927C {
+			return nil, nil, fmt.Errorf("EXIF tag %s can't be set", name)
+		}
+		target := 0
+		if kind == ExifIFD {
+			if exif < 0 && f.Value == "" {
+				continue
+			}
+			if exif < 0 {
+				// The Exif IFD follows IFD0
+				ifds = append(ifds[:1], append([]IFD{{Kind: ExifIFD, Tags: make(map[uint16]Value)}}, ifds[1:]...)...)
+				exif = 1
+			}
+			target = exif
+		}
+		tags := ifds[target].Tags
+		if f.Value == "" {
+			if _, found := tags[id]; found {
+				delete(tags, id)
+				changed = append(changed, name)
+			}
+			continue
+		}
+		tagType, found := newTagTypes[id]
+		if existing, exists := tags[id]; exists {
+			tagType = existing.Type
+		} else if !found {
+			return nil, nil, fmt.Errorf("EXIF tag %s can't be added", name)
+		}
+		value, err := ParseValue(tagType, f.Value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't set EXIF tag %s: %w", name, err)
+		}
+		if id == 0x9286 && tagType == UndefinedType {
+			// UserComment starts with the character code of the text
+			value.Data = append([]byte("ASCII\x00\x00\x00"), f.Value...)
+			value.Count += 8
+		}
+		if existing, exists := tags[id]; exists && existing.Type == value.Type && existing.Count == value.Count && existing.String() == value.String() {
+			continue
+		}
+		tags[id] = value
+		changed = append(changed, name)
+	}
+
+	for _, ifd := range ifds {
+		if _, found := makerNoteVendors[ifd.Kind]; found {
+			continue
+		}
+		for id := range ifd.Tags {
+			if _, pointer := ifdPointers[id]; !pointer {
+				result, err := EncodeExif(ifds, byteOrder)
+				if err != nil {
+					return nil, nil, err
+				}
+				return result, changed, nil
+			}
+		}
+	}
+	return nil, changed, nil
+}
+
+// findTag returns the ID of the TIFF or Exif tag called name and the kind of IFD it belongs to.
+func findTag(name string) (uint16, IFDKind, bool) {
+	for id, n := range tiffTagNames {
+		if strings.EqualFold(n, name) {
+			return id, TIFFIFD, true
+		}
+	}
+	for id, n := range exifTagNames {
+		if strings.EqualFold(n, name) {
+			return id, ExifIFD, true
+		}
+	}
+	return 0, "", false
+}
@@ -3,7 +3,7 @@ package shared
 import (
 	"bytes"
 	"encoding/binary"
-	"jch-metadata/internal/output"
+	"jch-metadata/internal/document"
 )
 
 func ParseICC(raw []byte) *Profile {
@@ -34,25 +34,26 @@ func ParseICC(raw []byte) *Profile {
 }
 
 type Profile struct {
-	CmmType            string
-	ProfileClass       string
-	PrimaryPlatform    string
-	DeviceManufacturer string
-	DeviceModel        string
-	ProfileCreator     string
-	Copyright          string
+	CmmType            string `json:"cmmType"`
+	ProfileClass       string `json:"profileClass"`
+	PrimaryPlatform    string `json:"primaryPlatform"`
+	DeviceManufacturer string `json:"deviceManufacturer"`
+	DeviceModel        string `json:"deviceModel"`
+	ProfileCreator     string `json:"profileCreator"`
+	Copyright          string `json:"copyright"`
 }
 
-func PrintICC(indented bool, profile *Profile) {
-	if profile == nil {
-		return
+func ICCSection(profile *Profile) *document.Section {
+	section := &document.Section{
+		Key:   "icc",
+		Title: "ICC Profile",
 	}
-	output.PrintHeader(indented, "ICC Profile")
-	output.PrintForm(indented, "CMM Type", profile.CmmType, 18)
-	output.PrintForm(indented, "Profile Class", profile.ProfileClass, 18)
-	output.PrintForm(indented, "Primary Platform", profile.PrimaryPlatform, 18)
-	output.PrintForm(indented, "Dev Manufacturer", profile.DeviceManufacturer, 18)
-	output.PrintForm(indented, "Dev Model", profile.DeviceModel, 18)
-	output.PrintForm(indented, "Profile Creator", profile.ProfileCreator, 18)
-	output.PrintForm(indented, "Copyright", profile.Copyright, 18)
+	section.AddString("cmmType", "CMM Type", profile.CmmType)
+	section.AddString("profileClass", "Profile Class", profile.ProfileClass)
+	section.AddString("primaryPlatform", "Primary Platform", profile.PrimaryPlatform)
+	section.AddString("deviceManufacturer", "Dev Manufacturer", profile.DeviceManufacturer)
+	section.AddString("deviceModel", "Dev Model", profile.DeviceModel)
+	section.AddString("profileCreator", "Profile Creator", profile.ProfileCreator)
+	section.AddString("copyright", "Copyright", profile.Copyright)
+	return section
 }
@@ -0,0 +1,324 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/binary"
+	"jch-metadata/internal/parser"
+	"math"
+	"sort"
+	"strings"
+)
+
+// IFD kinds of the MakerNote of each vendor.  Their sections are named after the kind, such as
+// "exif.makernote.canon", so they can be selected with "exif.makernote".
+const (
+	CanonMakerNote            IFDKind = "makernote.canon"
+	NikonMakerNote            IFDKind = "makernote.nikon"
+	SonyMakerNote             IFDKind = "makernote.sony"
+	FujifilmMakerNote         IFDKind = "makernote.fujifilm"
+	OlympusMakerNote          IFDKind = "makernote.olympus"
+	OlympusEquipmentMakerNote IFDKind = "makernote.olympus.equipment"
+	PanasonicMakerNote        IFDKind = "makernote.panasonic"
+	AppleMakerNote            IFDKind = "makernote.apple"
+)
+
+// makerNoteVendor describes the tags of a MakerNote IFD.  Only these tags are shown, since MakerNotes have many
+// undocumented binary values.
+type makerNoteVendor struct {
+	Title string
+	Tags  map[uint16]string
+	// Identifying are the tags that identify the camera or its owner, with their audit category.
+	Identifying map[uint16]string
+}
+
+var makerNoteVendors = map[IFDKind]makerNoteVendor{
+	CanonMakerNote: {
+		Title: "Canon MakerNote",
+		Tags: map[uint16]string{
+			0x0006: "ImageType",
+			0x0007: "FirmwareVersion",
+			0x0008: "FileNumber",
+			0x0009: "OwnerName",
+			0x000C: "SerialNumber",
+			0x0010: "CanonModelID",
+			0x0095: "LensModel",
+			0x0096: "InternalSerialNumber",
+		},
+		Identifying: map[uint16]string{
+			0x0009: parser.PersonCategory,
+			0x000C: parser.SerialCategory,
+			0x0096: parser.SerialCategory,
+		},
+	},
+	NikonMakerNote: {
+		Title: "Nikon MakerNote",
+		Tags: map[uint16]string{
+			0x0001: "MakerNoteVersion",
+			0x0002: "ISO",
+			0x0004: "Quality",
+			0x0005: "WhiteBalance",
+			0x001D: "SerialNumber",
+			0x0083: "LensType",
+			0x0084: "Lens",
+			0x00A7: "ShutterCount",
+		},
+		Identifying: map[uint16]string{
+			0x001D: parser.SerialCategory,
+			0x00A7: parser.SerialCategory,
+		},
+	},
+	SonyMakerNote: {
+		Title: "Sony MakerNote",
+		Tags: map[uint16]string{
+			0x0102: "Quality",
+			0x2031: "SerialNumber",
+			0xB001: "SonyModelID",
+			0xB020: "CreativeStyle",
+			0xB027: "LensType",
+		},
+		Identifying: map[uint16]string{
+			0x2031: parser.SerialCategory,
+		},
+	},
+	FujifilmMakerNote: {
+		Title: "Fujifilm MakerNote",
+		Tags: map[uint16]string{
+			0x0000: "Version",
+			0x0010: "InternalSerialNumber",
+			0x1000: "Quality",
+			0x1438: "ImageCount",
+		},
+		Identifying: map[uint16]string{
+			0x0010: parser.SerialCategory,
+			0x1438: parser.SerialCategory,
+		},
+	},
+	OlympusMakerNote: {
+		Title: "Olympus MakerNote",
+		Tags: map[uint16]string{
+			0x0000: "MakerNoteVersion",
+			0x0207: "CameraType",
+			0x0209: "CameraID",
+			0x0404: "SerialNumber",
+			0x2010: "Equipment",
+		},
+		Identifying: map[uint16]string{
+			0x0404: parser.SerialCategory,
+		},
+	},
+	OlympusEquipmentMakerNote: {
+		Title: "Olympus Equipment MakerNote",
+		Tags: map[uint16]string{
+			0x0000: "EquipmentVersion",
+			0x0100: "CameraType2",
+			0x0101: "SerialNumber",
+			0x0102: "InternalSerialNumber",
+			0x0201: "LensType",
+			0x0202: "LensSerialNumber",
+			0x0203: "LensModel",
+		},
+		Identifying: map[uint16]string{
+			0x0101: parser.SerialCategory,
+			0x0102: parser.SerialCategory,
+			0x0202: parser.SerialCategory,
+		},
+	},
+	PanasonicMakerNote: {
+		Title: "Panasonic MakerNote",
+		Tags: map[uint16]string{
+			0x0001: "ImageQuality",
+			0x0002: "FirmwareVersion",
+			0x0025: "InternalSerialNumber",
+			0x0051: "LensType",
+			0x0052: "LensSerialNumber",
+			0x0053: "AccessoryType",
+			0x0054: "AccessorySerialNumber",
+		},
+		Identifying: map[uint16]string{
+			0x0025: parser.SerialCategory,
+			0x0052: parser.SerialCategory,
+			0x0054: parser.SerialCategory,
+		},
+	},
+	AppleMakerNote: {
+		Title: "Apple MakerNote",
+		Tags: map[uint16]string{
+			0x0001: "MakerNoteVersion",
+			0x000A: "HDRImageType",
+			0x000B: "BurstUUID",
+			0x0011: "ContentIdentifier",
+		},
+		Identifying: map[uint16]string{
+			0x000B: parser.SerialCategory,
+			0x0011: parser.SerialCategory,
+		},
+	},
+}
+
+// makerNoteLayout is the location of a MakerNote IFD.  Offsets in the IFD are relative to base, which is the TIFF
+// header of the EXIF data for most vendors, but the start of the MakerNote or its own TIFF header for others.
+type makerNoteLayout struct {
+	kind      IFDKind
+	base      int
+	ifdOffset uint32
+	byteOrder ByteOrder
+	// absolute is true if offsets are relative to the TIFF header of the EXIF data, so they change when the
+	// MakerNote is moved.  The IFD starts after header bytes.
+	absolute bool
+	header   int
+}
+
+// detectMakerNote returns the layout of the MakerNote that starts at start in raw.  Vendors that don't write a
+// header are recognized by the Make tag of IFD0.
+func detectMakerNote(raw []byte, byteOrder ByteOrder, start int, size int, cameraMake string) (makerNoteLayout, bool) {
+	note := raw[start : start+size]
+	tiffHeader := 6
+	relative := func(kind IFDKind, order []byte, ifdOffset uint32) (makerNoteLayout, bool) {
+		if len(order) != 2 || (string(order) != "II" && string(order) != "MM") {
+			return makerNoteLayout{}, false
+		}
+		return makerNoteLayout{kind: kind, base: start, ifdOffset: ifdOffset, byteOrder: ByteOrder{byteOrder: order}}, true
+	}
+	absolute := func(kind IFDKind, headerSize int) (makerNoteLayout, bool) {
+		return makerNoteLayout{kind: kind, base: tiffHeader, ifdOffset: uint32(start + headerSize - tiffHeader), byteOrder: byteOrder, absolute: true, header: headerSize}, true
+	}
+	switch {
+	case bytes.HasPrefix(note, []byte("Nikon\x00\x02")) && len(note) >= 18:
+		// Type 3 MakerNotes embed a TIFF header after the 10 bytes of the Nikon header
+		order := ByteOrder{byteOrder: note[10:12]}
+		if string(note[10:12]) != "II" && string(note[10:12]) != "MM" {
+			return makerNoteLayout{}, false
+		}
+		return makerNoteLayout{kind: NikonMakerNote, base: start + 10, ifdOffset: order.getUint32(note[14:18]), byteOrder: order}, true
+	case bytes.HasPrefix(note, []byte("Nikon\x00\x01")):
+		return absolute(NikonMakerNote, 8)
+	case bytes.HasPrefix(note, []byte("SONY DSC \x00\x00\x00")), bytes.HasPrefix(note, []byte("SONY CAM \x00\x00\x00")):
+		return absolute(SonyMakerNote, 12)
+	case bytes.HasPrefix(note, []byte("FUJIFILM")) && len(note) >= 12:
+		return relative(FujifilmMakerNote, []byte("II"), binary.LittleEndian.Uint32(note[8:12]))
+	case bytes.HasPrefix(note, []byte("OLYMPUS\x00")) && len(note) >= 12:
+		return relative(OlympusMakerNote, note[8:10], 12)
+	case bytes.HasPrefix(note, []byte("OM SYSTEM\x00\x00\x00")) && len(note) >= 16:
+		return relative(OlympusMakerNote, note[12:14], 16)
+	case bytes.HasPrefix(note, []byte("OLYMP\x00")):
+		return absolute(OlympusMakerNote, 8)
+	case bytes.HasPrefix(note, []byte("Panasonic\x00\x00\x00")):
+		return absolute(PanasonicMakerNote, 12)
+	case bytes.HasPrefix(note, []byte("Apple iOS\x00")) && len(note) >= 14:
+		return relative(AppleMakerNote, note[12:14], 14)
+	case strings.HasPrefix(strings.ToUpper(cameraMake), "CANON"):
+		return absolute(CanonMakerNote, 0)
+	case strings.HasPrefix(strings.ToUpper(cameraMake), "SONY"):
+		return absolute(SonyMakerNote, 0)
+	}
+	return makerNoteLayout{}, false
+}
+
+// parseMakerNote returns the IFDs of the MakerNote in the Exif IFD exif.  The offsets of the returned IFDs are
+// relative to the base of the MakerNote.
+func parseMakerNote(raw []byte, byteOrder ByteOrder, exif IFD, cameraMake string) []IFD {
+	start, size := makerNoteLocation(raw, byteOrder, int(exif.StartOffset)+6)
+	if size == 0 {
+		return nil
+	}
+	layout, found := detectMakerNote(raw, byteOrder, start, size, cameraMake)
+	if !found || layout.base < 6 {
+		return nil
+	}
+	// ParseIFD expects the 6 bytes of the "Exif\0\0" prefix before the base of the offsets
+	base := raw[layout.base-6:]
+	ifd := IFD{StartOffset: layout.ifdOffset, Kind: layout.kind}
+	_, _ = ParseIFD(&ifd, base, layout.byteOrder)
+	result := []IFD{ifd}
+	if layout.kind == OlympusMakerNote {
+		if offset, ok := ifd.Tags[0x2010].Uint(0); ok && uint32(offset) != ifd.StartOffset {
+			equipment := IFD{StartOffset: uint32(offset), Kind: OlympusEquipmentMakerNote}
+			_, _ = ParseIFD(&equipment, base, layout.byteOrder)
+			result = append(result, equipment)
+		}
+	}
+	return result
+}
+
+// makerNoteLocation returns the position and size of the MakerNote value in the Exif IFD at offset.  The size is 0 if
+// there is no MakerNote.
+func makerNoteLocation(raw []byte, byteOrder ByteOrder, offset int) (int, int) {
+	entry := findEntry(raw, byteOrder, offset, 0x927C)
+	if entry < 0 {
+		return 0, 0
+	}
+	size := int64(byteOrder.getUint32(raw[entry+4:entry+8])) * int64(typeSize(byteOrder.getUint16(raw[entry+2:entry+4])))
+	start := int64(byteOrder.getUint32(raw[entry+8:entry+12])) + 6
+	if size <= 4 || start+size > int64(len(raw)) {
+		return 0, 0
+	}
+	return int(start), int(size)
+}
+
+// findEntry returns the position of the entry of tagId in the IFD at offset, or -1 if it isn't found.
+func findEntry(raw []byte, byteOrder ByteOrder, offset int, tagId uint16) int {
+	if offset < 6 || offset+2 > len(raw) {
+		return -1
+	}
+	numberOfTags := int(byteOrder.getUint16(raw[offset : offset+2]))
+	for c := 0; c < numberOfTags; c++ {
+		entry := offset + 2 + c*12
+		if entry+12 > len(raw) {
+			return -1
+		}
+		if byteOrder.getUint16(raw[entry:entry+2]) == tagId {
+			return entry
+		}
+	}
+	return -1
+}
+
+// rebaseMakerNote returns a copy of the MakerNote note moved by delta bytes.  Offsets of MakerNotes that are relative
+// to the TIFF header are adjusted, other MakerNotes don't depend on their position.
+func rebaseMakerNote(note []byte, byteOrder ByteOrder, delta int64, cameraMake string) []byte {
+	result := append([]byte(nil), note...)
+	layout, found := detectMakerNote(result, byteOrder, 0, len(result), cameraMake)
+	if !found || !layout.absolute || delta == 0 || layout.header+2 > len(result) {
+		return result
+	}
+	numberOfTags := int(byteOrder.getUint16(result[layout.header : layout.header+2]))
+	for c := 0; c < numberOfTags; c++ {
+		entry := layout.header + 2 + c*12
+		if entry+12 > len(result) {
+			break
+		}
+		size := int64(byteOrder.getUint32(result[entry+4:entry+8])) * int64(typeSize(byteOrder.getUint16(result[entry+2:entry+4])))
+		offset := int64(byteOrder.getUint32(result[entry+8:entry+12])) + delta
+		// Values of 4 bytes or less are stored in the entry, except for the offsets of nested IFDs
+		if (size > 4 || byteOrder.getUint16(result[entry+2:entry+4]) == IFDType) && offset >= 0 && offset <= math.MaxUint32 {
+			byteOrder.binaryOrder().PutUint32(result[entry+8:entry+12], uint32(offset))
+		}
+	}
+	return result
+}
+
+// auditMakerNote returns the identifying values of a MakerNote IFD.
+func auditMakerNote(ifd IFD) []parser.Finding {
+	vendor, found := makerNoteVendors[ifd.Kind]
+	if !found {
+		return nil
+	}
+	var tags []uint16
+	for t := range vendor.Identifying {
+		tags = append(tags, t)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	var result []parser.Finding
+	for _, t := range tags {
+		value, found := ifd.Tags[t]
+		if !found {
+			continue
+		}
+		text := strings.TrimSpace(value.String())
+		if text == "" || text == "0" {
+			continue
+		}
+		result = append(result, parser.Finding{Category: vendor.Identifying[t], Source: "EXIF " + vendor.Title + " " + vendor.Tags[t], Value: text})
+	}
+	return result
+}
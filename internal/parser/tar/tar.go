@@ -0,0 +1,173 @@
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"jch-metadata/internal/document"
+	"jch-metadata/internal/parser"
+	"time"
+)
+
+var Parser = parser.Parser{
+	Name:      "TAR",
+	Container: true,
+	Support: func(r io.ReaderAt, size int64) (bool, error) {
+		return IsTar(r, size)
+	},
+	Handle: func(input *parser.Input, action parser.Action, parsers []parser.Parser, options parser.Options) (*document.Document, error) {
+		if action == parser.ShowAction {
+			entries, err := GetEntries(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			doc := &document.Document{}
+			for i, e := range entries {
+				err = e.Describe(doc, input, parsers, options, i)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return doc, nil
+		}
+		return nil, fmt.Errorf("%w for TAR archives: %s", parser.ErrUnsupportedAction, action)
+	},
+	Items: func(input *parser.Input) ([]*parser.Input, error) {
+		entries, err := GetEntries(input.Reader, input.Size)
+		if err != nil {
+			return nil, err
+		}
+		var result []*parser.Input
+		for i, e := range entries {
+			if item := e.Item(input, i); item != nil {
+				result = append(result, item)
+			}
+		}
+		return result, nil
+	},
+}
+
+// IsTar checks for the magic of POSIX and GNU archives at offset 257 of the first header.
+func IsTar(r io.ReaderAt, size int64) (bool, error) {
+	if size < 512 {
+		return false, nil
+	}
+	magicBytes := make([]byte, 6)
+	_, err := r.ReadAt(magicBytes, 257)
+	if err != nil {
+		return false, fmt.Errorf("failed to read file: %w", err)
+	}
+	return string(magicBytes) == "ustar\x00" || string(magicBytes) == "ustar ", nil
+}
+
+type Entry struct {
+	Name       string
+	Type       string
+	LinkName   string
+	Mode       int64
+	UID        int
+	GID        int
+	Uname      string
+	Gname      string
+	ModTime    time.Time
+	AccessTime time.Time
+	ChangeTime time.Time
+	DataAt     int64
+	Size       int64
+}
+
+// GetEntries reads the headers of the archive.  PAX and GNU long name headers are merged into the entries they
+// describe.
+func GetEntries(r io.ReaderAt, size int64) ([]Entry, error) {
+	var result []Entry
+	reader := io.NewSectionReader(r, 0, size)
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading TAR header: %w", err)
+		}
+		// The data of the entry starts where the reader stopped after its header
+		offset, err := reader.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, Entry{
+			Name:       header.Name,
+			Type:       TypeName(header.Typeflag),
+			LinkName:   header.Linkname,
+			Mode:       header.Mode,
+			UID:        header.Uid,
+			GID:        header.Gid,
+			Uname:      header.Uname,
+			Gname:      header.Gname,
+			ModTime:    header.ModTime,
+			AccessTime: header.AccessTime,
+			ChangeTime: header.ChangeTime,
+			DataAt:     offset,
+			Size:       header.Size,
+		})
+	}
+	return result, nil
+}
+
+// Describe adds the header of an entry to doc.  The content of regular files is parsed into a nested document.
+func (e *Entry) Describe(doc *document.Document, input *parser.Input, parsers []parser.Parser, options parser.Options, index int) error {
+	entry := doc.AddSection(fmt.Sprintf("entry.%d", index), "Entry")
+	entry.AddString("name", "Name", e.Name)
+	entry.AddString("type", "Type", e.Type)
+	if e.LinkName != "" {
+		entry.AddString("linkName", "Link Name", e.LinkName)
+	}
+	entry.AddString("mode", "Mode", fmt.Sprintf("%04o", e.Mode))
+	entry.AddInt("uid", "UID", int64(e.UID))
+	entry.AddInt("gid", "GID", int64(e.GID))
+	entry.AddString("uname", "User Name", e.Uname)
+	entry.AddString("gname", "Group Name", e.Gname)
+	entry.AddTime("modTime", "Modification Time", e.ModTime)
+	if !e.AccessTime.IsZero() {
+		entry.AddTime("accessTime", "Access Time", e.AccessTime)
+	}
+	if !e.ChangeTime.IsZero() {
+		entry.AddTime("changeTime", "Change Time", e.ChangeTime)
+	}
+	entry.AddInt("size", "Size", e.Size)
+	item := e.Item(input, index)
+	if item == nil {
+		return nil
+	}
+	return parser.DescribeItem(entry, parsers, e.Item(input, index), parser.ShowAction, options)
+}
+
+// Item returns the content of the entry or nil if it isn't a regular file stored in the archive.
+func (e *Entry) Item(input *parser.Input, index int) *parser.Input {
+	if e.Type != TypeName(tar.TypeReg) || e.Size == 0 || e.DataAt+e.Size > input.Size {
+		return nil
+	}
+	return input.Item("entry", index, e.Name, e.DataAt, e.Size)
+}
+
+func TypeName(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeReg:
+		return "Regular File"
+	case tar.TypeLink:
+		return "Hard Link"
+	case tar.TypeSymlink:
+		return "Symbolic Link"
+	case tar.TypeChar:
+		return "Character Device"
+	case tar.TypeBlock:
+		return "Block Device"
+	case tar.TypeDir:
+		return "Directory"
+	case tar.TypeFifo:
+		return "FIFO"
+	case tar.TypeGNUSparse:
+		return "Sparse File"
+	}
+	return fmt.Sprintf("Unknown (%c)", typeflag)
+}
@@ -12,7 +12,7 @@ func TestIsELF(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := elf.IsELF(f, 0)
+	result, err := elf.IsELF(f)
 	if !result {
 		t.Fatalf("Result should be true")
 	}
@@ -23,7 +23,7 @@ func TestIsELF_Unsupported(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := elf.IsELF(f, 0)
+	result, err := elf.IsELF(f)
 	if result {
 		t.Fatalf("Result should be false")
 	}
@@ -0,0 +1,173 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"jch-metadata/internal/parser"
+	"jch-metadata/internal/parser/shared"
+	"math"
+	"testing"
+)
+
+func writeBigEndian(b *bytes.Buffer, values ...any) {
+	for _, v := range values {
+		_ = binary.Write(b, binary.BigEndian, v)
+	}
+}
+
+// exifWithGPS returns big endian EXIF data with a GPS IFD at 33°51'54.72" S 151°12'31.5" W, 10 m below sea level.
+func exifWithGPS() []byte {
+	var b bytes.Buffer
+	write := func(values ...any) { writeBigEndian(&b, values...) }
+	b.WriteString("Exif\x00\x00MM")
+	write(uint16(0x2A), uint32(8))
+	// IFD0 with the GPS IFD pointer, followed by the GPS IFD at offset 26 and its values at offset 104.
+	write(uint16(1), uint16(0x8825), uint16(4), uint32(1), uint32(26), uint32(0))
+	write(uint16(6))
+	write(uint16(0x0001), uint16(2), uint32(2), []byte{'S', 0, 0, 0})
+	write(uint16(0x0002), uint16(5), uint32(3), uint32(104))
+	write(uint16(0x0003), uint16(2), uint32(2), []byte{'W', 0, 0, 0})
+	write(uint16(0x0004), uint16(5), uint32(3), uint32(128))
+	write(uint16(0x0005), uint16(1), uint32(1), []byte{1, 0, 0, 0})
+	write(uint16(0x0006), uint16(5), uint32(1), uint32(152))
+	write(uint32(0))
+	write(uint32(33), uint32(1), uint32(51), uint32(1), uint32(5472), uint32(100))
+	write(uint32(151), uint32(1), uint32(12), uint32(1), uint32(315), uint32(10))
+	write(uint32(10), uint32(1))
+	return b.Bytes()
+}
+
+func TestDecodeGPS(t *testing.T) {
+	ifds, err := shared.ParseExif(exifWithGPS())
+	if err != nil {
+		t.Fatalf("Error parsing EXIF data: %s", err)
+	}
+	if len(ifds) != 2 || ifds[1].Kind != shared.GPSIFD {
+		t.Fatalf("Expected IFD0 and GPS IFD but received %d IFDs", len(ifds))
+	}
+	gps := shared.DecodeGPS(ifds[1])
+	if gps.Latitude == nil || math.Abs(*gps.Latitude+33.8652) > 1e-9 {
+		t.Fatalf("Unexpected latitude: %v", gps.Latitude)
+	}
+	if gps.Longitude == nil || math.Abs(*gps.Longitude+151.20875) > 1e-9 {
+		t.Fatalf("Unexpected longitude: %v", gps.Longitude)
+	}
+	if gps.Altitude == nil || *gps.Altitude != -10 {
+		t.Fatalf("Unexpected altitude: %v", gps.Altitude)
+	}
+	if gps.Time != nil || gps.Speed != nil || gps.Direction != nil {
+		t.Fatalf("Unexpected GPS fields: %+v", gps)
+	}
+	section := shared.ExifSections(ifds)[1]
+	if section.Key != "exif.gps" || section.FindField("latitude") == nil || section.FindField("0x0002").Label != "GPSLatitude" {
+		t.Fatalf("Unexpected GPS section: %+v", section)
+	}
+}
+
+// exifWithNikonMakerNote returns big endian EXIF data with a Nikon type 3 MakerNote, which has its own TIFF header.
+func exifWithNikonMakerNote() []byte {
+	var b bytes.Buffer
+	write := func(values ...any) { writeBigEndian(&b, values...) }
+	b.WriteString("Exif\x00\x00MM")
+	write(uint16(0x2A), uint32(8))
+	// IFD0 with Make at offset 38, the Exif IFD at offset 44 and the MakerNote at offset 62
+	write(uint16(2), uint16(0x010F), uint16(2), uint32(6), uint32(38), uint16(0x8769), uint16(4), uint32(1), uint32(44), uint32(0))
+	write([]byte("Nikon\x00"))
+	write(uint16(1), uint16(0x927C), uint16(7), uint32(56), uint32(62), uint32(0))
+	write([]byte("Nikon\x00\x02\x10\x00\x00MM"), uint16(0x2A), uint32(8))
+	write(uint16(2), uint16(0x001D), uint16(2), uint32(8), uint32(38), uint16(0x00A7), uint16(4), uint32(1), uint32(12345), uint32(0))
+	write([]byte("1234567\x00"))
+	return b.Bytes()
+}
+
+func TestNikonMakerNote(t *testing.T) {
+	raw := exifWithNikonMakerNote()
+	ifds, err := shared.ParseExif(raw)
+	if err != nil {
+		t.Fatalf("Error parsing EXIF data: %s", err)
+	}
+	if len(ifds) != 3 || ifds[2].Kind != shared.NikonMakerNote {
+		t.Fatalf("Expected a Nikon MakerNote IFD but received %d IFDs", len(ifds))
+	}
+	if serial := ifds[2].Tags[0x001D].String(); serial != "1234567" {
+		t.Fatalf("Unexpected serial number: %s", serial)
+	}
+	if count, _ := ifds[2].Tags[0x00A7].Uint(0); count != 12345 {
+		t.Fatalf("Unexpected shutter count: %d", count)
+	}
+	section := shared.ExifSections(ifds)[2]
+	if section.Key != "exif.makernote.nikon" || section.FindField("0x001D").Label != "SerialNumber" {
+		t.Fatalf("Unexpected MakerNote section: %+v", section)
+	}
+	findings, err := shared.AuditExif(raw)
+	if err != nil || len(findings) != 2 || findings[0].Source != "EXIF Nikon MakerNote SerialNumber" || findings[0].Value != "1234567" {
+		t.Fatalf("Unexpected findings: %v", findings)
+	}
+
+	selector := parser.Selector{Remove: []string{"exif.gps"}}
+	if _, removed, err := shared.RemoveExifTags(raw, selector, "jpeg"); err != nil || len(removed) != 0 {
+		t.Fatalf("Nothing should have been removed but received %v, %v", removed, err)
+	}
+	// The MakerNote has its own TIFF header, so it is kept as is when it moves
+	encoded, err := shared.EncodeExif(ifds, shared.BigEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifds, err = shared.ParseExif(encoded)
+	if err != nil || len(ifds) != 3 || ifds[2].Tags[0x001D].String() != "1234567" {
+		t.Fatalf("MakerNote should have been kept: %+v", ifds)
+	}
+	selector = parser.Selector{Remove: []string{"exif.makernote"}}
+	raw, removed, err := shared.RemoveExifTags(raw, selector, "jpeg")
+	if err != nil || len(removed) != 1 || removed[0] != "makernote" {
+		t.Fatalf("MakerNote should have been removed but received %v, %v", removed, err)
+	}
+	ifds, err = shared.ParseExif(raw)
+	if err != nil || len(ifds) != 1 {
+		t.Fatalf("MakerNote should have been removed")
+	}
+	if findings, err = shared.AuditExif(raw); err != nil || len(findings) != 0 {
+		t.Fatalf("Unexpected findings: %v, %v", findings, err)
+	}
+
+	raw[6], raw[7] = 'X', 'X'
+	if _, err = shared.ParseExif(raw); err == nil {
+		t.Fatalf("Parsing EXIF data with an invalid byte order should fail")
+	}
+	if _, err = shared.AuditExif(raw); err == nil {
+		t.Fatalf("Auditing EXIF data with an invalid byte order should fail")
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		tagType uint16
+		text    string
+		valid   bool
+		value   string
+	}{
+		{shared.ShortType, "1, 2, 3", true, "1, 2, 3"},
+		{shared.RationalType, "1/160, 7.1", true, "1/160, 71000/10000"},
+		{shared.SRationalType, "-1/3, 0", true, "-1/3, 0"},
+		{shared.ShortType, "abc, 5", false, ""},
+		{shared.ShortType, "70000, 5", false, ""},
+		{shared.ByteType, "256, 1", false, ""},
+		{shared.SLongType, "-1, x, 3", false, ""},
+		{shared.RationalType, "1/0, 5", false, ""},
+		{shared.RationalType, "-1, 5", false, ""},
+		{shared.SRationalType, "1/x, 5", false, ""},
+		{shared.DoubleType, "1.5, nope, 2", false, ""},
+	}
+	for _, test := range tests {
+		value, err := shared.ParseValue(test.tagType, test.text)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%q should be invalid but was parsed as %s", test.text, value)
+			}
+			continue
+		}
+		if err != nil || value.String() != test.value {
+			t.Errorf("Unexpected value for %q: %s, %v", test.text, value, err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"bytes"
 	"jch-metadata/internal/parser/flac"
 	"os"
 	"testing"
@@ -11,7 +12,7 @@ func TestIsFlac(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := flac.IsFLAC(f, 0)
+	result, err := flac.IsFLAC(f)
 	if !result {
 		t.Fatalf("Result should be true")
 	}
@@ -22,7 +23,7 @@ func TestIsFlac_Unsupported(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := flac.IsFLAC(f, 0)
+	result, err := flac.IsFLAC(f)
 	if result {
 		t.Fatalf("Result should be false")
 	}
@@ -33,7 +34,7 @@ func TestFlacMetadata(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	metadata, err := flac.GetMetadata(f, 0)
+	metadata, err := flac.GetMetadata(f)
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
@@ -88,3 +89,27 @@ func TestFlacMetadata(t *testing.T) {
 		t.Fatalf("Unexpected user comment: %s", vorbisComment.UserComment[0])
 	}
 }
+
+func TestFlacVerify(t *testing.T) {
+	data, err := os.ReadFile("internal/parser/test/test1.flac")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	// Make the first user comment longer than the Vorbis comment block
+	data[109] = 0xFF
+	problems, err := flac.Verify(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Error verifying file: %s", err)
+	}
+	if len(problems) != 1 || problems[0].Target != "VORBIS_COMMENT block" || problems[0].Offset != 64 {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+
+	problems, err = flac.Verify(bytes.NewReader(data[:1000]), 1000)
+	if err != nil {
+		t.Fatalf("Error verifying file: %s", err)
+	}
+	if len(problems) != 2 || problems[1].Target != "PADDING block" {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+}
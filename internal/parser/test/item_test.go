@@ -0,0 +1,95 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"jch-metadata/internal/parser"
+	"jch-metadata/internal/parser/flac"
+	"jch-metadata/internal/parser/jpeg"
+	"os"
+	"testing"
+)
+
+// flacWithPicture returns test1.flac with test1.jpeg as a front cover PICTURE block after STREAMINFO.
+func flacWithPicture(t *testing.T) []byte {
+	audio, err := os.ReadFile("internal/parser/test/test1.flac")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	cover, err := os.ReadFile("internal/parser/test/test1.jpeg")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	var picture bytes.Buffer
+	for _, v := range []any{uint32(3), uint32(10), []byte("image/jpeg"), uint32(5), []byte("cover"), uint32(160), uint32(120), uint32(24), uint32(0), uint32(len(cover)), cover} {
+		_ = binary.Write(&picture, binary.BigEndian, v)
+	}
+	streamInfoEnd := 8 + int(binary.BigEndian.Uint32([]byte{0, audio[5], audio[6], audio[7]}))
+	var result bytes.Buffer
+	result.Write(audio[:streamInfoEnd])
+	result.Write([]byte{6, byte(picture.Len() >> 16), byte(picture.Len() >> 8), byte(picture.Len())})
+	result.Write(picture.Bytes())
+	result.Write(audio[streamInfoEnd:])
+	return result.Bytes()
+}
+
+func TestNestedItems(t *testing.T) {
+	buffer := &parser.Buffer{Data: flacWithPicture(t)}
+	input := &parser.Input{Name: "test.flac", Reader: buffer, Size: int64(len(buffer.Data)), Writer: buffer}
+	parsers := []parser.Parser{flac.Parser, jpeg.Parser}
+	doc, err := parser.StartParsing(parsers, input, parser.ShowAction, parser.Options{})
+	if err != nil {
+		t.Fatalf("Error parsing file: %s", err)
+	}
+	picture := doc.FindSection("picture.0")
+	if picture.FindField("type").Value != "Front Cover" || picture.FindField("path").Value != "picture[0]" {
+		t.Fatalf("Unexpected picture: %v", picture.Fields)
+	}
+	if len(picture.Documents) != 1 || picture.Documents[0].Path != "test.flac!picture[0]" {
+		t.Fatalf("Picture should have been parsed as JPEG")
+	}
+	thumbnail := picture.Documents[0].FindSection("thumbnail")
+	if len(thumbnail.Documents) != 1 || thumbnail.Documents[0].Path != "test.flac!picture[0]!thumbnail[0]" {
+		t.Fatalf("Thumbnail should have been parsed as JPEG")
+	}
+
+	doc, err = parser.StartParsing(parsers, input, parser.ShowAction, parser.Options{MaxNesting: 1})
+	if err != nil {
+		t.Fatalf("Error parsing file: %s", err)
+	}
+	thumbnail = doc.FindSection("picture.0").Documents[0].FindSection("thumbnail")
+	if len(thumbnail.Documents) != 0 || thumbnail.FindField("content") == nil {
+		t.Fatalf("Thumbnail should be deeper than the maximum nesting level")
+	}
+
+	item, err := parser.ResolvePath(parsers, input, "picture[0]!thumbnail[0]")
+	if err != nil {
+		t.Fatalf("Error resolving path: %s", err)
+	}
+	if item.Size != 1378 || item.Depth != 2 {
+		t.Fatalf("Unexpected thumbnail size %d at depth %d", item.Size, item.Depth)
+	}
+	_, err = parser.ResolvePath(parsers, input, "picture[1]")
+	if err == nil {
+		t.Fatalf("Resolving a missing item should fail")
+	}
+
+	cover, err := parser.ResolvePath(parsers, input, "picture[0]")
+	if err != nil {
+		t.Fatalf("Error resolving path: %s", err)
+	}
+	_, err = parser.StartParsing(parsers, cover, parser.ClearAction, parser.Options{})
+	if err != nil {
+		t.Fatalf("Error clearing cover: %s", err)
+	}
+	if len(buffer.Data) != int(input.Size) {
+		t.Fatalf("Clearing a nested item shouldn't change the size of the file")
+	}
+	metadata, err := jpeg.ParseFile(cover.Reader, cover.Size)
+	if err != nil {
+		t.Fatalf("Error parsing picture: %s", err)
+	}
+	if len(metadata.IFDs) > 0 || metadata.ICCProfile != nil {
+		t.Fatalf("Metadata of the picture should have been cleared")
+	}
+}
@@ -2,7 +2,11 @@ package test
 
 import (
 	"bytes"
+	"jch-metadata/internal/document"
+	"jch-metadata/internal/parser"
 	"jch-metadata/internal/parser/jpeg"
+	"jch-metadata/internal/parser/png"
+	"jch-metadata/internal/parser/shared"
 	"os"
 	"testing"
 )
@@ -12,7 +16,7 @@ func TestIsJPEG(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := jpeg.IsJPEG(f, 0)
+	result, err := jpeg.IsJPEG(f)
 	if !result {
 		t.Fatalf("Result should be true")
 	}
@@ -23,7 +27,7 @@ func TestIsJPEG_Unsupported(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := jpeg.IsJPEG(f, 0)
+	result, err := jpeg.IsJPEG(f)
 	if result {
 		t.Fatalf("Result should be false")
 	}
@@ -34,7 +38,8 @@ func TestFindApplicationMarkers(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := jpeg.FindApplicationSegments(f, 0)
+	fileInfo, _ := f.Stat()
+	result, err := jpeg.FindApplicationSegments(f, fileInfo.Size())
 	if len(result) != 3 {
 		t.Fatalf("Unexpected result size: %d", len(result))
 	}
@@ -54,7 +59,8 @@ func TestParseJPEG(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := jpeg.ParseFile(f, 0)
+	fileInfo, _ := f.Stat()
+	result, err := jpeg.ParseFile(f, fileInfo.Size())
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
@@ -64,24 +70,30 @@ func TestParseJPEG(t *testing.T) {
 	if result.JFXXThumbnail {
 		t.Fatalf("Image doesn't have JFXX Thumbnail")
 	}
-	if len(result.IFDs) != 3 {
+	if len(result.IFDs) != 5 {
 		t.Fatalf("Unexpected number of IFDs: %d", len(result.IFDs))
 	}
 	if len(result.IFDs[0].Tags) != 10 {
 		t.Fatalf("Unexpected number of EXIF tags in IFD 0: %d", len(result.IFDs[0].Tags))
 	}
-	if len(result.IFDs[1].Tags) != 27 {
+	if len(result.IFDs[1].Tags) != 30 {
 		t.Fatalf("Unexpected number of EXIF tags in IFD 1: %d", len(result.IFDs[1].Tags))
 	}
 	if len(result.IFDs[2].Tags) != 6 {
 		t.Fatalf("Unexpected number of EXIF tags in IFD 2: %d", len(result.IFDs[2].Tags))
 	}
-	if result.IFDs[0].Tags[0x110] != "Canon EOS 40D" {
+	if result.IFDs[0].Tags[0x110].String() != "Canon EOS 40D" {
 		t.Fatalf("Unexpected value for tag ID: %s", result.IFDs[0].Tags[0x110])
 	}
-	if result.IFDs[0].Tags[0x131] != "GIMP 2.4.5" {
+	if result.IFDs[0].Tags[0x131].String() != "GIMP 2.4.5" {
 		t.Fatalf("Unexpected value for tag ID: %s", result.IFDs[0].Tags[0x131])
 	}
+	if result.IFDs[1].Tags[0x829A].String() != "1/160" {
+		t.Fatalf("Unexpected value for tag ID: %s", result.IFDs[1].Tags[0x829A])
+	}
+	if f, _ := result.IFDs[1].Tags[0x829D].Float(0); f != 7.1 {
+		t.Fatalf("Unexpected value for tag ID: %s", result.IFDs[1].Tags[0x829D])
+	}
 	if len(result.UnsupportedMarkers) != 0 {
 		t.Fatalf("Unexpected number of unsupported markers: %d", len(result.UnsupportedMarkers))
 	}
@@ -113,7 +125,8 @@ func TestParseXMP(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := jpeg.ParseFile(f, 0)
+	fileInfo, _ := f.Stat()
+	result, err := jpeg.ParseFile(f, fileInfo.Size())
 	if len(result.XMP) != 22 {
 		t.Fatalf("Invalid XMP chunks: %d", len(result.XMP))
 	}
@@ -130,7 +143,8 @@ func TestExtract(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := jpeg.ExtractThumbnail(f, 0)
+	fileInfo, _ := f.Stat()
+	result, err := jpeg.ExtractThumbnail(f, fileInfo.Size())
 	if err != nil {
 		t.Fatalf("Error extracting thumbnail: %s", err)
 	}
@@ -141,3 +155,124 @@ func TestExtract(t *testing.T) {
 		t.Fatalf("Invalid thumbnail")
 	}
 }
+
+func TestStartParsingJPEG(t *testing.T) {
+	f, err := os.Open("internal/parser/test/test1.jpeg")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	fileInfo, _ := f.Stat()
+	doc, err := parser.StartParsing([]parser.Parser{png.Parser, jpeg.Parser}, &parser.Input{Name: f.Name(), Reader: f, Size: fileInfo.Size()}, parser.ShowAction, parser.Options{})
+	if err != nil {
+		t.Fatalf("Error parsing file: %s", err)
+	}
+	if doc.Format != "JPEG" {
+		t.Fatalf("Unexpected format: %s", doc.Format)
+	}
+	model := doc.FindSection("exif.0").FindField("0x0110")
+	if model == nil || model.Value != "Canon EOS 40D" {
+		t.Fatalf("Unexpected camera model: %v", model)
+	}
+	if model.Label != "Model" {
+		t.Fatalf("Unexpected label: %s", model.Label)
+	}
+	exposure := doc.FindSection("exif.1").FindField("0x829A")
+	if exposure == nil || exposure.Label != "ExposureTime" || exposure.Value != "1/160 s" {
+		t.Fatalf("Unexpected exposure time: %v", exposure)
+	}
+	flash := doc.FindSection("exif.1").FindField("0x9209")
+	if flash == nil || flash.Value != "Fired, compulsory flash mode" {
+		t.Fatalf("Unexpected flash: %v", flash)
+	}
+	copyright := doc.FindSection("icc").FindField("copyright")
+	if copyright == nil || copyright.Type != document.StringType {
+		t.Fatalf("Unexpected copyright field: %v", copyright)
+	}
+}
+
+func TestClearSelector(t *testing.T) {
+	f, err := os.Open("internal/parser/test/test1.jpeg")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	fileInfo, _ := f.Stat()
+	input := &parser.Input{Name: f.Name(), Reader: f, Size: fileInfo.Size()}
+	options := parser.Options{DryRun: true, Selector: parser.Selector{Keep: []string{"icc"}}}
+	doc, err := parser.StartParsing([]parser.Parser{jpeg.Parser}, input, parser.ClearAction, options)
+	if err != nil {
+		t.Fatalf("Error running dry run: %s", err)
+	}
+	if len(doc.Sections) != 2 {
+		t.Fatalf("Expected 2 changes but received %d", len(doc.Sections))
+	}
+	options.Selector = parser.Selector{Remove: []string{"exif.gps"}}
+	doc, err = parser.StartParsing([]parser.Parser{jpeg.Parser}, input, parser.ClearAction, options)
+	if err != nil {
+		t.Fatalf("Error running dry run: %s", err)
+	}
+	operation := doc.FindSection("change.0").FindField("operation")
+	if len(doc.Sections) != 1 || operation == nil || operation.Value != parser.RewriteOperation {
+		t.Fatalf("Expected EXIF segment to be rewritten but received %v", operation)
+	}
+}
+
+func TestRewriteExif(t *testing.T) {
+	data, err := os.ReadFile("internal/parser/test/test1.jpeg")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	buffer := &parser.Buffer{Data: data}
+	input := &parser.Input{Name: "test1.jpeg", Reader: buffer, Size: int64(len(buffer.Data)), Writer: buffer}
+	thumbnail, err := jpeg.ExtractThumbnail(input.Reader, input.Size)
+	if err != nil || thumbnail == nil {
+		t.Fatalf("Error extracting thumbnail: %v", err)
+	}
+	options := parser.Options{Selector: parser.Selector{Remove: []string{"exif.gps", "exif.Software"}}}
+	_, err = parser.StartParsing([]parser.Parser{jpeg.Parser}, input, parser.ClearAction, options)
+	if err != nil {
+		t.Fatalf("Error clearing file: %s", err)
+	}
+	input.Size = int64(len(buffer.Data))
+	metadata, err := jpeg.ParseFile(input.Reader, input.Size)
+	if err != nil {
+		t.Fatalf("Error parsing file: %s", err)
+	}
+	if len(metadata.IFDs) != 4 || metadata.IFDs[3].Kind != shared.InteropIFD {
+		t.Fatalf("Expected the GPS IFD to be removed but received %d IFDs", len(metadata.IFDs))
+	}
+	if _, found := metadata.IFDs[0].Tags[0x0131]; found || metadata.IFDs[0].Tags[0x0110].String() != "Canon EOS 40D" {
+		t.Fatalf("Unexpected IFD0: %v", metadata.IFDs[0].Tags)
+	}
+	rewritten, err := jpeg.ExtractThumbnail(input.Reader, input.Size)
+	if err != nil || !bytes.Equal(rewritten, thumbnail) {
+		t.Fatalf("Thumbnail should have been kept: %v", err)
+	}
+
+	options = parser.Options{Fields: []parser.Field{{Key: "exif.Artist", Value: "Jane Doe"}, {Key: "exif.Model", Value: ""}}}
+	_, err = parser.StartParsing([]parser.Parser{jpeg.Parser}, input, parser.SetAction, options)
+	if err != nil {
+		t.Fatalf("Error setting fields: %s", err)
+	}
+	input.Size = int64(len(buffer.Data))
+	metadata, err = jpeg.ParseFile(input.Reader, input.Size)
+	if err != nil {
+		t.Fatalf("Error parsing file: %s", err)
+	}
+	if _, found := metadata.IFDs[0].Tags[0x0110]; found || metadata.IFDs[0].Tags[0x013B].String() != "Jane Doe" {
+		t.Fatalf("Unexpected IFD0: %v", metadata.IFDs[0].Tags)
+	}
+
+	written := append([]byte(nil), buffer.Data...)
+	doc, err := parser.StartParsing([]parser.Parser{jpeg.Parser}, input, parser.SetAction, options)
+	if err != nil {
+		t.Fatalf("Error setting fields: %s", err)
+	}
+	if len(doc.Messages) != 1 || doc.Messages[0] != "No EXIF tag has been changed" || !bytes.Equal(buffer.Data, written) {
+		t.Fatalf("Nothing should have been changed: %v", doc.Messages)
+	}
+	options = parser.Options{Fields: []parser.Field{{Key: "exif.Unknown", Value: "value"}}}
+	_, err = parser.StartParsing([]parser.Parser{jpeg.Parser}, input, parser.SetAction, options)
+	if err == nil {
+		t.Fatalf("Setting an unknown EXIF tag should fail")
+	}
+}
@@ -0,0 +1,314 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"jch-metadata/internal/parser"
+	"jch-metadata/pkg/metadata"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMetadataDetect(t *testing.T) {
+	f, err := metadata.Open("internal/parser/test/test1.flac")
+	if err != nil {
+		t.Fatalf("Error opening file: %s", err)
+	}
+	defer f.Close()
+	format, err := metadata.Detect(f)
+	if err != nil {
+		t.Fatalf("Error detecting format: %s", err)
+	}
+	if format != "FLAC" {
+		t.Fatalf("Unexpected format: %s", format)
+	}
+}
+
+func TestMetadataRegistry(t *testing.T) {
+	registry, err := metadata.NewRegistry("png", "Mkv (Matroska)")
+	if err != nil {
+		t.Fatalf("Error creating registry: %s", err)
+	}
+	if formats := registry.Formats(); len(formats) != 2 || formats[0] != "PNG" || formats[1] != "Mkv (Matroska)" {
+		t.Fatalf("Unexpected formats: %v", formats)
+	}
+	f, err := metadata.Open("internal/parser/test/test1.flac")
+	if err != nil {
+		t.Fatalf("Error opening file: %s", err)
+	}
+	defer f.Close()
+	_, err = registry.Read(f)
+	if !errors.Is(err, metadata.ErrUnsupportedFormat) {
+		t.Fatalf("Expected unsupported format but received %v", err)
+	}
+	_, err = metadata.NewRegistry("gif")
+	if !errors.Is(err, metadata.ErrUnsupportedFormat) {
+		t.Fatalf("Expected unsupported format but received %v", err)
+	}
+}
+
+func TestMetadataReadFromReader(t *testing.T) {
+	data, err := os.ReadFile("internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	f := metadata.NewFile("test1.png", bytes.NewReader(data), int64(len(data)))
+	doc, err := metadata.Read(f)
+	if err != nil {
+		t.Fatalf("Error reading metadata: %s", err)
+	}
+	software := doc.FindSection("text").FindField("Software")
+	if software == nil || software.Value != "gnome-screenshot" {
+		t.Fatalf("Unexpected software field: %v", software)
+	}
+	_, err = metadata.Clear(f)
+	if err != metadata.ErrReadOnly {
+		t.Fatalf("Clearing a read-only file should fail but received %v", err)
+	}
+}
+
+func TestMetadataClearBuffer(t *testing.T) {
+	data, err := os.ReadFile("internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	f := metadata.NewBuffer("test1.png", data)
+	_, err = metadata.Clear(f)
+	if err != nil {
+		t.Fatalf("Error clearing metadata: %s", err)
+	}
+	if len(f.Bytes()) >= len(data) {
+		t.Fatalf("Cleared content should be smaller than %d bytes but was %d bytes", len(data), len(f.Bytes()))
+	}
+	doc, err := metadata.Read(metadata.NewBuffer("test1.png", f.Bytes()))
+	if err != nil {
+		t.Fatalf("Error reading metadata: %s", err)
+	}
+	if doc.FindSection("text") != nil {
+		t.Fatalf("Textual data should have been removed")
+	}
+}
+
+func TestMetadataSet(t *testing.T) {
+	data, err := os.ReadFile("internal/parser/test/test1.flac")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	f := metadata.NewBuffer("test1.flac", data)
+	_, err = metadata.Set(f, metadata.FieldValue{Key: "TITLE", Value: "Song"})
+	if err != nil {
+		t.Fatalf("Error setting metadata: %s", err)
+	}
+	if len(f.Bytes()) != len(data) {
+		t.Fatalf("Vorbis comment should have been written in place using padding")
+	}
+	doc, err := metadata.Read(f)
+	if err != nil {
+		t.Fatalf("Error reading metadata: %s", err)
+	}
+	comments := doc.FindSection("vorbisComment.0").FindField("userComments")
+	if comments == nil || len(comments.Value.([]string)) != 2 || comments.Value.([]string)[1] != "TITLE=Song" {
+		t.Fatalf("Unexpected comments: %v", comments)
+	}
+
+	data, err = os.ReadFile("internal/parser/test/test1.mp4")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	f = metadata.NewBuffer("test1.mp4", data)
+	title := "A title that is longer than the space available in the meta box"
+	_, err = metadata.Set(f, metadata.FieldValue{Key: "title", Value: title})
+	if err != nil {
+		t.Fatalf("Error setting metadata: %s", err)
+	}
+	if len(f.Bytes()) <= len(data) {
+		t.Fatalf("moov box should have been rewritten")
+	}
+	doc, err = metadata.Read(f)
+	if err != nil {
+		t.Fatalf("Error reading metadata: %s", err)
+	}
+	value := doc.FindSection("moov").FindSection("meta").FindField("com.apple.quicktime.title")
+	if value == nil || value.Value != title {
+		t.Fatalf("Unexpected title: %v", value)
+	}
+}
+
+func TestMetadataCopy(t *testing.T) {
+	from, err := metadata.Open("internal/parser/test/test1.jpeg")
+	if err != nil {
+		t.Fatalf("Error opening file: %s", err)
+	}
+	defer from.Close()
+	data, err := os.ReadFile("internal/parser/test/test1.webp")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	f := metadata.NewBuffer("test1.webp", data)
+	_, err = metadata.Copy(from, f)
+	if err != nil {
+		t.Fatalf("Error copying metadata: %s", err)
+	}
+	doc, err := metadata.Read(f)
+	if err != nil {
+		t.Fatalf("Error reading metadata: %s", err)
+	}
+	model := doc.FindSection("exif.0").FindField("0x0110")
+	if model == nil || model.Value != "Canon EOS 40D" {
+		t.Fatalf("Unexpected camera model: %v", model)
+	}
+	if doc.FindSection("icc") == nil {
+		t.Fatalf("ICC profile should have been copied")
+	}
+
+	from, err = metadata.Open("internal/parser/test/test1.flac")
+	if err != nil {
+		t.Fatalf("Error opening file: %s", err)
+	}
+	defer from.Close()
+	data, err = os.ReadFile("internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	f = metadata.NewBuffer("test1.png", data)
+	_, err = metadata.Copy(from, f)
+	if err != nil {
+		t.Fatalf("Error copying metadata: %s", err)
+	}
+	doc, err = metadata.Read(f)
+	if err != nil {
+		t.Fatalf("Error reading metadata: %s", err)
+	}
+	comment := doc.FindSection("text").FindField("Comment")
+	if comment == nil || comment.Value != "Processed by SoX" {
+		t.Fatalf("Unexpected comment: %v", comment)
+	}
+}
+
+func TestMetadataDiff(t *testing.T) {
+	before, err := metadata.Open("internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error opening file: %s", err)
+	}
+	defer before.Close()
+	data, err := os.ReadFile("internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	after := metadata.NewBuffer("test1.png", data)
+	_, err = metadata.Set(after, metadata.FieldValue{Key: "Software", Value: "GIMP"}, metadata.FieldValue{Key: "Title", Value: "Screenshot"})
+	if err != nil {
+		t.Fatalf("Error setting metadata: %s", err)
+	}
+	doc, err := metadata.Diff(before, after)
+	if err != nil {
+		t.Fatalf("Error comparing metadata: %s", err)
+	}
+	if len(doc.Sections) != 2 {
+		t.Fatalf("Expected 2 changes but found %d", len(doc.Sections))
+	}
+	expected := [][]string{{"text.Software", "changed"}, {"text.Title", "added"}}
+	for i, e := range expected {
+		section := doc.Sections[i]
+		if section.FindField("field").Value != e[0] || section.FindField("change").Value != e[1] {
+			t.Fatalf("Unexpected change %d: %v", i, section.Fields)
+		}
+	}
+	if section := doc.Sections[0]; section.FindField("before").Value != "gnome-screenshot" || section.FindField("after").Value != "GIMP" {
+		t.Fatalf("Unexpected values: %v", section.Fields)
+	}
+}
+
+func TestMetadataAudit(t *testing.T) {
+	data, err := os.ReadFile("internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	f := metadata.NewBuffer("test1.png", data)
+	_, err = metadata.Set(f, metadata.FieldValue{Key: "Author", Value: "Jane Doe"})
+	if err != nil {
+		t.Fatalf("Error setting metadata: %s", err)
+	}
+	doc, err := metadata.Audit(f, nil)
+	if err != nil {
+		t.Fatalf("Error auditing metadata: %s", err)
+	}
+	if risk := doc.FindSection("audit").FindField("risk"); risk == nil || risk.Value != "high" {
+		t.Fatalf("Unexpected risk: %v", risk)
+	}
+	finding := doc.FindSection("finding.0")
+	if finding == nil || finding.FindField("category").Value != "person" || finding.FindField("value").Value != "Jane Doe" {
+		t.Fatalf("Unexpected finding: %v", finding)
+	}
+	if violations := parser.CountViolations(doc); violations != 1 {
+		t.Fatalf("Expected 1 violation but found %d", violations)
+	}
+	doc, err = metadata.Audit(f, metadata.DefaultPolicy)
+	if err != nil || parser.CountViolations(doc) != 1 {
+		t.Fatalf("DefaultPolicy should be used when the policy is nil: %v", err)
+	}
+	doc, err = metadata.Audit(f, []string{"timestamp"})
+	if err != nil {
+		t.Fatalf("Error auditing metadata: %s", err)
+	}
+	if violations := parser.CountViolations(doc); violations != 1 || doc.FindSection("finding.0").FindField("violation").Value != false {
+		t.Fatalf("Only the creation time should violate the policy but found %d violations", violations)
+	}
+}
+
+func TestMetadataHash(t *testing.T) {
+	for _, name := range []string{"test1.jpeg", "test1.png", "test1.flac", "test1.webp", "test1.mp4"} {
+		data, err := os.ReadFile("internal/parser/test/" + name)
+		if err != nil {
+			t.Fatalf("Error reading file: %s", err)
+		}
+		f := metadata.NewBuffer(name, data)
+		before, err := metadata.Hash(f)
+		if err != nil {
+			t.Fatalf("Error hashing %s: %s", name, err)
+		}
+		_, err = metadata.Clear(f)
+		if err != nil {
+			t.Fatalf("Error clearing %s: %s", name, err)
+		}
+		after, err := metadata.Hash(f)
+		if err != nil {
+			t.Fatalf("Error hashing %s: %s", name, err)
+		}
+		if parser.FindEssenceHash(before) == "" || parser.FindEssenceHash(before) != parser.FindEssenceHash(after) {
+			t.Fatalf("Clearing %s changed the essence hash from %s to %s", name, parser.FindEssenceHash(before), parser.FindEssenceHash(after))
+		}
+	}
+}
+
+func TestMetadataClearWritable(t *testing.T) {
+	data, err := os.ReadFile("internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	path := filepath.Join(t.TempDir(), "test1.png")
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+	f, err := metadata.OpenWritable(path)
+	if err != nil {
+		t.Fatalf("Error opening file: %s", err)
+	}
+	defer f.Close()
+	_, err = metadata.Clear(f)
+	if err != nil {
+		t.Fatalf("Error clearing metadata: %s", err)
+	}
+	if f.Size() >= int64(len(data)) {
+		t.Fatalf("Size should have been refreshed but is %d bytes", f.Size())
+	}
+	doc, err := metadata.Read(f)
+	if err != nil {
+		t.Fatalf("Error reading metadata: %s", err)
+	}
+	if doc.FindSection("text") != nil {
+		t.Fatalf("Reading the cleared file should not return the removed textual data")
+	}
+}
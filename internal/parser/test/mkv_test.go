@@ -16,7 +16,7 @@ func TestIsMkv(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := mkv.IsMkv(f, 0)
+	result, err := mkv.IsMkv(f)
 	if err != nil {
 		t.Fatalf("Error inspecting file: %s", err)
 	}
@@ -27,7 +27,7 @@ func TestIsMkv(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err = mkv.IsMkv(f, 0)
+	result, err = mkv.IsMkv(f)
 	if err != nil {
 		t.Fatalf("Error inspecting file: %s", err)
 	}
@@ -41,7 +41,7 @@ func TestIsMkv_Unsupported(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := mkv.IsMkv(f, 0)
+	result, err := mkv.IsMkv(f)
 	if err != nil {
 		t.Fatalf("Error inspecting file: %s", err)
 	}
@@ -107,7 +107,8 @@ func TestParseFile(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := mkv.ParseFile(f)
+	fileInfo, _ := f.Stat()
+	result, err := mkv.ParseFile(f, fileInfo.Size())
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
@@ -182,7 +183,8 @@ func TestGetMetadata(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	metadata, err := mkv.GetMetadata(f)
+	fileInfo, _ := f.Stat()
+	metadata, err := mkv.GetMetadata(f, fileInfo.Size())
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
@@ -213,7 +215,7 @@ func TestHandleAttachments(t *testing.T) {
 		t.Fatalf("Error reading file stat")
 	}
 	var parsers = []parser.Parser{flac.Parser, png.Parser, jpeg.Parser}
-	err = mkv.Parser.Handle(f, parser.ShowAction, 0, fileInfo.Size(), parsers)
+	_, err = mkv.Parser.Handle(&parser.Input{Name: f.Name(), Reader: f, Size: fileInfo.Size()}, parser.ShowAction, parsers, parser.Options{})
 	if err != nil {
 		t.Fatalf("Unexpected error: %s", err)
 	}
@@ -224,7 +226,8 @@ func TestFindFirstElement(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := mkv.ParseFile(f)
+	fileInfo, _ := f.Stat()
+	result, err := mkv.ParseFile(f, fileInfo.Size())
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
@@ -258,3 +261,67 @@ func TestFindFirstElement(t *testing.T) {
 		t.Fatalf("Unexpected element data at: %d", searchWithValue.DataAt)
 	}
 }
+
+func TestMkvEssenceRanges(t *testing.T) {
+	info := mkv.EncodeElement([]byte{0x15, 0x49, 0xA9, 0x66}, mkv.EncodeElement([]byte{0x7B, 0xA9}, []byte("Title")))
+	cluster := mkv.EncodeElement([]byte{0x1F, 0x43, 0xB6, 0x75}, mkv.EncodeElement([]byte{0xA3}, []byte{0x81, 0x00, 0x00, 0x80, 0x01, 0x02}))
+	header := mkv.EncodeElement([]byte{0x1A, 0x45, 0xDF, 0xA3}, mkv.EncodeElement([]byte{0x42, 0x82}, []byte("matroska")))
+	data := append(header, mkv.EncodeElement([]byte{0x18, 0x53, 0x80, 0x67}, append(info, cluster...))...)
+	ranges, err := mkv.EssenceRanges(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Error finding clusters: %s", err)
+	}
+	if len(ranges) != 1 || ranges[0].Size != int64(len(cluster)) || !bytes.Equal(data[ranges[0].Offset:ranges[0].Offset+ranges[0].Size], cluster) {
+		t.Fatalf("Unexpected ranges: %v", ranges)
+	}
+}
+
+func TestMkvSetFieldsUnsupported(t *testing.T) {
+	header := mkv.EncodeElement([]byte{0x1A, 0x45, 0xDF, 0xA3}, mkv.EncodeElement([]byte{0x42, 0x82}, []byte("matroska")))
+	title := mkv.EncodeElement([]byte{0x7B, 0xA9}, []byte("Title"))
+	info := mkv.EncodeElement([]byte{0x15, 0x49, 0xA9, 0x66}, title)
+	cluster := mkv.EncodeElement([]byte{0x1F, 0x43, 0xB6, 0x75}, mkv.EncodeElement([]byte{0xE7}, []byte{0x00}))
+	crc := mkv.EncodeElement([]byte{0xBF}, []byte{0x00, 0x00, 0x00, 0x00})
+	segment := func(data ...[]byte) []byte {
+		return append(append([]byte(nil), header...), mkv.EncodeElement([]byte{0x18, 0x53, 0x80, 0x67}, bytes.Join(data, nil))...)
+	}
+	unknownSize := append(append([]byte(nil), header...), 0x18, 0x53, 0x80, 0x67, 0xFF)
+	unknownSize = append(unknownSize, info...)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"unknown size", unknownSize},
+		{"segment CRC-32", segment(crc, info)},
+		{"Info CRC-32", segment(mkv.EncodeElement([]byte{0x15, 0x49, 0xA9, 0x66}, append(crc, title...)))},
+		{"Info after Clusters", segment(info, cluster)},
+	}
+	fields := []parser.Field{{Key: "info.title", Value: "A longer title"}}
+	for _, test := range tests {
+		buffer := &parser.Buffer{Data: test.data}
+		_, err := mkv.SetFields(buffer, int64(len(buffer.Data)), buffer, fields, false)
+		if err == nil {
+			t.Fatalf("%s: setting fields should fail", test.name)
+		}
+		if !bytes.Equal(buffer.Data, test.data) {
+			t.Fatalf("%s: file should not have been modified", test.name)
+		}
+	}
+
+	buffer := &parser.Buffer{Data: segment(info)}
+	_, err := mkv.SetFields(buffer, int64(len(buffer.Data)), buffer, fields, false)
+	if err != nil {
+		t.Fatalf("Error moving Info element without Clusters: %s", err)
+	}
+	elements, err := mkv.ParseFile(buffer, int64(len(buffer.Data)))
+	if err != nil {
+		t.Fatalf("Error parsing file: %s", err)
+	}
+	segmentElements := mkv.SearchEBMLElements([]byte{0x18, 0x53, 0x80, 0x67}, elements).GetElements()
+	if len(segmentElements) != 2 || !bytes.Equal(segmentElements[0].ElementID, []byte{0xEC}) {
+		t.Fatalf("Info element should have been replaced by a Void element")
+	}
+	if value := mkv.GetStringValue([]byte{0x7B, 0xA9}, segmentElements[1].GetElements()); value != "A longer title" {
+		t.Fatalf("Unexpected title: %s", value)
+	}
+}
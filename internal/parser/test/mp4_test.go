@@ -1,7 +1,10 @@
 package test
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"jch-metadata/internal/parser"
 	"jch-metadata/internal/parser/mp4"
 	"os"
 	"testing"
@@ -195,3 +198,40 @@ func TestToFreeBox(t *testing.T) {
 		}
 	}
 }
+
+// mp4WithBrokenKeys returns test1.mp4 with a keys box that declares more entries than it contains.
+func mp4WithBrokenKeys(t *testing.T) *parser.Buffer {
+	data, err := os.ReadFile("internal/parser/test/test1.mp4")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	keys := bytes.Index(data, []byte("keys"))
+	if keys < 0 {
+		t.Fatalf("keys box not found")
+	}
+	binary.BigEndian.PutUint32(data[keys+8:keys+12], 1000)
+	return &parser.Buffer{Data: data}
+}
+
+func TestAuditBrokenKeys(t *testing.T) {
+	buffer := mp4WithBrokenKeys(t)
+	input := &parser.Input{Name: "broken.mp4", Reader: buffer, Size: int64(len(buffer.Data))}
+	_, err := parser.StartParsing([]parser.Parser{mp4.Parser}, input, parser.AuditAction, parser.Options{Policy: parser.DefaultPolicy})
+	if err == nil {
+		t.Fatalf("Auditing a file with a broken keys box should fail")
+	}
+}
+
+func TestSetBrokenKeys(t *testing.T) {
+	buffer := mp4WithBrokenKeys(t)
+	original := append([]byte(nil), buffer.Data...)
+	input := &parser.Input{Name: "broken.mp4", Reader: buffer, Size: int64(len(buffer.Data)), Writer: buffer}
+	options := parser.Options{Fields: []parser.Field{{Key: "title", Value: "Title"}}}
+	_, err := parser.StartParsing([]parser.Parser{mp4.Parser}, input, parser.SetAction, options)
+	if err == nil {
+		t.Fatalf("Setting fields in a file with a broken keys box should fail")
+	}
+	if !bytes.Equal(buffer.Data, original) {
+		t.Fatalf("File should not have been modified")
+	}
+}
@@ -2,6 +2,7 @@ package test
 
 import (
 	"bytes"
+	"jch-metadata/internal/parser"
 	"jch-metadata/internal/parser/png"
 	"os"
 	"testing"
@@ -12,7 +13,7 @@ func TestIsPNG(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := png.IsPNG(f, 0)
+	result, err := png.IsPNG(f)
 	if !result {
 		t.Fatalf("Result should be true")
 	}
@@ -23,7 +24,7 @@ func TestIsPNG_Unsupported(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
-	result, err := png.IsPNG(f, 0)
+	result, err := png.IsPNG(f)
 	if result {
 		t.Fatalf("Result should be false")
 	}
@@ -38,7 +39,7 @@ func TestGetChunks(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file stat")
 	}
-	chunks, err := png.GetChunks(f, 0, fileInfo.Size())
+	chunks, err := png.GetChunks(f, fileInfo.Size())
 	if err != nil {
 		t.Fatalf("Error getting chunks: %s", err)
 	}
@@ -65,7 +66,7 @@ func TestGetTextData(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file stat")
 	}
-	result, err := png.GetTextData(f, 0, fileInfo.Size())
+	result, err := png.GetTextData(f, fileInfo.Size())
 	if err != nil {
 		t.Fatalf("Error reading file: %s", err)
 	}
@@ -76,3 +77,74 @@ func TestGetTextData(t *testing.T) {
 		t.Fatalf("Unexpected text data: %s", result["CreationTime"])
 	}
 }
+
+func TestClearDryRun(t *testing.T) {
+	f, err := os.Open("internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	fileInfo, _ := f.Stat()
+	input := &parser.Input{Name: f.Name(), Reader: f, Size: fileInfo.Size()}
+	doc, err := parser.StartParsing([]parser.Parser{png.Parser}, input, parser.ClearAction, parser.Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("Error running dry run: %s", err)
+	}
+	if len(doc.Sections) != 2 {
+		t.Fatalf("Expected 2 changes but received %d", len(doc.Sections))
+	}
+	offset := doc.FindSection("change.0").FindField("offset")
+	if offset == nil || offset.Value != int64(49) {
+		t.Fatalf("Unexpected offset: %v", offset)
+	}
+}
+
+func TestSetTextData(t *testing.T) {
+	data, err := os.ReadFile("internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	buffer := &parser.Buffer{Data: data}
+	fields := []parser.Field{{Key: "Software", Value: ""}, {Key: "Title", Value: "Café"}}
+	_, err = png.SetTextData(buffer, int64(len(buffer.Data)), buffer, fields, false)
+	if err != nil {
+		t.Fatalf("Error setting text data: %s", err)
+	}
+	result, err := png.GetTextData(buffer, int64(len(buffer.Data)))
+	if err != nil {
+		t.Fatalf("Error reading text data: %s", err)
+	}
+	if _, found := result["Software"]; found {
+		t.Fatalf("Software should have been removed")
+	}
+	if result["Title"] != "Café" {
+		t.Fatalf("Unexpected title: %s", result["Title"])
+	}
+}
+
+func TestPngVerify(t *testing.T) {
+	data, err := os.ReadFile("internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	problems, err := png.Verify(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Error verifying file: %s", err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("Expected no problems but received %v", problems)
+	}
+	data[40] ^= 1
+	problems, err = png.Verify(bytes.NewReader(data), int64(len(data)-12))
+	if err != nil {
+		t.Fatalf("Error verifying file: %s", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("Expected 2 problems but received %v", problems)
+	}
+	if problems[0].Severity != parser.ErrorSeverity || problems[0].Offset != 33 {
+		t.Fatalf("Unexpected CRC problem: %v", problems[0])
+	}
+	if problems[1].Severity != parser.ErrorSeverity || problems[1].Message != "IEND chunk not found" {
+		t.Fatalf("Unexpected IEND problem: %v", problems[1])
+	}
+}
@@ -0,0 +1,95 @@
+package test
+
+import (
+	"io"
+	"jch-metadata/internal/parser"
+	"jch-metadata/internal/safewrite"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSafeWriteReplace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.txt")
+	err := os.WriteFile(path, []byte("original"), 0640)
+	if err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+	modTime := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	err = os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatalf("Error changing file time: %s", err)
+	}
+	err = safewrite.Replace(path, func(w io.Writer) error {
+		_, err := w.Write([]byte("replaced"))
+		return err
+	}, safewrite.Options{Backup: true})
+	if err != nil {
+		t.Fatalf("Error replacing file: %s", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "replaced" {
+		t.Fatalf("Unexpected content: %s", data)
+	}
+	fileInfo, _ := os.Stat(path)
+	if fileInfo.Mode().Perm() != 0640 {
+		t.Fatalf("Expected mode 0640 but received %o", fileInfo.Mode().Perm())
+	}
+	if !fileInfo.ModTime().Equal(modTime) {
+		t.Fatalf("Expected modification time %s but received %s", modTime, fileInfo.ModTime())
+	}
+	backup, _ := os.ReadFile(path + safewrite.BackupSuffix)
+	if string(backup) != "original" {
+		t.Fatalf("Unexpected backup content: %s", backup)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 2 {
+		t.Fatalf("Temporary files should have been removed but found %d files", len(entries))
+	}
+}
+
+func TestFileWriterReplaceTwice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.txt")
+	err := os.WriteFile(path, []byte("original"), 0640)
+	if err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Error opening file: %s", err)
+	}
+	writer := &parser.FileWriter{File: file, Backup: true}
+	defer writer.Close()
+	for _, content := range []string{"first", "second content"} {
+		err = writer.Replace(func(w io.Writer) error {
+			_, err := w.Write([]byte(content))
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Error replacing file: %s", err)
+		}
+	}
+	// Changes written in place after a replacement must go to the new file
+	_, err = writer.WriteAt([]byte("S"), 0)
+	if err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+	err = writer.Flush()
+	if err != nil {
+		t.Fatalf("Error flushing file: %s", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "Second content" {
+		t.Fatalf("Unexpected content: %s", data)
+	}
+	current := make([]byte, 6)
+	_, err = writer.File.ReadAt(current, 0)
+	if err != nil || string(current) != "Second" {
+		t.Fatalf("File should have been reopened but read %q, %v", current, err)
+	}
+	backup, _ := os.ReadFile(path + safewrite.BackupSuffix)
+	if string(backup) != "original" {
+		t.Fatalf("Backup should keep the original content but contains %s", backup)
+	}
+}
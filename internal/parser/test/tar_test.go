@@ -0,0 +1,94 @@
+package test
+
+import (
+	archiveTar "archive/tar"
+	"bytes"
+	"errors"
+	"jch-metadata/internal/parser"
+	"jch-metadata/internal/parser/png"
+	"jch-metadata/internal/parser/tar"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTarShow(t *testing.T) {
+	data, err := os.ReadFile("internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	var archive bytes.Buffer
+	w := archiveTar.NewWriter(&archive)
+	modTime := time.Date(2024, 6, 29, 14, 53, 20, 0, time.UTC)
+	err = w.WriteHeader(&archiveTar.Header{Name: "images/", Typeflag: archiveTar.TypeDir, Mode: 0755, ModTime: modTime})
+	if err != nil {
+		t.Fatalf("Error writing header: %s", err)
+	}
+	err = w.WriteHeader(&archiveTar.Header{
+		Name:     "images/test1.png",
+		Typeflag: archiveTar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		Uname:    "jocki",
+		Gname:    "users",
+		ModTime:  modTime,
+		Format:   archiveTar.FormatPAX,
+	})
+	if err != nil {
+		t.Fatalf("Error writing header: %s", err)
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		t.Fatalf("Error writing entry: %s", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("Error closing archive: %s", err)
+	}
+
+	r := bytes.NewReader(archive.Bytes())
+	result, err := tar.IsTar(r, r.Size())
+	if !result {
+		t.Fatalf("Result should be true")
+	}
+	entries, err := tar.GetEntries(r, r.Size())
+	if err != nil {
+		t.Fatalf("Error reading entries: %s", err)
+	}
+	if len(entries) != 2 || entries[1].Uname != "jocki" || entries[1].Gname != "users" || !entries[1].ModTime.Equal(modTime) {
+		t.Fatalf("Unexpected entries: %v", entries)
+	}
+	if entries[0].Type != "Directory" {
+		t.Fatalf("Unexpected type: %s", entries[0].Type)
+	}
+	input := &parser.Input{Name: "test.tar", Reader: r, Size: r.Size()}
+	doc, err := tar.Parser.Handle(input, parser.ShowAction, []parser.Parser{tar.Parser, png.Parser}, parser.Options{})
+	if err != nil {
+		t.Fatalf("Error parsing archive: %s", err)
+	}
+	section := doc.FindSection("entry.1")
+	if len(section.Documents) != 1 || section.Documents[0].Format != "PNG" {
+		t.Fatalf("Entry should have been parsed as PNG")
+	}
+}
+
+func TestTarUnsupportedAction(t *testing.T) {
+	var archive bytes.Buffer
+	w := archiveTar.NewWriter(&archive)
+	err := w.WriteHeader(&archiveTar.Header{Name: "empty", Typeflag: archiveTar.TypeReg, Mode: 0644})
+	if err != nil {
+		t.Fatalf("Error writing header: %s", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("Error closing archive: %s", err)
+	}
+	r := bytes.NewReader(archive.Bytes())
+	input := &parser.Input{Name: "test.tar", Reader: r, Size: r.Size()}
+	for _, action := range []parser.Action{parser.ClearAction, parser.HashAction} {
+		_, err = tar.Parser.Handle(input, action, []parser.Parser{tar.Parser}, parser.Options{})
+		if !errors.Is(err, parser.ErrUnsupportedAction) {
+			t.Fatalf("Expected unsupported %s action but received %v", action, err)
+		}
+	}
+}
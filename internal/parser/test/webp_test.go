@@ -1,9 +1,14 @@
 package test
 
 import (
+	"bytes"
+	"encoding/binary"
+	"jch-metadata/internal/parser"
+	"jch-metadata/internal/parser/shared"
 	"jch-metadata/internal/parser/webp"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestIsWebP(t *testing.T) {
@@ -12,7 +17,7 @@ func TestIsWebP(t *testing.T) {
 		t.Fatalf("Error reading file: %s", err)
 	}
 	fileInfo, _ := f.Stat()
-	result, err := webp.IsWebp(f, 0, fileInfo.Size())
+	result, err := webp.IsWebp(f, fileInfo.Size())
 	if !result {
 		t.Fatalf("Result should be true")
 	}
@@ -24,7 +29,7 @@ func TestIsWebP_Unsupported(t *testing.T) {
 		t.Fatalf("Error reading file: %s", err)
 	}
 	fileInfo, _ := f.Stat()
-	result, err := webp.IsWebp(f, 0, fileInfo.Size())
+	result, err := webp.IsWebp(f, fileInfo.Size())
 	if result {
 		t.Fatalf("Result should be false")
 	}
@@ -39,7 +44,7 @@ func TestWebpGetChunks(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading file stat")
 	}
-	chunks, err := webp.GetChunks(f, 0, fileInfo.Size())
+	chunks, err := webp.GetChunks(f, fileInfo.Size())
 	if err != nil {
 		t.Fatalf("Error getting chunks: %s", err)
 	}
@@ -87,7 +92,7 @@ func TestWebpGetChunks(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error reading EXIF data: %s", err)
 	}
-	if len(ifds) != 3 {
+	if len(ifds) != 5 {
 		t.Fatalf("Invalid IFD size: %d", len(ifds))
 	}
 	if ifds[0].StartOffset != 8 {
@@ -96,13 +101,13 @@ func TestWebpGetChunks(t *testing.T) {
 	if len(ifds[0].Tags) != 10 {
 		t.Fatalf("Invalid number of tags: %d", len(ifds[0].Tags))
 	}
-	if ifds[0].Tags[0x10F] != "Samsung" {
+	if ifds[0].Tags[0x10F].String() != "Samsung" {
 		t.Fatalf("Invalid tags: 0x10F => %s", ifds[0].Tags[0x10F])
 	}
-	if ifds[0].Tags[0x110] != "Galaxy Nexus" {
+	if ifds[0].Tags[0x110].String() != "Galaxy Nexus" {
 		t.Fatalf("Invalid tags: 0x110 => %s", ifds[0].Tags[0x110])
 	}
-	if ifds[0].Tags[0x112] != "65536" {
+	if ifds[0].Tags[0x112].String() != "1" {
 		t.Fatalf("Invalid tags: 0x112 => %s", ifds[0].Tags[0x112])
 	}
 	if ifds[1].StartOffset != 210 {
@@ -111,10 +116,10 @@ func TestWebpGetChunks(t *testing.T) {
 	if len(ifds[1].Tags) != 38 {
 		t.Fatalf("Invalid number of tags: %d", len(ifds[1].Tags))
 	}
-	if ifds[1].Tags[0x9004] != "2013:10:21 15:19:01" {
+	if ifds[1].Tags[0x9004].String() != "2013:10:21 15:19:01" {
 		t.Fatalf("Invalid tags: 0x9004 => %s", ifds[1].Tags[0x9004])
 	}
-	if ifds[1].Tags[0xA420] != "d95be34ec8879acecb4922b372f51e81" {
+	if ifds[1].Tags[0xA420].String() != "d95be34ec8879acecb4922b372f51e81" {
 		t.Fatalf("Invalid tags: 0xA420 => %s", ifds[1].Tags[0xA420])
 	}
 	if ifds[2].StartOffset != 1008 {
@@ -123,9 +128,19 @@ func TestWebpGetChunks(t *testing.T) {
 	if len(ifds[2].Tags) != 6 {
 		t.Fatalf("Invalid number of tags: %d", len(ifds[2].Tags))
 	}
-	if ifds[2].Tags[0x103] != "393216" {
+	if ifds[2].Tags[0x103].String() != "6" {
 		t.Fatalf("Invalid tags: 0x103 => %s", ifds[2].Tags[0x103])
 	}
+	if ifds[3].Kind != shared.GPSIFD || ifds[4].Kind != shared.InteropIFD {
+		t.Fatalf("Invalid IFD kinds: %s, %s", ifds[3].Kind, ifds[4].Kind)
+	}
+	gps := shared.DecodeGPS(ifds[3])
+	if gps.Time == nil || gps.Time.Format(time.RFC3339) != "2013-10-21T13:19:01Z" {
+		t.Fatalf("Invalid GPS time: %v", gps.Time)
+	}
+	if gps.Direction == nil || *gps.Direction != 59 || gps.DirectionRef != "magnetic north" {
+		t.Fatalf("Invalid GPS direction: %v", gps.Direction)
+	}
 
 	xmp, err := chunks[3].GetXMP()
 	if err != nil {
@@ -135,3 +150,70 @@ func TestWebpGetChunks(t *testing.T) {
 		t.Fatalf("Invalid XMP value")
 	}
 }
+
+func TestWebpClearFlags(t *testing.T) {
+	data, err := os.ReadFile("internal/parser/test/test1.webp")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	buffer := &parser.Buffer{Data: data}
+	input := &parser.Input{Name: "test1.webp", Reader: buffer, Size: int64(len(buffer.Data)), Writer: buffer}
+	_, err = parser.StartParsing([]parser.Parser{webp.Parser}, input, parser.ClearAction, parser.Options{})
+	if err != nil {
+		t.Fatalf("Error clearing file: %s", err)
+	}
+	problems, err := webp.Verify(buffer, int64(len(buffer.Data)))
+	if err != nil {
+		t.Fatalf("Error verifying file: %s", err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("Cleared file should have no problems but received %v", problems)
+	}
+}
+
+func TestWebpExifWithoutPrefix(t *testing.T) {
+	f, err := os.Open("internal/parser/test/test1.webp")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	defer f.Close()
+	fileInfo, _ := f.Stat()
+	chunks, err := webp.GetChunks(f, fileInfo.Size())
+	if err != nil {
+		t.Fatalf("Error reading chunks: %s", err)
+	}
+	// The WebP specification stores the TIFF data without the "Exif\0\0" prefix
+	data := []byte("RIFF\x00\x00\x00\x00WEBP")
+	for _, c := range chunks {
+		chunkData, err := c.GetData()
+		if err != nil {
+			t.Fatalf("Error reading chunk: %s", err)
+		}
+		if c.FourC == "EXIF" {
+			chunkData = bytes.TrimPrefix(chunkData, []byte(shared.ExifHeader))
+		}
+		data = append(data, webp.EncodeChunk(c.FourC, chunkData)...)
+	}
+	binary.LittleEndian.PutUint32(data[4:8], uint32(len(data)-8))
+
+	r := bytes.NewReader(data)
+	chunks, err = webp.GetChunks(r, r.Size())
+	if err != nil {
+		t.Fatalf("Error reading chunks: %s", err)
+	}
+	ifds, err := chunks[2].GetExif()
+	if err != nil {
+		t.Fatalf("Error reading EXIF data: %s", err)
+	}
+	if len(ifds) != 5 || len(ifds[0].Tags) != 10 {
+		t.Fatalf("Unexpected IFDs: %d", len(ifds))
+	}
+	input := &parser.Input{Name: "test.webp", Reader: r, Size: r.Size()}
+	doc, err := parser.StartParsing([]parser.Parser{webp.Parser}, input, parser.ShowAction, parser.Options{})
+	if err != nil {
+		t.Fatalf("Error parsing file: %s", err)
+	}
+	if doc.FindSection("exif.0") == nil {
+		t.Fatalf("EXIF data should have been shown")
+	}
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	archiveZip "archive/zip"
+	"bytes"
+	"errors"
+	"jch-metadata/internal/parser"
+	"jch-metadata/internal/parser/png"
+	"jch-metadata/internal/parser/zip"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestZipShow(t *testing.T) {
+	data, err := os.ReadFile("internal/parser/test/test1.png")
+	if err != nil {
+		t.Fatalf("Error reading file: %s", err)
+	}
+	var archive bytes.Buffer
+	w := archiveZip.NewWriter(&archive)
+	header := &archiveZip.FileHeader{Name: "images/test1.png", Method: archiveZip.Deflate, Modified: time.Date(2024, 6, 29, 14, 53, 20, 0, time.UTC)}
+	// Info-ZIP Unix extra field with UID 1000 and GID 100
+	header.Extra = []byte{0x75, 0x78, 0x0B, 0x00, 0x01, 0x04, 0xE8, 0x03, 0x00, 0x00, 0x04, 0x64, 0x00, 0x00, 0x00}
+	entry, err := w.CreateHeader(header)
+	if err != nil {
+		t.Fatalf("Error creating entry: %s", err)
+	}
+	_, err = entry.Write(data)
+	if err != nil {
+		t.Fatalf("Error writing entry: %s", err)
+	}
+	err = w.SetComment("Bundle")
+	if err != nil {
+		t.Fatalf("Error setting comment: %s", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("Error closing archive: %s", err)
+	}
+
+	r := bytes.NewReader(archive.Bytes())
+	result, err := zip.IsZip(r)
+	if !result {
+		t.Fatalf("Result should be true")
+	}
+	input := &parser.Input{Name: "test.zip", Reader: r, Size: r.Size()}
+	doc, err := zip.Parser.Handle(input, parser.ShowAction, []parser.Parser{zip.Parser, png.Parser}, parser.Options{})
+	if err != nil {
+		t.Fatalf("Error parsing archive: %s", err)
+	}
+	if comment := doc.FindSection("archive").FindField("comment"); comment.Value != "Bundle" {
+		t.Fatalf("Unexpected comment: %v", comment.Value)
+	}
+	section := doc.FindSection("entry.0")
+	if section.FindField("extra.uid").Value != uint64(1000) || section.FindField("extra.gid").Value != uint64(100) {
+		t.Fatalf("Unexpected UID and GID: %v", section.Fields)
+	}
+	if len(section.Documents) != 1 || section.Documents[0].Format != "PNG" {
+		t.Fatalf("Entry should have been parsed as PNG")
+	}
+}
+
+func TestZipUnsupportedAction(t *testing.T) {
+	var archive bytes.Buffer
+	err := archiveZip.NewWriter(&archive).Close()
+	if err != nil {
+		t.Fatalf("Error closing archive: %s", err)
+	}
+	r := bytes.NewReader(archive.Bytes())
+	input := &parser.Input{Name: "test.zip", Reader: r, Size: r.Size()}
+	for _, action := range []parser.Action{parser.ClearAction, parser.HashAction} {
+		_, err = zip.Parser.Handle(input, action, []parser.Parser{zip.Parser}, parser.Options{})
+		if !errors.Is(err, parser.ErrUnsupportedAction) {
+			t.Fatalf("Expected unsupported %s action but received %v", action, err)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+	"jch-metadata/internal/parser/shared"
+	"strings"
+)
+
+// ExportMetadata returns the content of the EXIF, XMP and ICCP chunks.
+func ExportMetadata(r io.ReaderAt, size int64) (*parser.Metadata, error) {
+	chunks, err := GetChunks(r, size)
+	if err != nil {
+		return nil, err
+	}
+	result := parser.Metadata{}
+	for _, c := range chunks {
+		if !c.IsMetadata() {
+			continue
+		}
+		data, err := c.GetData()
+		if err != nil {
+			return nil, err
+		}
+		switch c.FourC {
+		case "EXIF":
+			result.Exif = bytes.TrimPrefix(data, []byte(shared.ExifHeader))
+		case "XMP ":
+			result.XMP = data
+		case "ICCP":
+			result.ICC = data
+		}
+	}
+	return &result, nil
+}
+
+// ImportMetadata rewrites the file with the EXIF data, XMP packet and ICC profile of source, replacing the chunks of
+// the same kind.  A file in the simple format is converted into the extended format by adding a VP8X chunk.  If dryRun
+// is true, the changes are returned without writing them.
+func ImportMetadata(r io.ReaderAt, size int64, w parser.Writer, source *parser.Metadata, dryRun bool) ([]parser.Change, error) {
+	chunks, err := GetChunks(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(source.Exif) == 0 && len(source.XMP) == 0 && len(source.ICC) == 0 {
+		return nil, nil
+	}
+	replaced := map[string][]byte{}
+	if len(source.Exif) > 0 {
+		replaced["EXIF"] = append([]byte(shared.ExifHeader), source.Exif...)
+	}
+	if len(source.XMP) > 0 {
+		replaced["XMP "] = source.XMP
+	}
+	if len(source.ICC) > 0 {
+		replaced["ICCP"] = source.ICC
+	}
+
+	var changes []parser.Change
+	var vp8x []byte
+	metadata := map[string][]byte{}
+	for _, c := range chunks {
+		if c.FourC == "VP8X" && vp8x == nil {
+			vp8x, err = c.GetData()
+			if err != nil {
+				return nil, err
+			}
+		} else if c.IsMetadata() {
+			if _, found := replaced[c.FourC]; found {
+				changes = append(changes, parser.Change{
+					Operation: parser.RemoveOperation,
+					Target:    fmt.Sprintf("%s chunk", strings.TrimSpace(c.FourC)),
+					Offset:    c.StartAt,
+					Size:      8 + int64(c.Size),
+				})
+			} else if _, found := metadata[c.FourC]; !found {
+				metadata[c.FourC], err = c.GetData()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	for k, v := range replaced {
+		metadata[k] = v
+	}
+	if vp8x == nil {
+		vp8x, err = newVP8X(chunks)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, parser.Change{
+			Operation: parser.RewriteOperation,
+			Target:    "new VP8X chunk",
+			Offset:    12,
+			Size:      18,
+		})
+	}
+	if len(vp8x) < 1 {
+		return nil, fmt.Errorf("invalid VP8X chunk size: %d", len(vp8x))
+	}
+	vp8x[0] &^= 0x20 | 0x08 | 0x04
+	for fourC, flag := range map[string]byte{"ICCP": 0x20, "EXIF": 0x08, "XMP ": 0x04} {
+		if metadata[fourC] != nil {
+			vp8x[0] |= flag
+		}
+	}
+
+	// The ICCP chunk must follow the VP8X chunk, EXIF and XMP chunks are stored after the image data
+	result := []byte("RIFF\x00\x00\x00\x00WEBP")
+	result = append(result, EncodeChunk("VP8X", vp8x)...)
+	add := func(fourC string) {
+		data := metadata[fourC]
+		if data == nil {
+			return
+		}
+		if _, found := replaced[fourC]; found {
+			changes = append(changes, parser.Change{
+				Operation: parser.RewriteOperation,
+				Target:    fmt.Sprintf("new %s chunk", strings.TrimSpace(fourC)),
+				Offset:    int64(len(result)),
+				Size:      8 + int64(len(data)),
+			})
+		}
+		result = append(result, EncodeChunk(fourC, data)...)
+	}
+	add("ICCP")
+	for _, c := range chunks {
+		if c.FourC == "VP8X" || c.IsMetadata() {
+			continue
+		}
+		data, err := c.GetData()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, EncodeChunk(c.FourC, data)...)
+	}
+	add("EXIF")
+	add("XMP ")
+	binary.LittleEndian.PutUint32(result[4:8], uint32(len(result)-8))
+	if dryRun {
+		return changes, nil
+	}
+	err = w.Replace(func(writer io.Writer) error {
+		_, err := writer.Write(result)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// newVP8X returns the content of a VP8X chunk for a file in the simple format, using the canvas size of the VP8 or
+// VP8L chunk.
+func newVP8X(chunks []Chunk) ([]byte, error) {
+	for _, c := range chunks {
+		if c.FourC != "VP8 " && c.FourC != "VP8L" {
+			continue
+		}
+		data, err := c.GetData()
+		if err != nil {
+			return nil, err
+		}
+		var width, height uint32
+		var flags byte
+		if c.FourC == "VP8 " {
+			if len(data) < 10 || !bytes.Equal(data[3:6], []byte{0x9D, 0x01, 0x2A}) {
+				return nil, fmt.Errorf("invalid VP8 frame header")
+			}
+			width = uint32(binary.LittleEndian.Uint16(data[6:8]) & 0x3FFF)
+			height = uint32(binary.LittleEndian.Uint16(data[8:10]) & 0x3FFF)
+		} else {
+			if len(data) < 5 || data[0] != 0x2F {
+				return nil, fmt.Errorf("invalid VP8L signature")
+			}
+			bits := binary.LittleEndian.Uint32(data[1:5])
+			width = bits&0x3FFF + 1
+			height = (bits>>14)&0x3FFF + 1
+			if bits&(1<<28) != 0 {
+				flags |= 0x10
+			}
+		}
+		if width == 0 || height == 0 {
+			return nil, fmt.Errorf("invalid canvas size: %dx%d", width, height)
+		}
+		result := make([]byte, 10)
+		result[0] = flags
+		copy(result[4:7], binary.LittleEndian.AppendUint32(nil, width-1))
+		copy(result[7:10], binary.LittleEndian.AppendUint32(nil, height-1))
+		return result, nil
+	}
+	return nil, fmt.Errorf("image data not found")
+}
+
+// exifData returns the content of an EXIF chunk with the "Exif\0\0" prefix, which some files leave out.
+func exifData(data []byte) []byte {
+	return append([]byte(shared.ExifHeader), bytes.TrimPrefix(data, []byte(shared.ExifHeader))...)
+}
+
+// SetExifFields rewrites the file with fields, such as "exif.Artist", written to the EXIF chunk.  It also returns the
+// names of the changed tags, and nothing is written if there is none.  If dryRun is true, the changes are returned
+// without writing them.
+func SetExifFields(r io.ReaderAt, size int64, w parser.Writer, fields []parser.Field, dryRun bool) ([]parser.Change, []string, error) {
+	chunks, err := GetChunks(r, size)
+	if err != nil {
+		return nil, nil, err
+	}
+	var raw []byte
+	index := -1
+	for i, c := range chunks {
+		if c.FourC == "EXIF" {
+			data, err := c.GetData()
+			if err != nil {
+				return nil, nil, err
+			}
+			raw = exifData(data)
+			index = i
+			break
+		}
+	}
+	exif, changed, err := shared.SetExifTags(raw, fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(changed) == 0 {
+		return nil, nil, nil
+	}
+	if exif != nil {
+		changes, err := ImportMetadata(r, size, w, &parser.Metadata{Exif: exif[len(shared.ExifHeader):]}, dryRun)
+		return changes, changed, err
+	}
+	changes := []parser.Change{{
+		Operation: parser.RemoveOperation,
+		Target:    "EXIF chunk",
+		Offset:    chunks[index].StartAt,
+		Size:      8 + int64(chunks[index].Size),
+	}}
+	if dryRun {
+		return changes, changed, nil
+	}
+	err = ClearMetadata(w, chunks, map[int][]byte{index: nil})
+	if err != nil {
+		return nil, nil, err
+	}
+	return changes, changed, nil
+}
@@ -0,0 +1,30 @@
+package webp
+
+import (
+	"io"
+	"jch-metadata/internal/parser"
+)
+
+// essenceChunks are the chunks storing the image: lossy (VP8) and lossless (VP8L) bitstreams, the alpha channel and
+// the frames of animations.
+var essenceChunks = map[string]bool{
+	"VP8 ": true,
+	"VP8L": true,
+	"ALPH": true,
+	"ANMF": true,
+}
+
+// EssenceRanges returns the data of the chunks storing the image.
+func EssenceRanges(r io.ReaderAt, size int64) ([]parser.Range, error) {
+	chunks, err := GetChunks(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var result []parser.Range
+	for _, c := range chunks {
+		if essenceChunks[c.FourC] {
+			result = append(result, parser.Range{Offset: c.StartAt + 8, Size: int64(c.Size)})
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,83 @@
+package webp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+	"strings"
+)
+
+// Verify checks that the RIFF size matches the length of the file, that chunks fit in the file, that the file
+// contains image data and that the flags of the VP8X chunk match the chunks in the file.
+func Verify(r io.ReaderAt, size int64) ([]parser.Problem, error) {
+	var problems []parser.Problem
+	header := make([]byte, 12)
+	_, err := r.ReadAt(header, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	end := int64(binary.LittleEndian.Uint32(header[4:8])) + 8
+	if end != size {
+		problems = append(problems, parser.NewError("RIFF header", 0, "RIFF size %d doesn't match file length %d", end-8, size-8))
+	}
+	if end > size {
+		end = size
+	}
+
+	var vp8xFlags *byte
+	found := make(map[string]bool)
+	offset := int64(12)
+	for offset < end {
+		if offset+8 > end {
+			problems = append(problems, parser.NewError("file", offset, "%d bytes after the last chunk are not a chunk", end-offset))
+			break
+		}
+		chunkHeader := make([]byte, 8)
+		_, err = r.ReadAt(chunkHeader, offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		fourC := strings.Replace(string(chunkHeader[0:4]), "\x00", " ", -1)
+		chunkSize := int64(binary.LittleEndian.Uint32(chunkHeader[4:8]))
+		target := fmt.Sprintf("%s chunk", strings.TrimSpace(fourC))
+		if offset+8+chunkSize > end {
+			problems = append(problems, parser.NewError(target, offset, "chunk size %d overflows the RIFF data", chunkSize))
+			break
+		}
+		if fourC == "VP8X" {
+			if offset != 12 {
+				problems = append(problems, parser.NewError(target, offset, "VP8X chunk should be the first chunk"))
+			}
+			if chunkSize < 10 {
+				problems = append(problems, parser.NewError(target, offset, "VP8X chunk should have 10 bytes"))
+			} else if vp8xFlags == nil {
+				flags := make([]byte, 1)
+				_, err = r.ReadAt(flags, offset+8)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read file: %w", err)
+				}
+				vp8xFlags = &flags[0]
+			}
+		}
+		found[fourC] = true
+		offset += 8 + chunkSize + chunkSize&1
+	}
+
+	if !found["VP8 "] && !found["VP8L"] && !found["ANMF"] {
+		problems = append(problems, parser.NewError("file", 12, "image data not found"))
+	}
+	if vp8xFlags != nil {
+		for fourC, flag := range map[string]byte{"ICCP": 0x20, "EXIF": 0x08, "XMP ": 0x04} {
+			name := strings.TrimSpace(fourC)
+			if *vp8xFlags&flag != 0 && !found[fourC] {
+				problems = append(problems, parser.NewWarning("VP8X chunk", 12, "%s flag is set but there is no %s chunk", name, name))
+			} else if *vp8xFlags&flag == 0 && found[fourC] {
+				problems = append(problems, parser.NewWarning("VP8X chunk", 12, "%s chunk is ignored because %s flag is not set", name, name))
+			}
+		}
+	} else if found["ICCP"] || found["EXIF"] || found["XMP "] {
+		problems = append(problems, parser.NewWarning("file", 12, "metadata chunks are ignored without a VP8X chunk"))
+	}
+	return problems, nil
+}
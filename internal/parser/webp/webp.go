@@ -4,95 +4,181 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"jch-metadata/internal/output"
+	"jch-metadata/internal/document"
 	"jch-metadata/internal/parser"
 	"jch-metadata/internal/parser/shared"
-	"os"
+	"strings"
 )
 
 var Parser = parser.Parser{
 	Name:      "Webp",
 	Container: false,
-	Support: func(file *os.File, startOffset int64, length int64) (bool, error) {
-		return IsWebp(file, startOffset, length)
+	Support: func(r io.ReaderAt, size int64) (bool, error) {
+		return IsWebp(r, size)
 	},
-	Handle: func(file *os.File, action parser.Action, startOffset int64, length int64, parsers []parser.Parser) error {
-		chunks, err := GetChunks(file, startOffset, length)
+	Handle: func(input *parser.Input, action parser.Action, parsers []parser.Parser, options parser.Options) (*document.Document, error) {
+		if action == parser.VerifyAction {
+			problems, err := Verify(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeProblems(problems), nil
+		}
+		if action == parser.AuditAction {
+			metadata, err := ExportMetadata(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			findings, err := shared.AuditMetadata(metadata)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeFindings(findings, options.Policy), nil
+		}
+		if action == parser.HashAction {
+			ranges, err := EssenceRanges(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			return parser.DescribeHash(input.Reader, ranges)
+		}
+		chunks, err := GetChunks(input.Reader, input.Size)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if action == parser.ShowAction {
+			result := Metadata{}
 			for _, c := range chunks {
 				if c.FourC == "EXIF" {
-					ifds, err := c.GetExif()
+					result.IFDs, err = c.GetExif()
 					if err != nil {
-						return err
+						return nil, err
 					}
-					shared.PrintExif(startOffset > 0, ifds)
-				} else if c.FourC == "XMP" {
+				} else if c.FourC == "XMP " {
 					xmp, err := c.GetXMP()
 					if err != nil {
-						return err
+						return nil, err
 					}
-					output.PrintHeader(startOffset > 0, "XMP")
-					output.PrintMultiline(startOffset > 0, xmp)
-					output.Println(startOffset > 0)
+					result.XMP = append(result.XMP, xmp)
 				} else if c.FourC == "ICCP" {
-					icc, err := c.GetICC()
+					result.ICCProfile, err = c.GetICC()
 					if err != nil {
-						return err
+						return nil, err
 					}
-					shared.PrintICC(startOffset > 0, icc)
 				}
 			}
+			return result.Describe(), nil
 		} else if action == parser.ClearAction {
-			hasMetadata := false
-			for _, c := range chunks {
-				if c.IsMetadata() {
-					hasMetadata = true
-					break
+			var changes []parser.Change
+			replaced := make(map[int][]byte)
+			var removedTags, removedChunks int
+			for i, c := range chunks {
+				names := c.SelectorNames()
+				if !c.IsMetadata() {
+					continue
+				} else if options.Selector.Removes(names...) && !(c.FourC == "EXIF" && options.Selector.KeepsPart(names...)) {
+					replaced[i] = nil
+					removedChunks++
+					changes = append(changes, parser.Change{
+						Operation: parser.RemoveOperation,
+						Target:    fmt.Sprintf("%s chunk", strings.TrimSpace(c.FourC)),
+						Offset:    c.StartAt,
+						Size:      8 + int64(c.Size),
+					})
+				} else if c.FourC == "EXIF" {
+					data, err := c.GetData()
+					if err != nil {
+						return nil, err
+					}
+					exif, removed, err := shared.RemoveExifTags(exifData(data), options.Selector, "webp")
+					if err != nil {
+						return nil, fmt.Errorf("error rewriting EXIF chunk: %w", err)
+					}
+					if len(removed) == 0 {
+						continue
+					}
+					replaced[i] = exif
+					removedTags += len(removed)
+					change := parser.Change{
+						Operation: parser.RemoveOperation,
+						Target:    "EXIF chunk",
+						Offset:    c.StartAt,
+						Size:      8 + int64(c.Size),
+					}
+					if exif != nil {
+						change.Operation = parser.RewriteOperation
+						change.Target = fmt.Sprintf("EXIF chunk without %s", strings.Join(removed, ", "))
+					} else {
+						removedChunks++
+					}
+					changes = append(changes, change)
 				}
 			}
-			if !hasMetadata {
-				fmt.Println("No metadata found in file!")
-				return nil
+			if options.DryRun {
+				return parser.DescribeChanges(changes), nil
+			}
+			if len(changes) == 0 {
+				return document.Message("No metadata found in file!"), nil
 			}
-			fmt.Println("Filtering metadata chunks...")
-			err := ClearMetadata(file, chunks)
+			err = ClearMetadata(input.Writer, chunks, replaced)
 			if err != nil {
-				return err
+				return nil, err
+			}
+			doc := &document.Document{}
+			if removedTags > 0 {
+				doc.AddMessage("%d EXIF tags have been removed!", removedTags)
+			}
+			if removedChunks > 0 {
+				doc.AddMessage("Metadata chunks have been removed!")
+			}
+			return doc, nil
+		} else if action == parser.SetAction {
+			changes, changed, err := SetExifFields(input.Reader, input.Size, input.Writer, options.Fields, options.DryRun)
+			if err != nil {
+				return nil, err
+			}
+			if len(changed) == 0 {
+				return document.Message("No EXIF tag has been changed"), nil
+			}
+			if options.DryRun {
+				return parser.DescribeChanges(changes), nil
+			}
+			return document.Message("EXIF tags have been written: %s", strings.Join(changed, ", ")), nil
+		} else if action == parser.CopyAction {
+			changes, err := ImportMetadata(input.Reader, input.Size, input.Writer, options.Source, options.DryRun)
+			if err != nil {
+				return nil, err
 			}
-			fmt.Println("Metadata chunks have been removed!")
+			return parser.DescribeCopy(options.Source, []string{parser.ExifKind, parser.XMPKind, parser.ICCKind}, changes, options.DryRun), nil
 		}
-		return nil
+		return document.Message("Unsupported action: %s", action), nil
+	},
+	Export: func(input *parser.Input) (*parser.Metadata, error) {
+		return ExportMetadata(input.Reader, input.Size)
 	},
 }
 
-func IsWebp(file *os.File, startOffset int64, length int64) (bool, error) {
+func IsWebp(r io.ReaderAt, length int64) (bool, error) {
 	magicBytes := make([]byte, 24)
-	_, err := file.ReadAt(magicBytes, startOffset)
+	_, err := r.ReadAt(magicBytes, 0)
 	if err != nil {
 		return false, fmt.Errorf("failed to read file: %w", err)
 	}
 	if string(magicBytes[0:4]) != "RIFF" {
 		return false, nil
 	}
-	size := binary.LittleEndian.Uint32(magicBytes[4:8])
-	if size != uint32(length-8) {
-		return false, nil
-	}
 	if string(magicBytes[8:12]) != "WEBP" {
 		return false, nil
 	}
 	return true, nil
 }
 
-func GetChunks(file *os.File, startOffset int64, length int64) ([]Chunk, error) {
+func GetChunks(r io.ReaderAt, length int64) ([]Chunk, error) {
 	var result []Chunk
-	offset := startOffset + 12
+	offset := int64(12)
 	for {
 		header := make([]byte, 8)
-		_, err := file.ReadAt(header, offset)
+		_, err := r.ReadAt(header, offset)
 		if err == io.EOF {
 			break
 		}
@@ -106,10 +192,11 @@ func GetChunks(file *os.File, startOffset int64, length int64) ([]Chunk, error)
 			FourC:   string(header[0:4]),
 			Size:    binary.LittleEndian.Uint32(header[4:8]),
 			StartAt: offset,
-			File:    file,
+			Reader:  r,
 		}
 		result = append(result, chunk)
-		offset += 8 + int64(chunk.Size)
+		// Chunks with odd size are followed by a padding byte
+		offset += 8 + int64(chunk.Size) + int64(chunk.Size&1)
 		if offset >= length {
 			break
 		}
@@ -117,64 +204,102 @@ func GetChunks(file *os.File, startOffset int64, length int64) ([]Chunk, error)
 	return result, nil
 }
 
-func ClearMetadata(file *os.File, chunks []Chunk) error {
+// ClearMetadata writes the file with the chunks at the indexes of replaced rewritten with their new data, or removed if
+// it is nil.  The ICC profile, EXIF and XMP flags of the VP8X chunk are updated to match the chunks that are left.
+func ClearMetadata(w parser.Writer, chunks []Chunk, replaced map[int][]byte) error {
+	present := make(map[string]bool)
+	for i, c := range chunks {
+		if data, found := replaced[i]; !found || data != nil {
+			present[c.FourC] = true
+		}
+	}
 	var result = []byte{0x52, 0x49, 0x46, 0x46, 0x00, 0x00, 0x00, 0x00, 0x57, 0x45, 0x42, 0x50}
-	for _, c := range chunks {
-		if c.IsMetadata() {
+	for i, c := range chunks {
+		data, found := replaced[i]
+		if found && data == nil {
 			continue
 		}
-		data, err := c.GetData()
-		if err != nil {
-			return err
+		if !found {
+			var err error
+			data, err = c.GetData()
+			if err != nil {
+				return err
+			}
+		}
+		if c.FourC == "VP8X" && len(data) > 0 {
+			data = append([]byte(nil), data...)
+			data[0] &^= 0x20 | 0x08 | 0x04
+			for fourC, flag := range map[string]byte{"ICCP": 0x20, "EXIF": 0x08, "XMP ": 0x04} {
+				if present[fourC] {
+					data[0] |= flag
+				}
+			}
 		}
-		length := make([]byte, 4)
-		binary.LittleEndian.PutUint32(length, c.Size)
-		result = append(result, []byte(c.FourC)...)
-		result = append(result, length...)
-		result = append(result, data...)
+		result = append(result, EncodeChunk(c.FourC, data)...)
 	}
 	binary.LittleEndian.PutUint32(result[4:8], uint32(len(result)-8))
-	tempFile, err := os.CreateTemp("", "jch_metadata_tmp_*")
-	if err != nil {
-		return err
-	}
-	_, err = tempFile.Write(result)
-	if err != nil {
+	return w.Replace(func(writer io.Writer) error {
+		_, err := writer.Write(result)
 		return err
+	})
+}
+
+// EncodeChunk returns a chunk with the given FourCC and data, adding a padding byte if the size is odd.
+func EncodeChunk(fourC string, data []byte) []byte {
+	result := append([]byte(fourC), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(result[4:8], uint32(len(data)))
+	result = append(result, data...)
+	if len(data)%2 == 1 {
+		result = append(result, 0)
 	}
-	err = tempFile.Close()
-	if err != nil {
-		return err
+	return result
+}
+
+type Metadata struct {
+	IFDs       []shared.IFD
+	XMP        []string
+	ICCProfile *shared.Profile
+}
+
+func (m *Metadata) Describe() *document.Document {
+	doc := &document.Document{}
+	doc.Sections = append(doc.Sections, shared.ExifSections(m.IFDs)...)
+	if m.XMP != nil {
+		xmp := doc.AddSection("xmp", "XMP")
+		for i, s := range m.XMP {
+			xmp.AddText(fmt.Sprintf("%d", i), "", s)
+		}
 	}
-	err = os.Rename(tempFile.Name(), file.Name())
-	if err != nil {
-		return err
+	if m.ICCProfile != nil {
+		doc.Sections = append(doc.Sections, shared.ICCSection(m.ICCProfile))
 	}
-	return nil
+	return doc
 }
 
 type Chunk struct {
 	FourC   string
 	Size    uint32
 	StartAt int64
-	File    *os.File
+	Reader  io.ReaderAt
 }
 
 func (c *Chunk) GetData() ([]byte, error) {
 	data := make([]byte, c.Size)
-	_, err := c.File.ReadAt(data, c.StartAt+8)
+	_, err := c.Reader.ReadAt(data, c.StartAt+8)
 	if err != nil {
 		return nil, fmt.Errorf("error reading data at offset %d: %w", c.StartAt+4, err)
 	}
 	return data, nil
 }
 
+// GetExif returns the IFDs of the EXIF chunk.  The chunk holds TIFF data, optionally preceded by the "Exif\0\0"
+// prefix written by some encoders.
 func (c *Chunk) GetExif() ([]shared.IFD, error) {
 	data, err := c.GetData()
 	if err != nil {
 		return nil, err
 	}
-	return shared.ParseExif(data[:]), nil
+	return shared.ParseExif(exifData(data))
 }
 
 func (c *Chunk) GetXMP() (string, error) {
@@ -196,3 +321,16 @@ func (c *Chunk) GetICC() (*shared.Profile, error) {
 func (c *Chunk) IsMetadata() bool {
 	return c.FourC == "EXIF" || c.FourC == "XMP " || c.FourC == "ICCP"
 }
+
+// SelectorNames returns the names used by parser.Selector to choose this chunk.
+func (c *Chunk) SelectorNames() []string {
+	switch c.FourC {
+	case "EXIF":
+		return []string{"exif", "webp.exif"}
+	case "XMP ":
+		return []string{"xmp", "webp.xmp"}
+	case "ICCP":
+		return []string{"icc", "webp.icc"}
+	}
+	return []string{"webp." + strings.ToLower(strings.TrimSpace(c.FourC))}
+}
@@ -0,0 +1,171 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"jch-metadata/internal/safewrite"
+	"os"
+	"path/filepath"
+)
+
+// Writer modifies content for actions such as clear.  Small changes are written in place through WriteAt while
+// Replace rewrites the whole content.
+type Writer interface {
+	io.WriterAt
+	Replace(write func(w io.Writer) error) error
+}
+
+var ErrReplaceNested = errors.New("nested content can't be larger than its original size")
+
+// FileWriter writes changes to a file on disk.  The modification time of the file is preserved and changes written
+// in place are synced to disk by Flush.  If Backup is true, a copy of the original file is kept before the first
+// change.  Replace swaps File for the new file, so File must be used to read the content after a change.
+type FileWriter struct {
+	File     *os.File
+	Backup   bool
+	original os.FileInfo
+	// written is true if content has been written in place since the last Flush
+	written bool
+}
+
+func (w *FileWriter) WriteAt(p []byte, off int64) (int, error) {
+	err := w.prepare()
+	if err != nil {
+		return 0, err
+	}
+	w.written = true
+	return w.File.WriteAt(p, off)
+}
+
+// Replace writes the new content to a temporary file that is renamed over the file, then opens the new file.
+func (w *FileWriter) Replace(write func(w io.Writer) error) error {
+	err := w.prepare()
+	if err != nil {
+		return err
+	}
+	err = safewrite.Replace(w.File.Name(), write, safewrite.Options{})
+	if err != nil {
+		return err
+	}
+	// Changes written in place went to the replaced file
+	w.written = false
+	file, err := os.OpenFile(w.File.Name(), os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("error opening replaced file: %w", err)
+	}
+	old := w.File
+	w.File = file
+	err = old.Close()
+	if err != nil {
+		return fmt.Errorf("error closing replaced file: %w", err)
+	}
+	return nil
+}
+
+// Flush syncs the changes written in place to disk and restores the modification time of the file.  It is called
+// once all changes are written.
+func (w *FileWriter) Flush() error {
+	if !w.written {
+		return nil
+	}
+	w.written = false
+	err := w.File.Sync()
+	if err != nil {
+		return fmt.Errorf("error syncing file: %w", err)
+	}
+	err = safewrite.RestoreModTime(w.File.Name(), w.original)
+	if err != nil {
+		return fmt.Errorf("error preserving modification time: %w", err)
+	}
+	return nil
+}
+
+// Close flushes the changes and closes the current file.
+func (w *FileWriter) Close() error {
+	err := w.Flush()
+	closeErr := w.File.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// prepare records the original file and keeps its backup before the first change.
+func (w *FileWriter) prepare() error {
+	if w.original != nil {
+		return nil
+	}
+	fileInfo, err := w.File.Stat()
+	if err != nil {
+		return fmt.Errorf("error retrieving file stat: %w", err)
+	}
+	if w.Backup {
+		target, err := filepath.EvalSymlinks(w.File.Name())
+		if err != nil {
+			return fmt.Errorf("error resolving %s: %w", w.File.Name(), err)
+		}
+		err = safewrite.Backup(target)
+		if err != nil {
+			return err
+		}
+	}
+	w.original = fileInfo
+	return nil
+}
+
+// Buffer is content stored in memory.  It can be used as both the Reader and the Writer of an Input.
+type Buffer struct {
+	Data []byte
+}
+
+func (b *Buffer) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(b.Data).ReadAt(p, off)
+}
+
+func (b *Buffer) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(b.Data)) {
+		return 0, fmt.Errorf("writing %d bytes at offset %d is out of bounds", len(p), off)
+	}
+	return copy(b.Data[off:], p), nil
+}
+
+func (b *Buffer) Replace(write func(w io.Writer) error) error {
+	var result bytes.Buffer
+	err := write(&result)
+	if err != nil {
+		return err
+	}
+	b.Data = result.Bytes()
+	return nil
+}
+
+type sectionWriter struct {
+	writer Writer
+	offset int64
+	size   int64
+}
+
+func (w *sectionWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > w.size {
+		return 0, fmt.Errorf("writing %d bytes at offset %d is out of bounds", len(p), off)
+	}
+	return w.writer.WriteAt(p, w.offset+off)
+}
+
+// Replace writes the new content over the section.  The container keeps its structure, so the content can't grow
+// and the rest of the section is filled with zeros.
+func (w *sectionWriter) Replace(write func(w io.Writer) error) error {
+	var result bytes.Buffer
+	err := write(&result)
+	if err != nil {
+		return err
+	}
+	if int64(result.Len()) > w.size {
+		return ErrReplaceNested
+	}
+	result.Write(make([]byte, w.size-int64(result.Len())))
+	_, err = w.writer.WriteAt(result.Bytes(), w.offset)
+	return err
+}
@@ -0,0 +1,306 @@
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"jch-metadata/internal/document"
+	"jch-metadata/internal/parser"
+	"time"
+)
+
+// MaxEntrySize is the size of the largest compressed entry that is decompressed in memory to be parsed.
+const MaxEntrySize = 64 << 20
+
+var Parser = parser.Parser{
+	Name:      "ZIP",
+	Container: true,
+	Support: func(r io.ReaderAt, size int64) (bool, error) {
+		return IsZip(r)
+	},
+	Handle: func(input *parser.Input, action parser.Action, parsers []parser.Parser, options parser.Options) (*document.Document, error) {
+		if action == parser.ShowAction {
+			metadata, err := GetMetadata(input.Reader, input.Size)
+			if err != nil {
+				return nil, err
+			}
+			doc := &document.Document{}
+			err = metadata.Describe(doc, input, parsers, options)
+			if err != nil {
+				return nil, err
+			}
+			return doc, nil
+		}
+		return nil, fmt.Errorf("%w for ZIP archives: %s", parser.ErrUnsupportedAction, action)
+	},
+	Items: func(input *parser.Input) ([]*parser.Input, error) {
+		metadata, err := GetMetadata(input.Reader, input.Size)
+		if err != nil {
+			return nil, err
+		}
+		return metadata.Items(input)
+	},
+}
+
+func IsZip(r io.ReaderAt) (bool, error) {
+	magicBytes := make([]byte, 4)
+	_, err := r.ReadAt(magicBytes, 0)
+	if err != nil {
+		return false, fmt.Errorf("failed to read file: %w", err)
+	}
+	// An empty archive only has the end of central directory record
+	return bytes.Equal(magicBytes, []byte{0x50, 0x4B, 0x03, 0x04}) || bytes.Equal(magicBytes, []byte{0x50, 0x4B, 0x05, 0x06}), nil
+}
+
+type Metadata struct {
+	Comment string
+	Entries []Entry
+	file    *zip.Reader
+}
+
+type Entry struct {
+	Name             string
+	Comment          string
+	Modified         time.Time
+	Method           uint16
+	CompressedSize   uint64
+	UncompressedSize uint64
+	Extra            Extra
+}
+
+// Extra holds the values of the extra fields of an entry that record times and the owner of the file.
+type Extra struct {
+	ModTime    time.Time
+	AccessTime time.Time
+	CreateTime time.Time
+	UID        *uint64
+	GID        *uint64
+}
+
+func GetMetadata(r io.ReaderAt, size int64) (*Metadata, error) {
+	file, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ZIP archive: %w", err)
+	}
+	result := Metadata{
+		Comment: file.Comment,
+		file:    file,
+	}
+	for _, f := range file.File {
+		result.Entries = append(result.Entries, Entry{
+			Name:             f.Name,
+			Comment:          f.Comment,
+			Modified:         f.Modified,
+			Method:           f.Method,
+			CompressedSize:   f.CompressedSize64,
+			UncompressedSize: f.UncompressedSize64,
+			Extra:            ParseExtra(f.Extra),
+		})
+	}
+	return &result, nil
+}
+
+// ParseExtra reads the extended timestamp (0x5455), NTFS (0x000A) and Unix (0x000D, 0x5855 and 0x7875) extra fields.
+func ParseExtra(extra []byte) Extra {
+	result := Extra{}
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if 4+size > len(extra) {
+			break
+		}
+		data := extra[4 : 4+size]
+		extra = extra[4+size:]
+		switch id {
+		case 0x5455:
+			if len(data) < 1 {
+				continue
+			}
+			// The central directory only stores the modification time even if the flags list more values
+			times := []*time.Time{&result.ModTime, &result.AccessTime, &result.CreateTime}
+			offset := 1
+			for i, t := range times {
+				if data[0]&(1<<i) == 0 || offset+4 > len(data) {
+					continue
+				}
+				*t = time.Unix(int64(int32(binary.LittleEndian.Uint32(data[offset:offset+4]))), 0).UTC()
+				offset += 4
+			}
+		case 0x000A:
+			for offset := 4; offset+4 <= len(data); {
+				tag := binary.LittleEndian.Uint16(data[offset : offset+2])
+				tagSize := int(binary.LittleEndian.Uint16(data[offset+2 : offset+4]))
+				offset += 4
+				if tag == 1 && tagSize >= 24 && offset+24 <= len(data) {
+					result.ModTime = ntfsTime(data[offset : offset+8])
+					result.AccessTime = ntfsTime(data[offset+8 : offset+16])
+					result.CreateTime = ntfsTime(data[offset+16 : offset+24])
+				}
+				offset += tagSize
+			}
+		case 0x000D, 0x5855:
+			if len(data) >= 8 {
+				result.AccessTime = time.Unix(int64(binary.LittleEndian.Uint32(data[0:4])), 0).UTC()
+				result.ModTime = time.Unix(int64(binary.LittleEndian.Uint32(data[4:8])), 0).UTC()
+			}
+			if len(data) >= 12 {
+				uid := uint64(binary.LittleEndian.Uint16(data[8:10]))
+				gid := uint64(binary.LittleEndian.Uint16(data[10:12]))
+				result.UID, result.GID = &uid, &gid
+			}
+		case 0x7875:
+			if len(data) < 3 || data[0] != 1 {
+				continue
+			}
+			uidSize := int(data[1])
+			if 2+uidSize+1 > len(data) {
+				continue
+			}
+			uid := littleEndian(data[2 : 2+uidSize])
+			gidSize := int(data[2+uidSize])
+			if 3+uidSize+gidSize > len(data) {
+				continue
+			}
+			gid := littleEndian(data[3+uidSize : 3+uidSize+gidSize])
+			result.UID, result.GID = &uid, &gid
+		}
+	}
+	return result
+}
+
+// ntfsTime converts the number of 100 nanoseconds since 1601 to a time.
+func ntfsTime(value []byte) time.Time {
+	ticks := binary.LittleEndian.Uint64(value)
+	return time.Date(1601, time.January, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(ticks/10000) * time.Millisecond)
+}
+
+func littleEndian(value []byte) uint64 {
+	result := uint64(0)
+	for i := len(value) - 1; i >= 0; i-- {
+		result = result<<8 | uint64(value[i])
+	}
+	return result
+}
+
+// Describe adds the archive comment and the entries to doc.  Supported entries are parsed into nested documents.
+func (m *Metadata) Describe(doc *document.Document, input *parser.Input, parsers []parser.Parser, options parser.Options) error {
+	archive := doc.AddSection("archive", "Archive")
+	archive.AddText("comment", "Comment", m.Comment)
+	archive.AddInt("entries", "Entries", int64(len(m.Entries)))
+	for i, e := range m.Entries {
+		entry := doc.AddSection(fmt.Sprintf("entry.%d", i), "Entry")
+		entry.AddString("name", "Name", e.Name)
+		entry.AddString("comment", "Comment", e.Comment)
+		entry.AddTime("modified", "Modified", e.Modified)
+		entry.AddString("method", "Method", MethodName(e.Method))
+		entry.AddUint("compressedSize", "Compressed Size", e.CompressedSize)
+		entry.AddUint("uncompressedSize", "Uncompressed Size", e.UncompressedSize)
+		if !e.Extra.ModTime.IsZero() {
+			entry.AddTime("extra.modTime", "Extra Modification Time", e.Extra.ModTime)
+		}
+		if !e.Extra.AccessTime.IsZero() {
+			entry.AddTime("extra.accessTime", "Extra Access Time", e.Extra.AccessTime)
+		}
+		if !e.Extra.CreateTime.IsZero() {
+			entry.AddTime("extra.createTime", "Extra Creation Time", e.Extra.CreateTime)
+		}
+		if e.Extra.UID != nil {
+			entry.AddUint("extra.uid", "UID", *e.Extra.UID)
+			entry.AddUint("extra.gid", "GID", *e.Extra.GID)
+		}
+		if m.file.File[i].FileInfo().IsDir() {
+			continue
+		}
+		if m.file.File[i].Flags&0x1 != 0 {
+			entry.AddString("content", "Content", "Encrypted")
+			continue
+		}
+		entryInput, err := m.entryInput(input, i)
+		if errors.Is(err, zip.ErrAlgorithm) {
+			entry.AddString("content", "Content", "Unsupported compression method")
+			continue
+		} else if err != nil {
+			return fmt.Errorf("error while processing entry [%s]: %w", e.Name, err)
+		}
+		if entryInput == nil {
+			entry.AddString("content", "Content", "Entry is too large to be parsed")
+			continue
+		}
+		err = parser.DescribeItem(entry, parsers, entryInput, parser.ShowAction, options)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryInput returns the content of the entry at index.  Stored entries are read from the archive while compressed
+// entries are decompressed in memory.  Entries can't be modified because their CRC would no longer match.  It
+// returns nil if the entry is larger than MaxEntrySize.
+func (m *Metadata) entryInput(input *parser.Input, index int) (*parser.Input, error) {
+	f := m.file.File[index]
+	if f.Method == zip.Store {
+		offset, err := f.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		item := input.Item("entry", index, f.Name, offset, int64(f.UncompressedSize64))
+		item.Writer = nil
+		return item, nil
+	}
+	if f.UncompressedSize64 > MaxEntrySize {
+		return nil, nil
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, MaxEntrySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxEntrySize {
+		return nil, nil
+	}
+	return input.ItemData("entry", index, f.Name, data), nil
+}
+
+// Items returns the content of the entries that can be parsed.
+func (m *Metadata) Items(input *parser.Input) ([]*parser.Input, error) {
+	var result []*parser.Input
+	for i, f := range m.file.File {
+		if f.FileInfo().IsDir() || f.Flags&0x1 != 0 {
+			continue
+		}
+		item, err := m.entryInput(input, i)
+		if errors.Is(err, zip.ErrAlgorithm) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error while processing entry [%s]: %w", f.Name, err)
+		}
+		if item != nil {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+func MethodName(method uint16) string {
+	switch method {
+	case zip.Store:
+		return "Stored"
+	case zip.Deflate:
+		return "Deflated"
+	case 12:
+		return "BZIP2"
+	case 14:
+		return "LZMA"
+	case 93:
+		return "Zstandard"
+	}
+	return fmt.Sprintf("Unknown (%d)", method)
+}
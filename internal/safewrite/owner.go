@@ -0,0 +1,32 @@
+//go:build !windows
+
+package safewrite
+
+import (
+	"os"
+	"syscall"
+)
+
+func copyOwner(file *os.File, fileInfo os.FileInfo) error {
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	current, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if currentStat, ok := current.Sys().(*syscall.Stat_t); ok && currentStat.Uid == stat.Uid && currentStat.Gid == stat.Gid {
+		return nil
+	}
+	return file.Chown(int(stat.Uid), int(stat.Gid))
+}
+
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
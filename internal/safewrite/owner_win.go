@@ -0,0 +1,11 @@
+//go:build windows
+
+package safewrite
+
+import "os"
+
+func copyOwner(file *os.File, fileInfo os.FileInfo) error {
+	return nil
+}
+
+func syncDir(dir string) {}
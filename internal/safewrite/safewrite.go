@@ -0,0 +1,144 @@
+// Package safewrite writes files atomically.  Content is written to a temporary file in the directory of the
+// target, synced to disk and renamed over the target, so the target is never left partially written.
+package safewrite
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BackupSuffix is appended to the name of the target to name its backup.
+const BackupSuffix = ".bak"
+
+type Options struct {
+	// Backup keeps a copy of the original content before it is replaced.
+	Backup bool
+}
+
+// Replace replaces the content of an existing file with the data written by write.  The mode, owner and
+// modification time of the original file are preserved.
+func Replace(path string, write func(w io.Writer) error, options Options) error {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fmt.Errorf("error resolving %s: %w", path, err)
+	}
+	fileInfo, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("error retrieving file stat: %w", err)
+	}
+	if options.Backup {
+		err = Backup(target)
+		if err != nil {
+			return err
+		}
+	}
+	return writeTemp(target, fileInfo.ModTime(), func(tempFile *os.File) error {
+		writer := bufio.NewWriter(tempFile)
+		err := write(writer)
+		if err != nil {
+			return err
+		}
+		err = writer.Flush()
+		if err != nil {
+			return fmt.Errorf("error flushing temporary file: %w", err)
+		}
+		return copyAttributes(tempFile, fileInfo)
+	})
+}
+
+// WriteFile creates or replaces the file at path with data.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	return writeTemp(path, time.Time{}, func(tempFile *os.File) error {
+		_, err := tempFile.Write(data)
+		if err != nil {
+			return err
+		}
+		return tempFile.Chmod(perm)
+	})
+}
+
+// Backup copies the file at path into a file with BackupSuffix, replacing any previous backup.
+func Backup(path string) error {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error retrieving file stat: %w", err)
+	}
+	source, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening file for backup: %w", err)
+	}
+	defer source.Close()
+	err = writeTemp(path+BackupSuffix, fileInfo.ModTime(), func(tempFile *os.File) error {
+		_, err := io.Copy(tempFile, source)
+		if err != nil {
+			return err
+		}
+		return copyAttributes(tempFile, fileInfo)
+	})
+	if err != nil {
+		return fmt.Errorf("error creating backup: %w", err)
+	}
+	return nil
+}
+
+// RestoreModTime sets the modification time of path back to the one in fileInfo after it has been modified in
+// place.
+func RestoreModTime(path string, fileInfo os.FileInfo) error {
+	return os.Chtimes(path, time.Now(), fileInfo.ModTime())
+}
+
+func copyAttributes(file *os.File, fileInfo os.FileInfo) error {
+	err := file.Chmod(fileInfo.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("error preserving file mode: %w", err)
+	}
+	err = copyOwner(file, fileInfo)
+	if err != nil {
+		return fmt.Errorf("error preserving file owner: %w", err)
+	}
+	return nil
+}
+
+// writeTemp writes a temporary file in the directory of path and renames it to path.  If modTime is not zero, it
+// becomes the modification time of the new file.
+func writeTemp(path string, modTime time.Time, write func(tempFile *os.File) error) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tempFile, err := os.CreateTemp(dir, "."+base+".tmp_*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+	tempName := tempFile.Name()
+	err = write(tempFile)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	closeErr := tempFile.Close()
+	if err == nil && closeErr != nil {
+		err = fmt.Errorf("error closing temporary file: %w", closeErr)
+	}
+	if err != nil {
+		_ = os.Remove(tempName)
+		return err
+	}
+	if !modTime.IsZero() {
+		err = os.Chtimes(tempName, time.Now(), modTime)
+		if err != nil {
+			_ = os.Remove(tempName)
+			return fmt.Errorf("error preserving modification time: %w", err)
+		}
+	}
+	err = os.Rename(tempName, path)
+	if err != nil {
+		_ = os.Remove(tempName)
+		return fmt.Errorf("error renaming file: %w", err)
+	}
+	syncDir(dir)
+	return nil
+}
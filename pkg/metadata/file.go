@@ -0,0 +1,127 @@
+package metadata
+
+import (
+	"fmt"
+	"io"
+	"jch-metadata/internal/parser"
+	"os"
+)
+
+// File is the input of every operation.  It can be backed by a file on disk, a byte slice or any io.ReaderAt.
+type File struct {
+	Name   string
+	reader io.ReaderAt
+	size   int64
+	writer parser.Writer
+	file   *os.File
+	buffer *parser.Buffer
+}
+
+// Open opens a file on disk for reading.
+func Open(name string) (*File, error) {
+	return openFile(name, os.O_RDONLY)
+}
+
+// OpenWritable opens a file on disk for reading and writing, which is required by Clear.
+func OpenWritable(name string) (*File, error) {
+	return openFile(name, os.O_RDWR)
+}
+
+func openFile(name string, flag int) (*File, error) {
+	file, err := os.OpenFile(name, flag, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("error retrieving file stat: %w", err)
+	}
+	f := &File{
+		Name:   name,
+		reader: file,
+		size:   fileInfo.Size(),
+		file:   file,
+	}
+	if flag&os.O_RDWR != 0 {
+		f.writer = &parser.FileWriter{File: file}
+	}
+	return f, nil
+}
+
+// NewFile creates a read-only File from the first size bytes of r.
+func NewFile(name string, r io.ReaderAt, size int64) *File {
+	return &File{
+		Name:   name,
+		reader: r,
+		size:   size,
+	}
+}
+
+// NewBuffer creates a writable File stored in memory.  The result of Clear can be retrieved by Bytes.
+func NewBuffer(name string, data []byte) *File {
+	buffer := &parser.Buffer{Data: data}
+	return &File{
+		Name:   name,
+		reader: buffer,
+		size:   int64(len(data)),
+		writer: buffer,
+		buffer: buffer,
+	}
+}
+
+// Size returns the size of the content, which changes when a File created by NewBuffer is rewritten.
+func (f *File) Size() int64 {
+	if f.buffer != nil {
+		return int64(len(f.buffer.Data))
+	}
+	return f.size
+}
+
+// Bytes returns the current content of a File created by NewBuffer.
+func (f *File) Bytes() []byte {
+	if f.buffer == nil {
+		return nil
+	}
+	return f.buffer.Data
+}
+
+func (f *File) Close() error {
+	if writer, ok := f.writer.(*parser.FileWriter); ok {
+		return writer.Close()
+	}
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// refresh flushes the changes written to a file on disk and reads its new size.  The file is a new one if it has
+// been replaced.
+func (f *File) refresh() error {
+	writer, ok := f.writer.(*parser.FileWriter)
+	if !ok {
+		return nil
+	}
+	err := writer.Flush()
+	if err != nil {
+		return err
+	}
+	f.file = writer.File
+	f.reader = writer.File
+	fileInfo, err := f.file.Stat()
+	if err != nil {
+		return fmt.Errorf("error retrieving file stat: %w", err)
+	}
+	f.size = fileInfo.Size()
+	return nil
+}
+
+func (f *File) input() *parser.Input {
+	return &parser.Input{
+		Name:   f.Name,
+		Reader: f.reader,
+		Size:   f.Size(),
+		Writer: f.writer,
+	}
+}
@@ -0,0 +1,263 @@
+// Package metadata is the public API of jch-metadata.  It detects the format of a file and reads, clears, sets, copies,
+// compares, verifies, audits or extracts its metadata using the built-in parsers, or a subset of them selected by
+// NewRegistry.
+//
+// The API follows semantic versioning through Version.  Until version 1.0.0, exported identifiers may still be
+// removed or changed in a minor version.
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"jch-metadata/internal/document"
+	"jch-metadata/internal/parser"
+	"jch-metadata/internal/parser/builtin"
+	"strings"
+)
+
+const Version = "0.1.0"
+
+type (
+	Document  = document.Document
+	Section   = document.Section
+	Field     = document.Field
+	FieldType = document.Type
+)
+
+// FieldValue is a metadata value written by Set, such as "TITLE" for FLAC files or "exif.Artist" for JPEG files.
+type FieldValue struct {
+	Key   string
+	Value string
+}
+
+// DefaultPolicy are the categories of personal data flagged by Audit if no policy is given.
+var DefaultPolicy = append([]string(nil), parser.DefaultPolicy...)
+
+var ErrUnsupportedFormat = errors.New("unsupported file format")
+var ErrReadOnly = errors.New("file is not writable")
+
+// Registry holds the parsers that are tried in order when processing a file.
+type Registry struct {
+	parsers []parser.Parser
+}
+
+// NewRegistry returns a registry with the built-in parsers of formats, in the order of DefaultRegistry.  Formats are
+// matched case-insensitively against the format names returned by Formats or their first word, such as "mkv" for
+// "Mkv (Matroska)".  It returns an error wrapping ErrUnsupportedFormat if a format has no parser.
+func NewRegistry(formats ...string) (*Registry, error) {
+	for _, format := range formats {
+		found := false
+		for _, p := range builtin.Parsers {
+			found = found || matchFormat(format, p)
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+		}
+	}
+	var parsers []parser.Parser
+	for _, p := range builtin.Parsers {
+		for _, format := range formats {
+			if matchFormat(format, p) {
+				parsers = append(parsers, p)
+				break
+			}
+		}
+	}
+	return &Registry{parsers: parsers}, nil
+}
+
+// matchFormat returns true if format is the name of p or its first word.
+func matchFormat(format string, p parser.Parser) bool {
+	return strings.EqualFold(format, p.Name) || strings.EqualFold(format, strings.Fields(p.Name)[0])
+}
+
+// DefaultRegistry returns a registry with all built-in parsers.
+func DefaultRegistry() *Registry {
+	return &Registry{parsers: builtin.Parsers}
+}
+
+func (r *Registry) Formats() []string {
+	var result []string
+	for _, p := range r.parsers {
+		result = append(result, p.Name)
+	}
+	return result
+}
+
+// Detect returns the name of the format of f or an empty string if the format is not supported.
+func (r *Registry) Detect(f *File) (string, error) {
+	for _, p := range r.parsers {
+		supported, err := p.Support(f.reader, f.Size())
+		if err != nil {
+			return "", err
+		}
+		if supported {
+			return p.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// Read returns the metadata of f.
+func (r *Registry) Read(f *File) (*Document, error) {
+	return r.run(f, parser.ShowAction, parser.Options{})
+}
+
+// Clear removes the metadata of f.  The file must be opened by OpenWritable or created by NewBuffer.
+func (r *Registry) Clear(f *File) (*Document, error) {
+	if f.writer == nil {
+		return nil, ErrReadOnly
+	}
+	return r.run(f, parser.ClearAction, parser.Options{})
+}
+
+// PlanClear returns the changes that Clear would make to f without writing them.
+func (r *Registry) PlanClear(f *File) (*Document, error) {
+	return r.run(f, parser.ClearAction, parser.Options{DryRun: true})
+}
+
+// Set writes values into the metadata of f.  An empty value removes the field.  The file must be opened by
+// OpenWritable or created by NewBuffer.
+func (r *Registry) Set(f *File, values ...FieldValue) (*Document, error) {
+	if f.writer == nil {
+		return nil, ErrReadOnly
+	}
+	fields := make([]parser.Field, len(values))
+	for i, v := range values {
+		fields[i] = parser.Field{Key: v.Key, Value: v.Value}
+	}
+	return r.run(f, parser.SetAction, parser.Options{Fields: fields})
+}
+
+// Copy writes the metadata of from into to, including across formats such as JPEG EXIF data into a WebP file.
+// Metadata that the format of to can't store is skipped and reported in the returned document.  The file to must be
+// opened by OpenWritable or created by NewBuffer.
+func (r *Registry) Copy(from *File, to *File) (*Document, error) {
+	if to.writer == nil {
+		return nil, ErrReadOnly
+	}
+	format, err := r.Detect(from)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		return nil, ErrUnsupportedFormat
+	}
+	source, err := parser.ExportMetadata(r.parsers, from.input())
+	if err != nil {
+		return nil, err
+	}
+	return r.run(to, parser.CopyAction, parser.Options{Source: source})
+}
+
+// Diff returns the fields that were added, removed or changed between the metadata of before and after, which must
+// have the same format.
+func (r *Registry) Diff(before *File, after *File) (*Document, error) {
+	beforeDoc, err := r.Read(before)
+	if err != nil {
+		return nil, err
+	}
+	afterDoc, err := r.Read(after)
+	if err != nil {
+		return nil, err
+	}
+	if beforeDoc.Format != afterDoc.Format {
+		return nil, fmt.Errorf("can't compare %s with %s", beforeDoc.Format, afterDoc.Format)
+	}
+	return document.Diff(beforeDoc, afterDoc), nil
+}
+
+// Verify checks the structure of f and returns the problems found, such as chunks with invalid CRC or boxes that
+// overflow their parent.
+func (r *Registry) Verify(f *File) (*Document, error) {
+	return r.run(f, parser.VerifyAction, parser.Options{})
+}
+
+// Audit reports the values of f that may leak personal data, such as GPS coordinates or source paths.  Values in a
+// category of policy, such as "location", are marked as violations.  DefaultPolicy is used if policy is nil.
+func (r *Registry) Audit(f *File, policy []string) (*Document, error) {
+	if policy == nil {
+		policy = DefaultPolicy
+	}
+	return r.run(f, parser.AuditAction, parser.Options{Policy: policy})
+}
+
+// Hash computes a digest of the media data of f, such as the scan data of a JPEG file or the audio frames of a FLAC
+// file, so files that only differ by their metadata have the same hash.
+func (r *Registry) Hash(f *File) (*Document, error) {
+	return r.run(f, parser.HashAction, parser.Options{})
+}
+
+// Extract writes the nested files of f, such as thumbnails and attachments, into outputDir.
+func (r *Registry) Extract(f *File, outputDir string) (*Document, error) {
+	return r.run(f, parser.ExtractAction, parser.Options{OutputDir: outputDir})
+}
+
+func (r *Registry) run(f *File, action parser.Action, options parser.Options) (*Document, error) {
+	doc, err := parser.StartParsing(r.parsers, f.input(), action, options)
+	if refreshErr := f.refresh(); err == nil {
+		err = refreshErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, ErrUnsupportedFormat
+	}
+	return doc, nil
+}
+
+// Read returns the metadata of f using the built-in parsers.
+func Read(f *File) (*Document, error) {
+	return DefaultRegistry().Read(f)
+}
+
+// Clear removes the metadata of f using the built-in parsers.
+func Clear(f *File) (*Document, error) {
+	return DefaultRegistry().Clear(f)
+}
+
+// PlanClear returns the changes that Clear would make to f using the built-in parsers.
+func PlanClear(f *File) (*Document, error) {
+	return DefaultRegistry().PlanClear(f)
+}
+
+// Set writes values into the metadata of f using the built-in parsers.
+func Set(f *File, values ...FieldValue) (*Document, error) {
+	return DefaultRegistry().Set(f, values...)
+}
+
+// Copy writes the metadata of from into to using the built-in parsers.
+func Copy(from *File, to *File) (*Document, error) {
+	return DefaultRegistry().Copy(from, to)
+}
+
+// Diff returns the differences between the metadata of before and after using the built-in parsers.
+func Diff(before *File, after *File) (*Document, error) {
+	return DefaultRegistry().Diff(before, after)
+}
+
+// Verify checks the structure of f using the built-in parsers.
+func Verify(f *File) (*Document, error) {
+	return DefaultRegistry().Verify(f)
+}
+
+// Audit reports the values of f that may leak personal data using the built-in parsers.
+func Audit(f *File, policy []string) (*Document, error) {
+	return DefaultRegistry().Audit(f, policy)
+}
+
+// Hash computes a digest of the media data of f using the built-in parsers.
+func Hash(f *File) (*Document, error) {
+	return DefaultRegistry().Hash(f)
+}
+
+// Extract writes the nested files of f into outputDir using the built-in parsers.
+func Extract(f *File, outputDir string) (*Document, error) {
+	return DefaultRegistry().Extract(f, outputDir)
+}
+
+// Detect returns the format of f using the built-in parsers.
+func Detect(f *File) (string, error) {
+	return DefaultRegistry().Detect(f)
+}